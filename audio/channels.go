@@ -0,0 +1,29 @@
+package audio
+
+// Deinterleave16 splits interleaved 16-bit stereo PCM into left and right mono streams.
+// Any trailing bytes that do not form a complete stereo frame are ignored.
+func Deinterleave16(data []byte) (left, right []byte) {
+	frames := len(data) / 4
+	left = make([]byte, frames*2)
+	right = make([]byte, frames*2)
+	for i := 0; i < frames; i++ {
+		copy(left[i*2:i*2+2], data[i*4:i*4+2])
+		copy(right[i*2:i*2+2], data[i*4+2:i*4+4])
+	}
+	return left, right
+}
+
+// Deinterleaver splits streamed 16-bit stereo PCM into left and right mono streams.
+// It keeps state between calls, so chunks may be split at arbitrary byte positions.
+type Deinterleaver struct {
+	pending []byte
+}
+
+// Split deinterleaves the complete stereo frames of the pending bytes and data, and holds
+// back an incomplete trailing frame until the next call
+func (d *Deinterleaver) Split(data []byte) (left, right []byte) {
+	data = append(d.pending, data...)
+	usable := len(data) - len(data)%4
+	d.pending = append([]byte(nil), data[usable:]...)
+	return Deinterleave16(data[:usable])
+}
@@ -0,0 +1,29 @@
+package audio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeinterleaverKeepsPartialFrames(t *testing.T) {
+	var stereo, wantLeft, wantRight []byte
+	for i := 0; i < 8; i++ {
+		left := []byte{byte(i), 0x10}
+		right := []byte{byte(i), 0x20}
+		stereo = append(stereo, left...)
+		stereo = append(stereo, right...)
+		wantLeft = append(wantLeft, left...)
+		wantRight = append(wantRight, right...)
+	}
+
+	for _, offset := range []int{1, 3, 5, 6, 13} {
+		var d Deinterleaver
+		left1, right1 := d.Split(stereo[:offset])
+		left2, right2 := d.Split(stereo[offset:])
+		left := append(left1, left2...)
+		right := append(right1, right2...)
+		if !bytes.Equal(left, wantLeft) || !bytes.Equal(right, wantRight) {
+			t.Errorf("split at %d: got left %x, right %x; want %x, %x", offset, left, right, wantLeft, wantRight)
+		}
+	}
+}
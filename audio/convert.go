@@ -0,0 +1,350 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Input sample encodings accepted by the Converter
+const (
+	EncodingPCM16   = "pcm16"
+	EncodingFloat32 = "float32"
+	EncodingMuLaw   = "mulaw"
+	EncodingALaw    = "alaw"
+)
+
+// TargetSampleRate is the sample rate the recognizer is configured for
+const TargetSampleRate = 16000
+
+// Format describes raw interleaved input audio
+type Format struct {
+	Encoding   string
+	SampleRate int
+	Channels   int
+}
+
+// bytesPerSample returns the size of one sample of the encoding
+func (f Format) bytesPerSample() int {
+	switch f.Encoding {
+	case EncodingFloat32:
+		return 4
+	case EncodingMuLaw, EncodingALaw:
+		return 1
+	}
+	return 2
+}
+
+// Validate checks that the format can be converted
+func (f Format) Validate() error {
+	switch f.Encoding {
+	case EncodingPCM16, EncodingFloat32, EncodingMuLaw, EncodingALaw:
+	default:
+		return fmt.Errorf("unsupported encoding %q", f.Encoding)
+	}
+	if f.SampleRate < 8000 || f.SampleRate > 192000 {
+		return fmt.Errorf("unsupported sample rate %d", f.SampleRate)
+	}
+	if f.Channels != 1 && f.Channels != 2 {
+		return fmt.Errorf("unsupported channel count %d", f.Channels)
+	}
+	return nil
+}
+
+// IsTarget reports whether the format already matches 16 kHz LINEAR16 with the given channel count
+func (f Format) IsTarget(channels int) bool {
+	return f.Encoding == EncodingPCM16 && f.SampleRate == TargetSampleRate && f.Channels == channels
+}
+
+// Converter turns streamed input audio into 16 kHz 16-bit LINEAR16, optionally downmixing stereo to mono.
+// It keeps state between calls, so chunks may be split at arbitrary byte positions.
+type Converter struct {
+	input       Format
+	outChannels int
+	pending     []byte
+	resamplers  []*Resampler
+}
+
+// NewConverter creates a converter producing outChannels channels at TargetSampleRate
+func NewConverter(input Format, outChannels int) (*Converter, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	if outChannels != 1 && outChannels != input.Channels {
+		return nil, fmt.Errorf("cannot convert %d input channels to %d output channels", input.Channels, outChannels)
+	}
+
+	resamplers := make([]*Resampler, outChannels)
+	for i := range resamplers {
+		resamplers[i] = NewResampler(input.SampleRate, TargetSampleRate)
+	}
+	return &Converter{input: input, outChannels: outChannels, resamplers: resamplers}, nil
+}
+
+// Convert converts a chunk of input audio and returns the LINEAR16 output available so far
+func (c *Converter) Convert(data []byte) []byte {
+	data = append(c.pending, data...)
+	frameBytes := c.input.bytesPerSample() * c.input.Channels
+	usable := len(data) - len(data)%frameBytes
+	c.pending = append([]byte(nil), data[usable:]...)
+	data = data[:usable]
+
+	frames := usable / frameBytes
+	channels := make([][]float64, c.outChannels)
+	for i := range channels {
+		channels[i] = make([]float64, frames)
+	}
+
+	size := c.input.bytesPerSample()
+	for frame := 0; frame < frames; frame++ {
+		base := frame * frameBytes
+		if c.outChannels == 1 && c.input.Channels == 2 {
+			// Downmix by averaging both channels
+			left := decodeSample(c.input.Encoding, data[base:base+size])
+			right := decodeSample(c.input.Encoding, data[base+size:base+2*size])
+			channels[0][frame] = (left + right) / 2
+			continue
+		}
+		for ch := 0; ch < c.outChannels; ch++ {
+			channels[ch][frame] = decodeSample(c.input.Encoding, data[base+ch*size:base+(ch+1)*size])
+		}
+	}
+
+	for ch := range channels {
+		channels[ch] = c.resamplers[ch].Process(channels[ch])
+	}
+	return c.encode(channels)
+}
+
+// Flush returns the output still held back by the resamplers at the end of the stream.
+// An incomplete trailing input frame is discarded and the converter starts over afterwards.
+func (c *Converter) Flush() []byte {
+	c.pending = nil
+	channels := make([][]float64, c.outChannels)
+	for ch := range channels {
+		channels[ch] = c.resamplers[ch].Flush()
+	}
+	return c.encode(channels)
+}
+
+// encode interleaves the output channels as LINEAR16.
+// All resamplers see the same number of input samples, so the channels have equal length.
+func (c *Converter) encode(channels [][]float64) []byte {
+	outFrames := len(channels[0])
+	out := make([]byte, outFrames*c.outChannels*2)
+	for frame := 0; frame < outFrames; frame++ {
+		for ch := 0; ch < c.outChannels; ch++ {
+			binary.LittleEndian.PutUint16(out[(frame*c.outChannels+ch)*2:], uint16(floatToPCM16(channels[ch][frame])))
+		}
+	}
+	return out
+}
+
+// decodeSample decodes one sample to the range [-1, 1]
+func decodeSample(encoding string, b []byte) float64 {
+	switch encoding {
+	case EncodingFloat32:
+		value := float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		if math.IsNaN(value) {
+			return 0
+		}
+		return math.Max(-1, math.Min(1, value))
+	case EncodingMuLaw:
+		return float64(MuLawDecode(b[0])) / 32768
+	case EncodingALaw:
+		return float64(ALawDecode(b[0])) / 32768
+	}
+	return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+}
+
+// floatToPCM16 converts a sample in [-1, 1] to a clamped 16-bit value
+func floatToPCM16(value float64) int16 {
+	scaled := math.Round(value * 32768)
+	if scaled > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if scaled < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(scaled)
+}
+
+// MuLawDecode decodes a G.711 mu-law byte to a 16-bit sample
+func MuLawDecode(b byte) int16 {
+	b = ^b
+	sign := b & 0x80
+	exponent := (b >> 4) & 0x07
+	mantissa := int(b & 0x0F)
+	sample := ((mantissa << 3) + 0x84) << exponent
+	sample -= 0x84
+	if sign != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// MuLawEncode encodes a 16-bit sample as a G.711 mu-law byte
+func MuLawEncode(sample int16) byte {
+	const bias, clip = 0x84, 32635
+	value := int(sample)
+	var sign byte
+	if value < 0 {
+		sign = 0x80
+		value = -value
+	}
+	if value > clip {
+		value = clip
+	}
+	value += bias
+	exponent := byte(7)
+	for mask := 0x4000; value&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := byte(value>>(exponent+3)) & 0x0F
+	return ^(sign | exponent<<4 | mantissa)
+}
+
+// ALawEncode encodes a 16-bit sample as a G.711 A-law byte
+func ALawEncode(sample int16) byte {
+	value := int(sample)
+	sign := byte(0x80)
+	if value < 0 {
+		sign = 0
+		value = -value - 1
+	}
+	if value > 32767 {
+		value = 32767
+	}
+	var exponent, mantissa byte
+	if value < 256 {
+		mantissa = byte(value>>4) & 0x0F
+	} else {
+		exponent = 1
+		for limit := 512; value >= limit && exponent < 7; limit <<= 1 {
+			exponent++
+		}
+		mantissa = byte(value>>(exponent+3)) & 0x0F
+	}
+	return (sign | exponent<<4 | mantissa) ^ 0x55
+}
+
+// ALawDecode decodes a G.711 A-law byte to a 16-bit sample
+func ALawDecode(b byte) int16 {
+	b ^= 0x55
+	sign := b & 0x80
+	exponent := (b >> 4) & 0x07
+	mantissa := int(b & 0x0F)
+	var sample int
+	if exponent == 0 {
+		sample = (mantissa << 4) + 8
+	} else {
+		sample = ((mantissa << 4) + 0x108) << (exponent - 1)
+	}
+	if sign != 0 {
+		return int16(sample)
+	}
+	return int16(-sample)
+}
+
+// resamplerHalfTaps is the half width of the interpolation kernel at unity bandwidth
+const resamplerHalfTaps = 8
+
+// Resampler converts a mono stream between sample rates with a windowed-sinc kernel.
+// When downsampling, the kernel is widened to low-pass the signal and avoid aliasing.
+type Resampler struct {
+	step     float64
+	cutoff   float64
+	halfTaps int
+	history  []float64
+	pos      float64
+}
+
+// NewResampler creates a resampler from inRate to outRate
+func NewResampler(inRate, outRate int) *Resampler {
+	cutoff := 1.0
+	if outRate < inRate {
+		cutoff = float64(outRate) / float64(inRate)
+	}
+	return &Resampler{
+		step:     float64(inRate) / float64(outRate),
+		cutoff:   cutoff,
+		halfTaps: int(math.Ceil(resamplerHalfTaps / cutoff)),
+	}
+}
+
+// Process resamples the next chunk of samples. Output lags the input by the kernel half width.
+func (r *Resampler) Process(in []float64) []float64 {
+	if r.step == 1 {
+		return in
+	}
+
+	buf := append(r.history, in...)
+	var out []float64
+	for r.pos+float64(r.halfTaps) < float64(len(buf)) {
+		out = append(out, r.interpolate(buf, r.pos))
+		r.pos += r.step
+	}
+
+	// Keep the samples still needed by the kernel for the next chunk
+	if drop := int(r.pos) - r.halfTaps; drop > 0 {
+		if drop > len(buf) {
+			drop = len(buf)
+		}
+		buf = buf[drop:]
+		r.pos -= float64(drop)
+	}
+	r.history = append([]float64(nil), buf...)
+	return out
+}
+
+// Flush returns the output samples still waiting for kernel lookahead, treating the
+// stream as ended, and resets the resampler
+func (r *Resampler) Flush() []float64 {
+	if r.step == 1 {
+		return nil
+	}
+	var out []float64
+	for r.pos < float64(len(r.history)) {
+		out = append(out, r.interpolate(r.history, r.pos))
+		r.pos += r.step
+	}
+	r.history = nil
+	r.pos = 0
+	return out
+}
+
+// interpolate evaluates the band-limited signal at a fractional position
+func (r *Resampler) interpolate(buf []float64, pos float64) float64 {
+	center := int(math.Floor(pos))
+	var sum, weights float64
+	for k := center - r.halfTaps + 1; k <= center+r.halfTaps; k++ {
+		if k < 0 || k >= len(buf) {
+			continue
+		}
+		x := pos - float64(k)
+		weight := r.cutoff * sinc(r.cutoff*x) * hann(x, float64(r.halfTaps))
+		sum += buf[k] * weight
+		weights += weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	// Normalizing keeps the DC gain at one near the start of the stream
+	return sum / weights
+}
+
+// sinc returns the normalized sinc function
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// hann returns a Hann window of the given half width
+func hann(x, halfWidth float64) float64 {
+	if math.Abs(x) >= halfWidth {
+		return 0
+	}
+	return 0.5 + 0.5*math.Cos(math.Pi*x/halfWidth)
+}
@@ -0,0 +1,225 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sine returns seconds of a sine wave at the given frequency and amplitude in [-1, 1]
+func sine(frequency, amplitude float64, sampleRate int, seconds float64) []float64 {
+	samples := make([]float64, int(float64(sampleRate)*seconds))
+	for i := range samples {
+		samples[i] = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+	}
+	return samples
+}
+
+// encodeFixture encodes interleaved samples in one of the converter's input encodings
+func encodeFixture(encoding string, samples []float64) []byte {
+	var buf bytes.Buffer
+	for _, s := range samples {
+		switch encoding {
+		case EncodingFloat32:
+			binary.Write(&buf, binary.LittleEndian, float32(s))
+		case EncodingMuLaw:
+			buf.WriteByte(MuLawEncode(floatToPCM16(s)))
+		case EncodingALaw:
+			buf.WriteByte(ALawEncode(floatToPCM16(s)))
+		default:
+			binary.Write(&buf, binary.LittleEndian, floatToPCM16(s))
+		}
+	}
+	return buf.Bytes()
+}
+
+// interleave combines two channels of equal length into stereo frames
+func interleave(left, right []float64) []float64 {
+	out := make([]float64, 0, 2*len(left))
+	for i := range left {
+		out = append(out, left[i], right[i])
+	}
+	return out
+}
+
+// decodePCM16 decodes LINEAR16 bytes to samples in [-1, 1]
+func decodePCM16(data []byte) []float64 {
+	out := make([]float64, len(data)/2)
+	for i := range out {
+		out[i] = float64(int16(binary.LittleEndian.Uint16(data[2*i:]))) / 32768
+	}
+	return out
+}
+
+// goertzel returns the magnitude of one frequency component, scaled to the sine amplitude
+func goertzel(samples []float64, frequency float64, sampleRate int) float64 {
+	coefficient := 2 * math.Cos(2*math.Pi*frequency/float64(sampleRate))
+	var s1, s2 float64
+	for _, x := range samples {
+		s1, s2 = x+coefficient*s1-s2, s1
+	}
+	power := s1*s1 + s2*s2 - coefficient*s1*s2
+	return 2 * math.Sqrt(math.Max(power, 0)) / float64(len(samples))
+}
+
+// dominantFrequency scans 50 Hz to 4 kHz in 10 Hz steps and returns the strongest frequency and its amplitude
+func dominantFrequency(samples []float64, sampleRate int) (frequency, amplitude float64) {
+	for f := 50.0; f <= 4000; f += 10 {
+		if a := goertzel(samples, f, sampleRate); a > amplitude {
+			frequency, amplitude = f, a
+		}
+	}
+	return frequency, amplitude
+}
+
+// convertAll converts the input in uneven chunks and flushes the converter
+func convertAll(t *testing.T, input Format, outChannels int, data []byte) []byte {
+	t.Helper()
+	converter, err := NewConverter(input, outChannels)
+	if err != nil {
+		t.Fatalf("NewConverter(%+v): %v", input, err)
+	}
+	var out []byte
+	for len(data) > 0 {
+		n := 997
+		if n > len(data) {
+			n = len(data)
+		}
+		out = append(out, converter.Convert(data[:n])...)
+		data = data[n:]
+	}
+	return append(out, converter.Flush()...)
+}
+
+func TestConverterResamplesSine(t *testing.T) {
+	tests := []struct {
+		name       string
+		encoding   string
+		sampleRate int
+		// tolerance is the allowed amplitude error; G.711 quantization adds noise
+		tolerance float64
+	}{
+		{"pcm16 8 kHz", EncodingPCM16, 8000, 0.02},
+		{"pcm16 44.1 kHz", EncodingPCM16, 44100, 0.02},
+		{"pcm16 48 kHz", EncodingPCM16, 48000, 0.02},
+		{"float32 48 kHz", EncodingFloat32, 48000, 0.02},
+		{"mulaw 8 kHz", EncodingMuLaw, 8000, 0.03},
+		{"alaw 8 kHz", EncodingALaw, 8000, 0.03},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := Format{Encoding: tt.encoding, SampleRate: tt.sampleRate, Channels: 1}
+			out := decodePCM16(convertAll(t, input, 1, encodeFixture(tt.encoding, sine(440, 0.5, tt.sampleRate, 1))))
+
+			// One second of input yields one second at the target rate
+			if diff := len(out) - TargetSampleRate; diff < -1 || diff > 1 {
+				t.Fatalf("got %d output samples, want %d", len(out), TargetSampleRate)
+			}
+
+			// The edges are affected by the kernel window, so only the middle is analyzed
+			middle := out[TargetSampleRate/10 : len(out)-TargetSampleRate/10]
+			frequency, amplitude := dominantFrequency(middle, TargetSampleRate)
+			if frequency != 440 {
+				t.Errorf("dominant frequency %v Hz, want 440 Hz", frequency)
+			}
+			if math.Abs(amplitude-0.5) > tt.tolerance {
+				t.Errorf("amplitude %.3f, want 0.5", amplitude)
+			}
+		})
+	}
+}
+
+func TestConverterDownmixesStereo(t *testing.T) {
+	// The customer speaks on the left channel while the right one is silent
+	left := sine(440, 0.8, 48000, 0.5)
+	right := make([]float64, len(left))
+	input := Format{Encoding: EncodingPCM16, SampleRate: 48000, Channels: 2}
+	out := decodePCM16(convertAll(t, input, 1, encodeFixture(EncodingPCM16, interleave(left, right))))
+
+	if diff := len(out) - TargetSampleRate/2; diff < -1 || diff > 1 {
+		t.Fatalf("got %d mono samples, want %d", len(out), TargetSampleRate/2)
+	}
+	frequency, amplitude := dominantFrequency(out[1600:len(out)-1600], TargetSampleRate)
+	if frequency != 440 {
+		t.Errorf("dominant frequency %v Hz, want 440 Hz", frequency)
+	}
+	// Averaging with silence halves the amplitude
+	if math.Abs(amplitude-0.4) > 0.02 {
+		t.Errorf("amplitude %.3f, want 0.4", amplitude)
+	}
+}
+
+func TestConverterKeepsStereoChannels(t *testing.T) {
+	left := sine(440, 0.5, 8000, 0.5)
+	right := sine(1000, 0.25, 8000, 0.5)
+	input := Format{Encoding: EncodingPCM16, SampleRate: 8000, Channels: 2}
+	out := decodePCM16(convertAll(t, input, 2, encodeFixture(EncodingPCM16, interleave(left, right))))
+
+	var outLeft, outRight []float64
+	for i := 0; i+1 < len(out); i += 2 {
+		outLeft = append(outLeft, out[i])
+		outRight = append(outRight, out[i+1])
+	}
+	for _, tt := range []struct {
+		name      string
+		samples   []float64
+		frequency float64
+		amplitude float64
+	}{
+		{"left", outLeft, 440, 0.5},
+		{"right", outRight, 1000, 0.25},
+	} {
+		frequency, amplitude := dominantFrequency(tt.samples[1600:len(tt.samples)-1600], TargetSampleRate)
+		if frequency != tt.frequency || math.Abs(amplitude-tt.amplitude) > 0.02 {
+			t.Errorf("%s channel: %v Hz at %.3f, want %v Hz at %v", tt.name, frequency, amplitude, tt.frequency, tt.amplitude)
+		}
+	}
+}
+
+func TestConverterChunkingDoesNotChangeOutput(t *testing.T) {
+	input := Format{Encoding: EncodingPCM16, SampleRate: 44100, Channels: 1}
+	data := encodeFixture(EncodingPCM16, sine(440, 0.5, 44100, 0.25))
+
+	converter, err := NewConverter(input, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole := append(converter.Convert(data), converter.Flush()...)
+	if chunked := convertAll(t, input, 1, data); !bytes.Equal(whole, chunked) {
+		t.Errorf("chunked conversion produced %d bytes that differ from the %d bytes of a single call", len(chunked), len(whole))
+	}
+}
+
+func TestG711RoundTrip(t *testing.T) {
+	codecs := []struct {
+		name   string
+		encode func(int16) byte
+		decode func(byte) int16
+	}{
+		{"mulaw", MuLawEncode, MuLawDecode},
+		{"alaw", ALawEncode, ALawDecode},
+	}
+	for _, codec := range codecs {
+		t.Run(codec.name, func(t *testing.T) {
+			// Every code decodes to a value that encodes back to the same code;
+			// mu-law has two codes for zero and prefers the positive one
+			for code := 0; code < 256; code++ {
+				sample := codec.decode(byte(code))
+				got := codec.encode(sample)
+				if got != byte(code) && codec.decode(got) != sample {
+					t.Errorf("code %#02x decodes to %d, which encodes to %#02x", code, sample, got)
+				}
+			}
+
+			// Encoding a sine loses at most the quantization step of the segment
+			for _, s := range sine(440, 0.9, 8000, 0.01) {
+				sample := floatToPCM16(s)
+				decoded := codec.decode(codec.encode(sample))
+				if diff := math.Abs(float64(decoded) - float64(sample)); diff > math.Abs(float64(sample))/16+16 {
+					t.Errorf("sample %d round-trips to %d", sample, decoded)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+)
+
+// VAD event types
+const (
+	EventSpeechStart = "speech_start"
+	EventSpeechEnd   = "speech_end"
+)
+
+// frameDurationMs is the analysis window of the VAD
+const frameDurationMs = 20
+
+// VADEvent marks the start or end of speech, relative to the start of the stream
+type VADEvent struct {
+	Type     string `json:"type"`
+	OffsetMs int64  `json:"offset_ms"`
+}
+
+// vadGap marks where forwarded audio jumps ahead in the stream because silence was dropped:
+// from forwarded frame at on, stream frame = forwarded frame + skipped
+type vadGap struct {
+	at      int64
+	skipped int64
+}
+
+// VAD is an energy-based voice activity detector for 16-bit mono LINEAR16 audio.
+// It drops silence between utterances while keeping a short pre-roll so that
+// word onsets are not cut off. The dropped spans are remembered, so offsets on the
+// forwarded audio can be mapped back to offsets in the stream with StreamOffsetMs.
+// Process must not be called concurrently; ForwardedMs and StreamOffsetMs may be
+// called from other goroutines.
+type VAD struct {
+	// Threshold is the RMS level (on the int16 scale) above which a frame counts as voiced
+	Threshold float64
+	// StartFrames is the number of consecutive voiced frames that start an utterance
+	StartFrames int
+	// HangoverFrames is the number of consecutive silent frames that end an utterance
+	HangoverFrames int
+	// PreRollFrames is the number of frames before speech start that are still forwarded; 0 disables the pre-roll
+	PreRollFrames int
+	// KeepaliveFrames forwards one silent frame after this many silent frames,
+	// so the recognizer does not time out during long pauses
+	KeepaliveFrames int
+
+	frameBytes   int
+	pending      []byte
+	preRoll      [][]byte
+	speaking     bool
+	voicedRun    int
+	silentRun    int
+	droppedRun   int
+	frames       int64
+	droppedBytes int64
+
+	// gaps and forwarded are guarded by mu
+	mu        sync.Mutex
+	gaps      []vadGap
+	forwarded int64
+}
+
+// NewVAD creates a detector for mono 16-bit audio at the given sample rate
+func NewVAD(sampleRate int) *VAD {
+	return &VAD{
+		Threshold:       500,
+		StartFrames:     3,
+		HangoverFrames:  40,
+		PreRollFrames:   15,
+		KeepaliveFrames: 250,
+		frameBytes:      sampleRate * frameDurationMs / 1000 * 2,
+	}
+}
+
+// Speaking reports whether the detector is currently inside an utterance
+func (v *VAD) Speaking() bool {
+	return v.speaking
+}
+
+// DroppedBytes returns how many bytes of silence were not forwarded
+func (v *VAD) DroppedBytes() int64 {
+	return v.droppedBytes
+}
+
+// ForwardedMs returns the duration of the audio forwarded so far
+func (v *VAD) ForwardedMs() int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.forwarded * frameDurationMs
+}
+
+// StreamOffsetMs maps an offset on the forwarded audio, such as a word time reported by
+// the recognizer, to the offset in the stream by adding back the silence dropped before it
+func (v *VAD) StreamOffsetMs(forwardedMs int64) int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	frame := forwardedMs / frameDurationMs
+	i := sort.Search(len(v.gaps), func(i int) bool { return v.gaps[i].at > frame })
+	if i == 0 {
+		return forwardedMs
+	}
+	return forwardedMs + v.gaps[i-1].skipped*frameDurationMs
+}
+
+// forward appends the frame with the given stream index to out and records a gap when
+// frames before it were dropped
+func (v *VAD) forward(out, frame []byte, index int64) []byte {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var last int64
+	if len(v.gaps) > 0 {
+		last = v.gaps[len(v.gaps)-1].skipped
+	}
+	if skipped := index - v.forwarded; skipped != last {
+		v.gaps = append(v.gaps, vadGap{at: v.forwarded, skipped: skipped})
+	}
+	v.forwarded++
+	return append(out, frame...)
+}
+
+// Process analyzes the audio and returns the bytes that should be forwarded to the
+// recognizer together with any speech start/end events. Incomplete frames are kept
+// until the next call.
+func (v *VAD) Process(pcm []byte) (forward []byte, events []VADEvent) {
+	data := append(v.pending, pcm...)
+
+	for len(data) >= v.frameBytes {
+		frame := data[:v.frameBytes]
+		data = data[v.frameBytes:]
+		index := v.frames
+		v.frames++
+
+		voiced := RMS16(frame) >= v.Threshold
+
+		if v.speaking {
+			forward = v.forward(forward, frame, index)
+			if voiced {
+				v.silentRun = 0
+			} else {
+				v.silentRun++
+			}
+			if v.silentRun >= v.HangoverFrames {
+				v.speaking = false
+				v.voicedRun = 0
+				events = append(events, VADEvent{Type: EventSpeechEnd, OffsetMs: v.frames * frameDurationMs})
+			}
+			continue
+		}
+
+		if voiced {
+			v.voicedRun++
+		} else {
+			v.voicedRun = 0
+		}
+
+		if v.voicedRun >= v.StartFrames {
+			v.speaking = true
+			v.silentRun = 0
+			v.droppedRun = 0
+			events = append(events, VADEvent{Type: EventSpeechStart, OffsetMs: (v.frames - int64(v.voicedRun)) * frameDurationMs})
+			// The pre-roll holds the frames right before the current one
+			first := index - int64(len(v.preRoll))
+			for i, buffered := range v.preRoll {
+				forward = v.forward(forward, buffered, first+int64(i))
+			}
+			forward = v.forward(forward, frame, index)
+			v.preRoll = nil
+			continue
+		}
+
+		v.droppedRun++
+		if v.KeepaliveFrames > 0 && v.droppedRun >= v.KeepaliveFrames {
+			// Forward the current frame once in a while to keep the recognition stream alive.
+			// The pre-roll before it is dropped, so forwarded audio stays in stream order.
+			for _, buffered := range v.preRoll {
+				v.droppedBytes += int64(len(buffered))
+			}
+			v.preRoll = nil
+			forward = v.forward(forward, frame, index)
+			v.droppedRun = 0
+			continue
+		}
+
+		v.preRoll = append(v.preRoll, append([]byte(nil), frame...))
+		if len(v.preRoll) > v.PreRollFrames {
+			v.droppedBytes += int64(len(v.preRoll[0]))
+			v.preRoll = v.preRoll[1:]
+		}
+	}
+
+	v.pending = append([]byte(nil), data...)
+	return forward, events
+}
+
+// RMS16 returns the root mean square level of 16-bit little-endian PCM samples
+func RMS16(pcm []byte) float64 {
+	samples := len(pcm) / 2
+	if samples == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < samples; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+		sum += sample * sample
+	}
+	return math.Sqrt(sum / float64(samples))
+}
@@ -0,0 +1,81 @@
+package audio
+
+import (
+	"testing"
+)
+
+// frames encodes seconds of a 440 Hz tone, or silence when amplitude is 0, as 16 kHz LINEAR16
+func frames(amplitude, seconds float64) []byte {
+	return encodeFixture(EncodingPCM16, sine(440, amplitude, TargetSampleRate, seconds))
+}
+
+func TestVADMapsForwardedOffsetsToStream(t *testing.T) {
+	vad := NewVAD(TargetSampleRate)
+	vad.KeepaliveFrames = 0
+
+	// Speech at 1.0-1.5 s and at 3.5-4.0 s
+	var stream []byte
+	for _, part := range []struct{ amplitude, seconds float64 }{{0, 1}, {0.5, 0.5}, {0, 2}, {0.5, 0.5}, {0, 1}} {
+		stream = append(stream, frames(part.amplitude, part.seconds)...)
+	}
+	forward, events := vad.Process(stream)
+
+	var starts []int64
+	for _, event := range events {
+		if event.Type == EventSpeechStart {
+			starts = append(starts, event.OffsetMs)
+		}
+	}
+	if len(starts) != 2 || starts[0] != 1000 || starts[1] != 3500 {
+		t.Fatalf("got speech starts %v, want [1000 3500]", starts)
+	}
+
+	// Find where each utterance begins in the forwarded audio
+	var onsets []int64
+	voiced := false
+	for i := 0; i+640 <= len(forward); i += 640 {
+		level := RMS16(forward[i : i+640])
+		if level >= vad.Threshold && !voiced {
+			onsets = append(onsets, int64(i/640)*frameDurationMs)
+		}
+		voiced = level >= vad.Threshold
+	}
+	if len(onsets) != 2 {
+		t.Fatalf("found %d utterances in the forwarded audio, want 2", len(onsets))
+	}
+	for i, onset := range onsets {
+		if got := vad.StreamOffsetMs(onset); got != starts[i] {
+			t.Errorf("utterance %d starts at %d ms of forwarded audio, mapped to %d ms, want %d ms", i, onset, got, starts[i])
+		}
+	}
+
+	// Silence at the end waits in the pre-roll
+	if got := int64(len(forward)+len(vad.preRoll)*640) + vad.DroppedBytes(); got != int64(len(stream)) {
+		t.Errorf("forwarded, buffered and dropped bytes add up to %d, want %d", got, len(stream))
+	}
+	if got := vad.ForwardedMs(); got != int64(len(forward)/640*frameDurationMs) {
+		t.Errorf("ForwardedMs() = %d, want %d", got, len(forward)/640*frameDurationMs)
+	}
+}
+
+func TestVADKeepaliveWithoutPreRoll(t *testing.T) {
+	vad := NewVAD(TargetSampleRate)
+	vad.PreRollFrames = 0
+	vad.KeepaliveFrames = 10
+
+	// One second of silence is 50 frames, so every tenth frame is forwarded
+	forward, events := vad.Process(frames(0, 1))
+	if len(events) != 0 {
+		t.Errorf("got events %v in silence", events)
+	}
+	if got := len(forward) / 640; got != 5 {
+		t.Errorf("forwarded %d keepalive frames, want 5", got)
+	}
+	// The last keepalive frame is the 50th frame of the stream
+	if got := vad.StreamOffsetMs(4 * frameDurationMs); got != 49*frameDurationMs {
+		t.Errorf("last keepalive frame maps to %d ms, want %d ms", got, 49*frameDurationMs)
+	}
+	if got := int64(len(forward)) + vad.DroppedBytes(); got != int64(len(frames(0, 1))) {
+		t.Errorf("forwarded and dropped bytes add up to %d, want %d", got, len(frames(0, 1)))
+	}
+}
@@ -0,0 +1,167 @@
+// Package audio contains helpers for handling raw PCM audio
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WAVHeaderSize is the size of the canonical 44-byte WAV header
+const WAVHeaderSize = 44
+
+// WriteWAVHeader writes a canonical PCM WAV header for 16-bit samples
+func WriteWAVHeader(w io.Writer, dataSize, sampleRate, channels int) error {
+	blockAlign := channels * 2
+	header := struct {
+		ChunkID       [4]byte
+		ChunkSize     uint32
+		Format        [4]byte
+		Subchunk1ID   [4]byte
+		Subchunk1Size uint32
+		AudioFormat   uint16
+		NumChannels   uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Subchunk2ID   [4]byte
+		Subchunk2Size uint32
+	}{
+		ChunkID:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     uint32(36 + dataSize),
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		Subchunk1ID:   [4]byte{'f', 'm', 't', ' '},
+		Subchunk1Size: 16,
+		AudioFormat:   1,
+		NumChannels:   uint16(channels),
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate * blockAlign),
+		BlockAlign:    uint16(blockAlign),
+		BitsPerSample: 16,
+		Subchunk2ID:   [4]byte{'d', 'a', 't', 'a'},
+		Subchunk2Size: uint32(dataSize),
+	}
+	return binary.Write(w, binary.LittleEndian, header)
+}
+
+// EncodeWAV wraps 16-bit little-endian PCM data in a WAV container
+func EncodeWAV(pcm []byte, sampleRate, channels int) []byte {
+	var buf bytes.Buffer
+	buf.Grow(WAVHeaderSize + len(pcm))
+	WriteWAVHeader(&buf, len(pcm), sampleRate, channels)
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// WAVWriter streams 16-bit PCM into a WAV file and fixes up the header sizes on Close
+type WAVWriter struct {
+	w          io.WriteSeeker
+	sampleRate int
+	channels   int
+	dataSize   int
+}
+
+// NewWAVWriter writes a placeholder header and returns a writer for the PCM data
+func NewWAVWriter(w io.WriteSeeker, sampleRate, channels int) (*WAVWriter, error) {
+	if err := WriteWAVHeader(w, 0, sampleRate, channels); err != nil {
+		return nil, err
+	}
+	return &WAVWriter{w: w, sampleRate: sampleRate, channels: channels}, nil
+}
+
+// Write appends PCM data
+func (ww *WAVWriter) Write(pcm []byte) (int, error) {
+	n, err := ww.w.Write(pcm)
+	ww.dataSize += n
+	return n, err
+}
+
+// DataSize returns the number of PCM bytes written so far
+func (ww *WAVWriter) DataSize() int {
+	return ww.dataSize
+}
+
+// Close rewrites the header with the final data size. It does not close the underlying writer.
+func (ww *WAVWriter) Close() error {
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := WriteWAVHeader(ww.w, ww.dataSize, ww.sampleRate, ww.channels); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// WAV audio format codes
+const (
+	WAVFormatPCM   = 1
+	WAVFormatFloat = 3
+	WAVFormatALaw  = 6
+	WAVFormatMuLaw = 7
+)
+
+// WAVInfo describes the format chunk of a WAV file
+type WAVInfo struct {
+	AudioFormat   int
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+}
+
+// ErrInvalidWAV is returned when data is not a WAV file this package can read
+var ErrInvalidWAV = errors.New("invalid WAV data")
+
+// IsWAV reports whether data starts with a RIFF/WAVE header
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// ParseWAV reads the format and data chunks of a WAV file
+func ParseWAV(data []byte) (WAVInfo, []byte, error) {
+	if !IsWAV(data) {
+		return WAVInfo{}, nil, ErrInvalidWAV
+	}
+
+	var info WAVInfo
+	var haveFormat bool
+	offset := 12
+	for offset+8 <= len(data) {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := offset + 8
+		end := body + size
+		if end > len(data) {
+			// Streaming writers sometimes leave the data size unset; use what is there
+			end = len(data)
+		}
+
+		switch id {
+		case "fmt ":
+			if end-body < 16 {
+				return WAVInfo{}, nil, fmt.Errorf("%w: short fmt chunk", ErrInvalidWAV)
+			}
+			info.AudioFormat = int(binary.LittleEndian.Uint16(data[body:]))
+			info.Channels = int(binary.LittleEndian.Uint16(data[body+2:]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(data[body+4:]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(data[body+14:]))
+			// WAVE_FORMAT_EXTENSIBLE stores the real format in the sub-format GUID
+			if info.AudioFormat == 0xFFFE && end-body >= 26 {
+				info.AudioFormat = int(binary.LittleEndian.Uint16(data[body+24:]))
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return WAVInfo{}, nil, fmt.Errorf("%w: data chunk before fmt chunk", ErrInvalidWAV)
+			}
+			return info, data[body:end], nil
+		}
+
+		// Chunks are padded to an even size
+		offset = end + size%2
+	}
+	return WAVInfo{}, nil, fmt.Errorf("%w: no data chunk", ErrInvalidWAV)
+}
@@ -0,0 +1,418 @@
+package config
+
+import (
+	"awesomeProject2/logging"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the complete server configuration.
+// Every setting can come from the YAML file (yaml tag), an environment variable (env tag)
+// or a flag named after its YAML path, e.g. -server.port.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	OpenAI    OpenAIConfig    `yaml:"openai"`
+	Google    GoogleConfig    `yaml:"google"`
+	Speech    SpeechConfig    `yaml:"speech"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+	Analysis  AnalysisConfig  `yaml:"analysis"`
+	TTS       TTSConfig       `yaml:"tts"`
+	Store     StoreConfig     `yaml:"store"`
+	Recording RecordingConfig `yaml:"recording"`
+	Auth      AuthConfig      `yaml:"auth"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	Port int `yaml:"port" env:"PORT"`
+	// DrainTimeout is how long active speech sessions may continue after a shutdown signal
+	DrainTimeout time.Duration `yaml:"drain_timeout" env:"SHUTDOWN_DRAIN_SECONDS"`
+}
+
+// OpenAIConfig configures the OpenAI API used for suggestions, summaries and speech
+type OpenAIConfig struct {
+	APIKey   string `yaml:"api_key" env:"OPENAI_API_KEY" secret:"true"`
+	Model    string `yaml:"model" env:"OPENAI_MODEL"`
+	TTSModel string `yaml:"tts_model" env:"OPENAI_TTS_MODEL"`
+	TTSVoice string `yaml:"tts_voice" env:"OPENAI_TTS_VOICE"`
+	// PromptTemplatesFile and BudgetsFile hold the admin-managed prompt templates and per-team budgets
+	PromptTemplatesFile string `yaml:"prompt_templates_file" env:"PROMPT_TEMPLATES_FILE"`
+	BudgetsFile         string `yaml:"budgets_file" env:"OPENAI_BUDGETS_FILE"`
+}
+
+// GoogleConfig configures the Google Cloud Speech client
+type GoogleConfig struct {
+	CredentialsFile string `yaml:"credentials_file" env:"GOOGLE_APPLICATION_CREDENTIALS"`
+}
+
+// SpeechConfig configures speech sessions
+type SpeechConfig struct {
+	CustomerLanguage string        `yaml:"customer_language" env:"SPEECH_CUSTOMER_LANGUAGE"`
+	AgentLanguage    string        `yaml:"agent_language" env:"SPEECH_AGENT_LANGUAGE"`
+	SummaryLanguage  string        `yaml:"summary_language" env:"SUMMARY_LANGUAGE"`
+	PhraseHintsFile  string        `yaml:"phrase_hints_file" env:"PHRASE_HINTS_FILE"`
+	ResumeGrace      time.Duration `yaml:"resume_grace" env:"SESSION_RESUME_GRACE_SECONDS"`
+}
+
+// WebSocketConfig bounds speech WebSocket connections
+type WebSocketConfig struct {
+	MaxMessageBytes int64         `yaml:"max_message_bytes" env:"WS_MAX_MESSAGE_BYTES"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"WS_WRITE_TIMEOUT_SECONDS"`
+	PongTimeout     time.Duration `yaml:"pong_timeout" env:"WS_PONG_TIMEOUT_SECONDS"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" env:"WS_IDLE_TIMEOUT_SECONDS"`
+}
+
+// AnalysisConfig configures turn analysis and the grammar check knowledge base
+type AnalysisConfig struct {
+	TurnAnalyzer      string `yaml:"turn_analyzer" env:"TURN_ANALYZER"`
+	KnowledgeBaseFile string `yaml:"knowledge_base_file" env:"KNOWLEDGE_BASE_FILE"`
+}
+
+// TTSConfig configures text-to-speech
+type TTSConfig struct {
+	Provider string `yaml:"provider" env:"TTS_PROVIDER"`
+	// MaxTextLength is the longest text in characters synthesized per request
+	MaxTextLength int `yaml:"max_text_length" env:"TTS_MAX_TEXT_LENGTH"`
+}
+
+// StoreConfig selects where conversations, summaries and jobs are kept
+type StoreConfig struct {
+	Backend string `yaml:"backend" env:"STORE_BACKEND"`
+}
+
+// RecordingConfig configures call recording
+type RecordingConfig struct {
+	Dir           string `yaml:"dir" env:"RECORDING_DIR"`
+	RetentionDays int    `yaml:"retention_days" env:"RECORDING_RETENTION_DAYS"`
+}
+
+// AuthConfig configures authentication. One of the methods must be configured
+// unless Disabled explicitly opts out.
+type AuthConfig struct {
+	JWTSecret   string `yaml:"jwt_secret" env:"AUTH_JWT_SECRET" secret:"true"`
+	APIKeysFile string `yaml:"api_keys_file" env:"AUTH_API_KEYS_FILE"`
+	// Disabled runs without authentication and trusts the Username parameter; for local development only
+	Disabled bool `yaml:"disabled" env:"AUTH_DISABLED"`
+}
+
+// CORSConfig configures the browser origin policy
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	AllowCredentials bool     `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+}
+
+// LogConfig configures structured logging
+type LogConfig struct {
+	// Level is debug, info, warn or error; transcripts are only logged unmasked at debug
+	Level  string `yaml:"level" env:"LOG_LEVEL"`
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// Modules overrides the level per module, e.g. speech=debug
+	Modules []string `yaml:"modules" env:"LOG_MODULE_LEVELS"`
+}
+
+// TracingConfig configures OpenTelemetry tracing
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter    string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	ServiceName string `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+	// OTLPEndpoint is the OTLP/HTTP traces URL, e.g. http://collector:4318/v1/traces;
+	// when empty the standard OTEL_EXPORTER_OTLP_* variables apply
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+}
+
+// Default returns the built-in configuration
+func Default() Config {
+	return Config{
+		Server: ServerConfig{Port: 8080, DrainTimeout: 30 * time.Second},
+		OpenAI: OpenAIConfig{Model: "gpt-4o", TTSModel: "tts-1", TTSVoice: "alloy"},
+		Speech: SpeechConfig{
+			CustomerLanguage: "de-DE",
+			AgentLanguage:    "de-DE",
+			SummaryLanguage:  "ko",
+			ResumeGrace:      30 * time.Second,
+		},
+		WebSocket: WebSocketConfig{
+			MaxMessageBytes: 1 << 20,
+			WriteTimeout:    10 * time.Second,
+			PongTimeout:     60 * time.Second,
+			IdleTimeout:     5 * time.Minute,
+		},
+		Analysis:  AnalysisConfig{TurnAnalyzer: "rules"},
+		TTS:       TTSConfig{Provider: "openai", MaxTextLength: 4096},
+		Store:     StoreConfig{Backend: "memory"},
+		Recording: RecordingConfig{RetentionDays: 30},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key"},
+		},
+		Log:     LogConfig{Level: "info", Format: "json"},
+		Tracing: TracingConfig{Exporter: "none", ServiceName: "speech-assist-backend"},
+	}
+}
+
+// Load builds the configuration from defaults, the config file, environment variables and
+// command line flags, in increasing order of precedence. It parses args with a flag set whose
+// remaining positional arguments are returned.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML config file")
+	// -port is kept as a short form of -server.port
+	port := flags.Int("port", 0, "Port to listen on (same as -server.port)")
+	values := make(map[string]*string)
+	walk(reflect.ValueOf(&cfg).Elem(), "", func(path string, field reflect.Value, tag reflect.StructTag) {
+		values[path] = flags.String(path, "", fmt.Sprintf("Overrides %s (env %s)", path, tag.Get("env")))
+	})
+	if err := flags.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, nil, fmt.Errorf("parsing config file %s: %w", *configFile, err)
+		}
+	}
+
+	var errs []error
+	walk(reflect.ValueOf(&cfg).Elem(), "", func(path string, field reflect.Value, tag reflect.StructTag) {
+		if name := tag.Get("env"); name != "" {
+			if value, ok := os.LookupEnv(name); ok && value != "" {
+				if err := setValue(field, value); err != nil {
+					errs = append(errs, fmt.Errorf("environment variable %s: %w", name, err))
+				}
+			}
+		}
+	})
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	walk(reflect.ValueOf(&cfg).Elem(), "", func(path string, field reflect.Value, tag reflect.StructTag) {
+		if set[path] {
+			if err := setValue(field, *values[path]); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", path, err))
+			}
+		}
+	})
+	if set["port"] && !set["server.port"] {
+		cfg.Server.Port = *port
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// Validate checks the configuration and reports every problem at once.
+// Configured files and directories must be usable, so a typo in a path stops the server at startup.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.DrainTimeout >= 0, "server.drain_timeout must not be negative")
+	check(c.OpenAI.Model != "", "openai.model must not be empty")
+	check(c.OpenAI.TTSModel != "", "openai.tts_model must not be empty")
+	check(c.OpenAI.TTSVoice != "", "openai.tts_voice must not be empty")
+	check(c.Speech.CustomerLanguage != "", "speech.customer_language must not be empty")
+	check(c.Speech.AgentLanguage != "", "speech.agent_language must not be empty")
+	check(c.Speech.SummaryLanguage != "", "speech.summary_language must not be empty")
+	check(c.Speech.ResumeGrace > 0, "speech.resume_grace must be positive")
+	check(c.WebSocket.MaxMessageBytes > 0, "websocket.max_message_bytes must be positive")
+	check(c.WebSocket.WriteTimeout > 0, "websocket.write_timeout must be positive")
+	check(c.WebSocket.PongTimeout > time.Second, "websocket.pong_timeout must be longer than one second")
+	check(c.WebSocket.IdleTimeout >= 0, "websocket.idle_timeout must not be negative")
+	check(c.Analysis.TurnAnalyzer == "rules" || c.Analysis.TurnAnalyzer == "llm",
+		"analysis.turn_analyzer must be rules or llm, got %q", c.Analysis.TurnAnalyzer)
+	check(c.TTS.Provider == "openai" || c.TTS.Provider == "local",
+		"tts.provider must be openai or local, got %q", c.TTS.Provider)
+	check(c.TTS.MaxTextLength > 0, "tts.max_text_length must be positive")
+	check(c.Store.Backend == "memory", "store.backend must be memory, got %q", c.Store.Backend)
+	check(c.Recording.RetentionDays > 0, "recording.retention_days must be positive")
+	authConfigured := c.Auth.JWTSecret != "" || c.Auth.APIKeysFile != ""
+	check(authConfigured || c.Auth.Disabled,
+		"auth.jwt_secret or auth.api_keys_file must be set; set auth.disabled to true to run without authentication")
+	check(!(authConfigured && c.Auth.Disabled), "auth.disabled cannot be combined with auth.jwt_secret or auth.api_keys_file")
+	check(len(c.CORS.AllowedOrigins) > 0,
+		"cors.allowed_origins must list the agent UI origins, e.g. https://agent.example.com (use * only for development)")
+	_, err := logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format must be json or text, got %q", c.Log.Format)
+	for _, entry := range c.Log.Modules {
+		module, level, found := strings.Cut(entry, "=")
+		_, err := logging.ParseLevel(level)
+		check(found && module != "" && err == nil, "log.modules entry %q must look like module=level", entry)
+	}
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "stdout" || c.Tracing.Exporter == "otlp",
+		"tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	check(c.Tracing.ServiceName != "", "tracing.service_name must not be empty")
+
+	for _, file := range []struct{ setting, path string }{
+		{"google.credentials_file", c.Google.CredentialsFile},
+		{"speech.phrase_hints_file", c.Speech.PhraseHintsFile},
+		{"analysis.knowledge_base_file", c.Analysis.KnowledgeBaseFile},
+		{"openai.prompt_templates_file", c.OpenAI.PromptTemplatesFile},
+		{"openai.budgets_file", c.OpenAI.BudgetsFile},
+		{"auth.api_keys_file", c.Auth.APIKeysFile},
+	} {
+		if file.path != "" {
+			err := checkReadableFile(file.path)
+			check(err == nil, "%s: %v", file.setting, err)
+		}
+	}
+	if c.Recording.Dir != "" {
+		err := checkWritableDir(c.Recording.Dir)
+		check(err == nil, "recording.dir: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkReadableFile reports whether path is a regular file that can be opened
+func checkReadableFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+// checkWritableDir reports whether files can be created in dir. A missing directory is
+// checked through its closest existing parent, since it is created on startup.
+func checkWritableDir(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(existing) == existing {
+			return err
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	probe, err := os.CreateTemp(existing, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", existing, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Redacted returns a copy of the configuration with secrets masked, suitable for printing
+func (c Config) Redacted() Config {
+	walk(reflect.ValueOf(&c).Elem(), "", func(path string, field reflect.Value, tag reflect.StructTag) {
+		if tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString("<redacted>")
+		}
+	})
+	return c
+}
+
+// YAML renders the configuration as YAML
+func (c Config) YAML() (string, error) {
+	data, err := yaml.Marshal(c)
+	return string(data), err
+}
+
+// walk calls fn for every leaf setting with its dotted YAML path
+func walk(v reflect.Value, prefix string, fn func(path string, field reflect.Value, tag reflect.StructTag)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		path := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if field.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), path, fn)
+			continue
+		}
+		fn(path, v.Field(i), field.Tag)
+	}
+}
+
+// setValue parses a string into a setting. Durations accept Go syntax ("90s") or plain seconds.
+func setValue(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		if seconds, err := strconv.Atoi(value); err == nil {
+			field.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	case []string:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
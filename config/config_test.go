@@ -0,0 +1,297 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes validation without touching the filesystem
+func validConfig() Config {
+	cfg := Default()
+	cfg.Auth.Disabled = true
+	cfg.CORS.AllowedOrigins = []string{"https://agent.example.com"}
+	return cfg
+}
+
+// writeFile creates a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  port: 9000\nopenai:\n  model: file-model\n  tts_voice: file-voice\nlog:\n  level: warn\n")
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		port  int
+		model string
+		voice string
+		level string
+	}{
+		{
+			name:  "defaults",
+			port:  8080,
+			model: "gpt-4o",
+			voice: "alloy",
+			level: "info",
+		},
+		{
+			name:  "file overrides defaults",
+			args:  []string{"-config", file},
+			port:  9000,
+			model: "file-model",
+			voice: "file-voice",
+			level: "warn",
+		},
+		{
+			name:  "env overrides file",
+			env:   map[string]string{"PORT": "9100", "OPENAI_MODEL": "env-model"},
+			args:  []string{"-config", file},
+			port:  9100,
+			model: "env-model",
+			voice: "file-voice",
+			level: "warn",
+		},
+		{
+			name:  "flags override env",
+			env:   map[string]string{"PORT": "9100", "OPENAI_MODEL": "env-model", "LOG_LEVEL": "error"},
+			args:  []string{"-config", file, "-server.port", "9200", "-openai.model", "flag-model"},
+			port:  9200,
+			model: "flag-model",
+			voice: "file-voice",
+			level: "error",
+		},
+		{
+			name:  "config file from env",
+			env:   map[string]string{"CONFIG_FILE": file},
+			port:  9000,
+			model: "file-model",
+			voice: "file-voice",
+			level: "warn",
+		},
+		{
+			name:  "short port flag",
+			env:   map[string]string{"PORT": "9100"},
+			args:  []string{"-port", "9300"},
+			port:  9300,
+			model: "gpt-4o",
+			voice: "alloy",
+			level: "info",
+		},
+		{
+			name:  "long port flag wins over short form",
+			args:  []string{"-port", "9300", "-server.port", "9400"},
+			port:  9400,
+			model: "gpt-4o",
+			voice: "alloy",
+			level: "info",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CONFIG_FILE", "PORT", "OPENAI_MODEL", "OPENAI_TTS_VOICE", "LOG_LEVEL"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			cfg, _, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != tt.port || cfg.OpenAI.Model != tt.model || cfg.OpenAI.TTSVoice != tt.voice || cfg.Log.Level != tt.level {
+				t.Errorf("got port %d, model %q, voice %q, level %q; want %d, %q, %q, %q",
+					cfg.Server.Port, cfg.OpenAI.Model, cfg.OpenAI.TTSVoice, cfg.Log.Level,
+					tt.port, tt.model, tt.voice, tt.level)
+			}
+		})
+	}
+}
+
+func TestLoadReturnsPositionalArgs(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	_, args, err := Load([]string{"-server.port", "9000", "print-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "print-config" {
+		t.Errorf("got args %q, want [print-config]", args)
+	}
+}
+
+func TestLoadSecondsSettings(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "45", want: 45 * time.Second},
+		{value: "0", want: 0},
+		{value: "90s", want: 90 * time.Second},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "500ms", want: 500 * time.Millisecond},
+		{value: "1.5", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("SHUTDOWN_DRAIN_SECONDS", tt.value)
+			t.Setenv("WS_PONG_TIMEOUT_SECONDS", tt.value)
+
+			cfg, _, err := Load(nil)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "SHUTDOWN_DRAIN_SECONDS") || !strings.Contains(err.Error(), "WS_PONG_TIMEOUT_SECONDS") {
+					t.Errorf("got error %v, want both variables reported", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.DrainTimeout != tt.want || cfg.WebSocket.PongTimeout != tt.want {
+				t.Errorf("got %v and %v, want %v", cfg.Server.DrainTimeout, cfg.WebSocket.PongTimeout, tt.want)
+			}
+		})
+	}
+
+	t.Run("flag", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", "")
+		cfg, _, err := Load([]string{"-speech.resume_grace", "12"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Speech.ResumeGrace != 12*time.Second {
+			t.Errorf("got %v, want 12s", cfg.Speech.ResumeGrace)
+		}
+	})
+}
+
+func TestLoadRejectsUnknownFileSettings(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	file := writeFile(t, "config.yaml", "server:\n  prot: 9000\n")
+	if _, _, err := Load([]string{"-config", file}); err == nil {
+		t.Error("expected an error for an unknown setting")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	readable := writeFile(t, "keys.json", "{}")
+	missing := filepath.Join(dir, "missing.json")
+	notADir := writeFile(t, "recordings", "")
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{name: "port out of range", modify: func(c *Config) { c.Server.Port = 70000 }, wantErr: "server.port"},
+		{name: "no authentication", modify: func(c *Config) { c.Auth.Disabled = false }, wantErr: "auth.jwt_secret"},
+		{
+			name:    "authentication disabled and configured",
+			modify:  func(c *Config) { c.Auth.JWTSecret = "secret" },
+			wantErr: "auth.disabled cannot be combined",
+		},
+		{name: "no CORS origins", modify: func(c *Config) { c.CORS.AllowedOrigins = nil }, wantErr: "cors.allowed_origins"},
+		{name: "no TTS text length", modify: func(c *Config) { c.TTS.MaxTextLength = 0 }, wantErr: "tts.max_text_length"},
+		{name: "bad module level", modify: func(c *Config) { c.Log.Modules = []string{"speech"} }, wantErr: "log.modules"},
+		{
+			name: "readable files",
+			modify: func(c *Config) {
+				c.Auth.Disabled = false
+				c.Auth.APIKeysFile = readable
+				c.Google.CredentialsFile = readable
+				c.Speech.PhraseHintsFile = readable
+				c.Analysis.KnowledgeBaseFile = readable
+				c.OpenAI.PromptTemplatesFile = readable
+				c.OpenAI.BudgetsFile = readable
+			},
+		},
+		{name: "missing API keys file", modify: func(c *Config) { c.Auth.Disabled = false; c.Auth.APIKeysFile = missing }, wantErr: "auth.api_keys_file"},
+		{name: "missing credentials file", modify: func(c *Config) { c.Google.CredentialsFile = missing }, wantErr: "google.credentials_file"},
+		{name: "missing phrase hints file", modify: func(c *Config) { c.Speech.PhraseHintsFile = missing }, wantErr: "speech.phrase_hints_file"},
+		{name: "missing prompt templates file", modify: func(c *Config) { c.OpenAI.PromptTemplatesFile = missing }, wantErr: "openai.prompt_templates_file"},
+		{name: "missing budgets file", modify: func(c *Config) { c.OpenAI.BudgetsFile = missing }, wantErr: "openai.budgets_file"},
+		{name: "knowledge base is a directory", modify: func(c *Config) { c.Analysis.KnowledgeBaseFile = dir }, wantErr: "analysis.knowledge_base_file"},
+		{name: "existing recording dir", modify: func(c *Config) { c.Recording.Dir = dir }},
+		{name: "recording dir created on startup", modify: func(c *Config) { c.Recording.Dir = filepath.Join(dir, "a", "b") }},
+		{name: "recording dir is a file", modify: func(c *Config) { c.Recording.Dir = notADir }, wantErr: "recording.dir"},
+		{
+			name:    "recording dir below a file",
+			modify:  func(c *Config) { c.Recording.Dir = filepath.Join(notADir, "2024") },
+			wantErr: "recording.dir",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := validConfig()
+	cfg.Server.Port = 0
+	cfg.Log.Format = "xml"
+	cfg.Speech.PhraseHintsFile = filepath.Join(t.TempDir(), "missing.json")
+
+	err := cfg.Validate()
+	for _, setting := range []string{"server.port", "log.format", "speech.phrase_hints_file"} {
+		if err == nil || !strings.Contains(err.Error(), setting) {
+			t.Errorf("error %v does not mention %s", err, setting)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := validConfig()
+	cfg.OpenAI.APIKey = "sk-secret"
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.Auth.Disabled = false
+	cfg.Auth.APIKeysFile = "/etc/keys.json"
+
+	redacted := cfg.Redacted()
+	if redacted.OpenAI.APIKey != "<redacted>" || redacted.Auth.JWTSecret != "<redacted>" {
+		t.Errorf("secrets not redacted: %q, %q", redacted.OpenAI.APIKey, redacted.Auth.JWTSecret)
+	}
+	if redacted.Auth.APIKeysFile != "/etc/keys.json" || redacted.OpenAI.Model != cfg.OpenAI.Model {
+		t.Error("settings that are not secret were changed")
+	}
+	if cfg.OpenAI.APIKey != "sk-secret" || cfg.Auth.JWTSecret != "jwt-secret" {
+		t.Error("Redacted modified the original configuration")
+	}
+
+	out, err := redacted.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "sk-secret") || strings.Contains(out, "jwt-secret") {
+		t.Errorf("printed configuration contains a secret:\n%s", out)
+	}
+
+	// Empty secrets stay empty, so print-config shows they are not configured
+	if empty := validConfig().Redacted(); empty.OpenAI.APIKey != "" || empty.Auth.JWTSecret != "" {
+		t.Error("empty secrets were replaced")
+	}
+}
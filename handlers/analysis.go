@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TurnAnalyzer detects sentiment, urgency and escalation keywords in a customer turn
+type TurnAnalyzer interface {
+	Analyze(ctx context.Context, text string) (models.TurnAnalysis, error)
+}
+
+var (
+	turnAnalyzer      TurnAnalyzer = NewRuleBasedAnalyzer()
+	turnAnalyzerMutex sync.RWMutex
+)
+
+// SetTurnAnalyzer replaces the analyzer used for finalized transcript turns
+func SetTurnAnalyzer(analyzer TurnAnalyzer) {
+	turnAnalyzerMutex.Lock()
+	defer turnAnalyzerMutex.Unlock()
+	turnAnalyzer = analyzer
+}
+
+// analyzeTurn runs the configured analyzer and falls back to the rule-based one on error
+func analyzeTurn(ctx context.Context, text string) models.TurnAnalysis {
+	turnAnalyzerMutex.RLock()
+	analyzer := turnAnalyzer
+	turnAnalyzerMutex.RUnlock()
+
+	analysis, err := analyzer.Analyze(ctx, text)
+	if err != nil {
+		assistLog.WarnContext(ctx, "turn analysis failed, falling back to rules", logging.Err(err))
+		analysis, _ = NewRuleBasedAnalyzer().Analyze(ctx, text)
+	}
+	return analysis
+}
+
+// keywordRule maps a normalized search term to the label reported to clients.
+// Terms match whole words. A trailing * matches any word starting with the term, so "kuendig*"
+// covers "Kündigung" and "kündigen" but "gericht" does not match "eingerichtet".
+// Terms of several words match consecutive words.
+type keywordRule struct {
+	term  string
+	label string
+}
+
+// escalationRules lists terms that indicate cancellation, complaints or legal threats
+var escalationRules = []keywordRule{
+	{"kuendig*", "Kündigung"},
+	{"gekuendigt", "Kündigung"},
+	{"anwalt*", "Anwalt"},
+	{"anwaelt*", "Anwalt"},
+	{"beschwer*", "Beschwerde"},
+	{"verbraucherzentrale", "Verbraucherzentrale"},
+	{"bundesnetzagentur", "Bundesnetzagentur"},
+	{"klage", "Klage"},
+	{"verklag*", "Klage"},
+	{"gericht", "Gericht"},
+	{"gerichts*", "Gericht"},
+	{"gerichtlich*", "Gericht"},
+	{"widerruf*", "Widerruf"},
+	{"schadensersatz*", "Schadensersatz"},
+	{"vorgesetzt*", "Vorgesetzter"},
+}
+
+// negativeTerms lists terms that indicate an angry or frustrated customer
+var negativeTerms = []string{
+	"aerger*", "unverschaemt*", "frechheit", "katastroph*",
+	"enttaeuscht*", "wuetend*", "sauer", "inakzeptabel", "unzumutbar", "schlecht*",
+	"nie wieder", "schon wieder", "zum wiederholten", "immer noch nicht", "unmoeglich*",
+	"laecherlich*", "reicht mir", "skandal*",
+}
+
+// positiveTerms lists terms that indicate a satisfied customer
+var positiveTerms = []string{
+	"danke", "dankeschoen", "vielen dank", "super", "perfekt*", "toll", "tolle", "toller",
+	"zufrieden*", "hilfreich*", "freundlich*", "wunderbar*", "prima", "sehr gut",
+}
+
+// urgencyTerms lists terms that indicate a time-critical request
+var urgencyTerms = []string{
+	"sofort*", "dringend*", "umgehend*", "heute noch", "notfall*", "so schnell wie moeglich",
+	"seit tagen", "seit wochen", "keine zeit", "jetzt gleich",
+}
+
+// umlautReplacer folds German umlauts so "Kündigung" and "Kuendigung" match the same rule
+var umlautReplacer = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss")
+
+// RuleBasedAnalyzer detects sentiment and escalation with local keyword lists
+type RuleBasedAnalyzer struct{}
+
+// NewRuleBasedAnalyzer creates an analyzer that works without the LLM
+func NewRuleBasedAnalyzer() *RuleBasedAnalyzer {
+	return &RuleBasedAnalyzer{}
+}
+
+// Analyze scores the text using keyword lists
+func (a *RuleBasedAnalyzer) Analyze(ctx context.Context, text string) (models.TurnAnalysis, error) {
+	words := normalizeWords(text)
+
+	analysis := models.TurnAnalysis{
+		Sentiment:          models.SentimentNeutral,
+		Urgency:            models.UrgencyLow,
+		EscalationKeywords: []string{},
+		Source:             "rules",
+	}
+
+	seen := make(map[string]bool)
+	for _, rule := range escalationRules {
+		if matchesTerm(words, rule.term) && !seen[rule.label] {
+			seen[rule.label] = true
+			analysis.EscalationKeywords = append(analysis.EscalationKeywords, rule.label)
+		}
+	}
+
+	negatives := countTerms(words, negativeTerms)
+	positives := countTerms(words, positiveTerms)
+	urgent := countTerms(words, urgencyTerms)
+	exclamations := strings.Count(text, "!")
+
+	// Escalation keywords and repeated exclamation marks weigh towards a negative mood
+	score := 0.35*float64(positives) - 0.35*float64(negatives) - 0.25*float64(len(analysis.EscalationKeywords))
+	if exclamations > 1 {
+		score -= 0.15
+	}
+	if score > 1 {
+		score = 1
+	} else if score < -1 {
+		score = -1
+	}
+	analysis.Score = score
+
+	switch {
+	case score <= -0.3:
+		analysis.Sentiment = models.SentimentNegative
+	case score >= 0.3:
+		analysis.Sentiment = models.SentimentPositive
+	}
+
+	switch {
+	case len(analysis.EscalationKeywords) > 0 || (urgent > 0 && analysis.Sentiment == models.SentimentNegative):
+		analysis.Urgency = models.UrgencyHigh
+	case urgent > 0 || analysis.Sentiment == models.SentimentNegative:
+		analysis.Urgency = models.UrgencyMedium
+	}
+
+	analysis.Escalate = len(analysis.EscalationKeywords) > 0 || analysis.Urgency == models.UrgencyHigh
+	return analysis, nil
+}
+
+// normalizeWords lowercases the text, folds umlauts and splits it into words
+func normalizeWords(text string) []string {
+	normalized := umlautReplacer.Replace(strings.ToLower(text))
+	return strings.FieldsFunc(normalized, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesTerm reports whether the words contain the term as whole words
+func matchesTerm(words []string, term string) bool {
+	termWords := strings.Fields(term)
+	for start := 0; start+len(termWords) <= len(words); start++ {
+		matched := true
+		for i, termWord := range termWords {
+			word := words[start+i]
+			if prefix, ok := strings.CutSuffix(termWord, "*"); ok {
+				matched = strings.HasPrefix(word, prefix)
+			} else {
+				matched = word == termWord
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeKeyword returns the label reported for a keyword found in the words: the label of
+// the escalation rule it matches, or the keyword itself. ok is false for keywords that do not
+// appear in the words as whole words, e.g. when the LLM paraphrased the customer.
+func normalizeKeyword(words []string, keyword string) (label string, ok bool) {
+	keywordWords := normalizeWords(keyword)
+	if len(keywordWords) == 0 || !matchesTerm(words, strings.Join(keywordWords, " ")) {
+		return "", false
+	}
+	for _, rule := range escalationRules {
+		if matchesTerm(keywordWords, rule.term) {
+			return rule.label, true
+		}
+	}
+	return strings.TrimSpace(keyword), true
+}
+
+// countTerms returns how many of the terms appear in the words
+func countTerms(words []string, terms []string) int {
+	count := 0
+	for _, term := range terms {
+		if matchesTerm(words, term) {
+			count++
+		}
+	}
+	return count
+}
+
+// LLMAnalyzer asks the OpenAI API to classify the turn
+type LLMAnalyzer struct{}
+
+// NewLLMAnalyzer creates an analyzer backed by the OpenAI API
+func NewLLMAnalyzer() *LLMAnalyzer {
+	return &LLMAnalyzer{}
+}
+
+// Analyze classifies the text with the LLM and merges in the local escalation keywords
+func (a *LLMAnalyzer) Analyze(ctx context.Context, text string) (models.TurnAnalysis, error) {
+	prompt := renderPrompt(PromptAnalysis, promptData{Text: text})
+
+	content, err := callOpenAIChat(ctx, "You are an assistant that analyzes the mood of German customer service calls.", prompt)
+	if err != nil {
+		return models.TurnAnalysis{}, err
+	}
+
+	var analysis models.TurnAnalysis
+	if err := json.Unmarshal([]byte(content), &analysis); err != nil {
+		return models.TurnAnalysis{}, fmt.Errorf("invalid analysis JSON: %w", err)
+	}
+
+	switch analysis.Sentiment {
+	case models.SentimentPositive, models.SentimentNeutral, models.SentimentNegative:
+	default:
+		return models.TurnAnalysis{}, fmt.Errorf("unknown sentiment %q", analysis.Sentiment)
+	}
+	switch analysis.Urgency {
+	case models.UrgencyLow, models.UrgencyMedium, models.UrgencyHigh:
+	default:
+		analysis.Urgency = models.UrgencyLow
+	}
+
+	// Keywords are cheap to detect locally, so never let the LLM miss them. Both lists are
+	// normalized like the rules match, so "kündigung" and "Kündigung" are reported once.
+	local, _ := NewRuleBasedAnalyzer().Analyze(ctx, text)
+	words := normalizeWords(text)
+	seen := make(map[string]bool)
+	keywords := []string{}
+	add := func(label string) {
+		if key := strings.Join(normalizeWords(label), " "); !seen[key] {
+			seen[key] = true
+			keywords = append(keywords, label)
+		}
+	}
+	for _, keyword := range analysis.EscalationKeywords {
+		if label, ok := normalizeKeyword(words, keyword); ok {
+			add(label)
+		}
+	}
+	for _, label := range local.EscalationKeywords {
+		add(label)
+	}
+	analysis.EscalationKeywords = keywords
+	if len(keywords) > 0 {
+		analysis.Urgency = models.UrgencyHigh
+	}
+	analysis.Escalate = len(keywords) > 0 || analysis.Urgency == models.UrgencyHigh
+	analysis.Source = "llm"
+	return analysis, nil
+}
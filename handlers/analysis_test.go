@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMatchesTerm(t *testing.T) {
+	tests := []struct {
+		text string
+		term string
+		want bool
+	}{
+		{"Das Gericht wird entscheiden", "gericht", true},
+		{"Der Router ist eingerichtet", "gericht", false},
+		{"Das ist an Sie gerichtet", "gericht", false},
+		{"Ich gehe vor Gericht!", "gericht", true},
+		{"Danke, das hilft", "danke", true},
+		{"Ein guter Gedanke", "danke", false},
+		{"Ich möchte kündigen", "kuendig*", true},
+		{"Die Kündigung ist raus", "kuendig*", true},
+		{"Ich habe gekündigt", "kuendig*", false},
+		{"Ich bin unzufrieden", "zufrieden*", false},
+		{"Ich bin sehr zufrieden", "zufrieden*", true},
+		{"Das passiert schon wieder", "schon wieder", true},
+		{"Schon. Wieder da?", "schon wieder", true},
+		{"Das ist schon wiederholt passiert", "schon wieder", false},
+		{"", "danke", false},
+	}
+	for _, tt := range tests {
+		if got := matchesTerm(normalizeWords(tt.text), tt.term); got != tt.want {
+			t.Errorf("matchesTerm(%q, %q) = %v, want %v", tt.text, tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestRuleBasedAnalyzer(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		sentiment string
+		urgency   string
+		keywords  []string
+	}{
+		{"set up router", "Der Router ist jetzt eingerichtet, guter Gedanke.", models.SentimentNeutral, models.UrgencyLow, []string{}},
+		{"thanks", "Vielen Dank, das war sehr hilfreich!", models.SentimentPositive, models.UrgencyLow, []string{}},
+		{"cancellation threat", "Ich kündige und gehe zum Anwalt!!", models.SentimentNegative, models.UrgencyHigh, []string{"Kündigung", "Anwalt"}},
+		{"court", "Dann sehen wir uns vor Gericht.", models.SentimentNeutral, models.UrgencyHigh, []string{"Gericht"}},
+		{"urgent", "Ich brauche das dringend.", models.SentimentNeutral, models.UrgencyMedium, []string{}},
+		{"angry and urgent", "Das ist eine Frechheit, ich brauche sofort Internet.", models.SentimentNegative, models.UrgencyHigh, []string{}},
+		{"unfriendly", "Ihr Kollege war unfreundlich.", models.SentimentNeutral, models.UrgencyLow, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis, err := NewRuleBasedAnalyzer().Analyze(context.Background(), tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if analysis.Sentiment != tt.sentiment || analysis.Urgency != tt.urgency {
+				t.Errorf("got %s/%s, want %s/%s", analysis.Sentiment, analysis.Urgency, tt.sentiment, tt.urgency)
+			}
+			if !reflect.DeepEqual(analysis.EscalationKeywords, tt.keywords) {
+				t.Errorf("got keywords %v, want %v", analysis.EscalationKeywords, tt.keywords)
+			}
+		})
+	}
+}
+
+func TestLLMAnalyzerNormalizesKeywords(t *testing.T) {
+	withOpenAIStub(t, `{
+		"sentiment": "negative",
+		"score": -0.8,
+		"urgency": "high",
+		"escalation_keywords": ["kündigung", "KUENDIGUNG", "Frechheit", "frechheit", "anwalt", "Gericht", "Verbraucher"]
+	}`, 10)
+
+	analysis, err := NewLLMAnalyzer().Analyze(context.Background(), "Das ist eine Frechheit! Die Kündigung geht raus, mein Anwalt meldet sich. Ich kündige!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rule keywords get the rule's label, others keep their first spelling, and keywords
+	// missing from the statement are dropped
+	want := []string{"Kündigung", "Frechheit", "Anwalt"}
+	if !reflect.DeepEqual(analysis.EscalationKeywords, want) {
+		t.Errorf("got keywords %q, want %q", analysis.EscalationKeywords, want)
+	}
+	if !analysis.Escalate || analysis.Source != "llm" {
+		t.Errorf("got escalate %v from %q, want an escalation from the llm", analysis.Escalate, analysis.Source)
+	}
+}
+
+func TestNormalizeKeyword(t *testing.T) {
+	words := normalizeWords("Ich gehe vor Gericht, das ist eine Frechheit. Meine Kündigung!")
+	tests := []struct {
+		keyword string
+		label   string
+		ok      bool
+	}{
+		{"gericht", "Gericht", true},
+		{"GERICHT", "Gericht", true},
+		{"Kuendigung", "Kündigung", true},
+		{" Frechheit ", "Frechheit", true},
+		{"vor Gericht", "Gericht", true},
+		{"Anwalt", "", false},
+		{"frech", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		label, ok := normalizeKeyword(words, tt.keyword)
+		if label != tt.label || ok != tt.ok {
+			t.Errorf("normalizeKeyword(%q) = %q, %v; want %q, %v", tt.keyword, label, ok, tt.label, tt.ok)
+		}
+	}
+}
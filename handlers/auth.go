@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication settings
+const (
+	// wsAuthProtocol is offered by browsers as "Sec-WebSocket-Protocol: bearer, <token>"
+	wsAuthProtocol = "bearer"
+	// ticketTTL is how long a WebSocket ticket can be redeemed
+	ticketTTL = 30 * time.Second
+	// jwtLeeway tolerates small clock differences when checking exp and nbf
+	jwtLeeway = 30 * time.Second
+)
+
+var (
+	// jwtKey verifies HS256 bearer tokens; JWT authentication is disabled when it is empty
+	jwtKey []byte
+
+	// apiKeys maps the SHA-256 hex of an API key to its identity; apiKeyTeams maps the
+	// usernames of the key file to their teams
+	apiKeys      = make(map[string]models.Identity)
+	apiKeyTeams  = make(map[string]string)
+	apiKeysMutex sync.RWMutex
+
+	// Single-use WebSocket tickets by ID
+	wsTickets      = make(map[string]wsTicket)
+	wsTicketsMutex sync.Mutex
+)
+
+// authLog logs authentication, authorization and origin checks
+var authLog = logging.For("auth")
+
+// errUnauthenticated is returned when a request carries no valid credentials
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+// identityKey is the request context key of the authenticated identity
+type identityKey struct{}
+
+// wsTicket is a short-lived credential for browsers that cannot set headers on WebSocket requests
+type wsTicket struct {
+	identity  models.Identity
+	expiresAt time.Time
+}
+
+// ConfigureJWT enables HS256 bearer tokens signed with key
+func ConfigureJWT(key string) {
+	jwtKey = []byte(key)
+	authLog.Info("JWT authentication enabled")
+}
+
+// LoadAPIKeys reads API keys from a JSON file of the form {"key": {"username": "anna", "role": "agent", "team": "berlin"}}
+func LoadAPIKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading API keys: %w", err)
+	}
+
+	var keys map[string]models.Identity
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("parsing API keys: %w", err)
+	}
+
+	hashed := make(map[string]models.Identity, len(keys))
+	teams := make(map[string]string)
+	for key, identity := range keys {
+		if key == "" || identity.Username == "" {
+			return fmt.Errorf("API keys need a key and a username")
+		}
+		identity.Method = "api_key"
+		hashed[hashAPIKey(key)] = identity
+		if identity.Team != "" {
+			teams[identity.Username] = identity.Team
+		}
+	}
+
+	apiKeysMutex.Lock()
+	apiKeys = hashed
+	apiKeyTeams = teams
+	apiKeysMutex.Unlock()
+
+	authLog.Info("API keys loaded", "keys", len(hashed))
+	return nil
+}
+
+// AuthEnabled reports whether any authentication method is configured
+func AuthEnabled() bool {
+	apiKeysMutex.RLock()
+	defer apiKeysMutex.RUnlock()
+	return len(jwtKey) > 0 || len(apiKeys) > 0
+}
+
+// hashAPIKey returns the lookup hash of an API key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate is a mux middleware that attaches the caller's identity to the request context
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !AuthEnabled() || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, err := authenticateRequest(r)
+		if err != nil {
+			authLog.WarnContext(r.Context(), "authentication failed", logging.Err(err),
+				"method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if identity.Role == "" {
+			identity.Role = models.RoleAgent
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+	})
+}
+
+// authenticateRequest checks the credentials of a request in order of preference
+func authenticateRequest(r *http.Request) (models.Identity, error) {
+	if value := r.Header.Get("Authorization"); value != "" {
+		token, found := strings.CutPrefix(value, "Bearer ")
+		if !found {
+			return models.Identity{}, errors.New("unsupported authorization scheme")
+		}
+		return authenticateToken(strings.TrimSpace(token))
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return authenticateAPIKey(key)
+	}
+
+	// Browsers cannot set headers on WebSocket requests
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		if token := websocketProtocolToken(r); token != "" {
+			return authenticateToken(token)
+		}
+		if ticket := r.URL.Query().Get("Ticket"); ticket != "" {
+			return redeemTicket(ticket)
+		}
+	}
+	return models.Identity{}, errUnauthenticated
+}
+
+// authenticateToken accepts either a JWT or an API key as bearer token
+func authenticateToken(token string) (models.Identity, error) {
+	if strings.Count(token, ".") == 2 {
+		return verifyJWT(token)
+	}
+	return authenticateAPIKey(token)
+}
+
+// authenticateAPIKey looks up the identity of an API key
+func authenticateAPIKey(key string) (models.Identity, error) {
+	apiKeysMutex.RLock()
+	defer apiKeysMutex.RUnlock()
+	identity, exists := apiKeys[hashAPIKey(key)]
+	if !exists {
+		return models.Identity{}, errUnauthenticated
+	}
+	return identity, nil
+}
+
+// websocketProtocolToken extracts the token from "Sec-WebSocket-Protocol: bearer, <token>"
+func websocketProtocolToken(r *http.Request) string {
+	var protocols []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == wsAuthProtocol {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// verifyJWT checks an HS256 token and returns the identity in its claims
+func verifyJWT(token string) (models.Identity, error) {
+	if len(jwtKey) == 0 {
+		return models.Identity{}, errors.New("JWT authentication is not configured")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return models.Identity{}, errors.New("malformed JWT")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return models.Identity{}, err
+	}
+	// Only the configured symmetric algorithm is accepted, never "none"
+	if header.Algorithm != "HS256" {
+		return models.Identity{}, fmt.Errorf("unsupported JWT algorithm %q", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return models.Identity{}, errors.New("malformed JWT signature")
+	}
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return models.Identity{}, errors.New("invalid JWT signature")
+	}
+
+	var claims struct {
+		Subject   string `json:"sub"`
+		Role      string `json:"role"`
+		Team      string `json:"team"`
+		ExpiresAt int64  `json:"exp"`
+		NotBefore int64  `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return models.Identity{}, err
+	}
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return models.Identity{}, errors.New("JWT is expired or has no exp claim")
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return models.Identity{}, errors.New("JWT is not valid yet")
+	}
+	if claims.Subject == "" {
+		return models.Identity{}, errors.New("JWT has no sub claim")
+	}
+
+	return models.Identity{Username: claims.Subject, Role: claims.Role, Team: claims.Team, Method: "jwt"}, nil
+}
+
+// decodeJWTPart decodes a base64url JSON segment of a JWT
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed JWT")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed JWT")
+	}
+	return nil
+}
+
+// HandleCreateTicket issues a single-use ticket for opening the speech WebSocket
+func HandleCreateTicket(w http.ResponseWriter, r *http.Request) {
+	identity, ok := IdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication is not enabled", http.StatusNotFound)
+		return
+	}
+
+	id := newID()
+	expiresAt := time.Now().Add(ticketTTL)
+	identity.Method = "ticket"
+
+	wsTicketsMutex.Lock()
+	// Drop expired tickets that were never redeemed
+	for ticketID, ticket := range wsTickets {
+		if time.Now().After(ticket.expiresAt) {
+			delete(wsTickets, ticketID)
+		}
+	}
+	wsTickets[id] = wsTicket{identity: identity, expiresAt: expiresAt}
+	wsTicketsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ticket":     id,
+		"expires_at": expiresAt,
+	})
+}
+
+// redeemTicket consumes a WebSocket ticket
+func redeemTicket(id string) (models.Identity, error) {
+	wsTicketsMutex.Lock()
+	defer wsTicketsMutex.Unlock()
+	ticket, exists := wsTickets[id]
+	delete(wsTickets, id)
+	if !exists || time.Now().After(ticket.expiresAt) {
+		return models.Identity{}, errors.New("unknown or expired ticket")
+	}
+	return ticket.identity, nil
+}
+
+// IdentityFromContext returns the authenticated identity of a request
+func IdentityFromContext(ctx context.Context) (models.Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(models.Identity)
+	return identity, ok
+}
+
+// requestUsername returns the user a request acts for. With authentication enabled this is
+// the authenticated identity; ok is false when the client asked to act for someone else.
+// Without authentication the client-supplied name is trusted as before.
+func requestUsername(r *http.Request, requested string) (username string, ok bool) {
+	identity, authenticated := IdentityFromContext(r.Context())
+	if !authenticated {
+		return requested, true
+	}
+	if requested != "" && requested != identity.Username {
+		authLog.WarnContext(r.Context(), "request for another user denied", "username", identity.Username, "requested", requested)
+		return "", false
+	}
+	return identity.Username, true
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTKey = "test-secret"
+
+// withAuth enables JWT authentication and the given API keys for one test
+func withAuth(t *testing.T, keys map[string]models.Identity) {
+	t.Helper()
+	previousKey := jwtKey
+	apiKeysMutex.Lock()
+	previousKeys, previousTeams := apiKeys, apiKeyTeams
+	apiKeysMutex.Unlock()
+	t.Cleanup(func() {
+		jwtKey = previousKey
+		apiKeysMutex.Lock()
+		apiKeys, apiKeyTeams = previousKeys, previousTeams
+		apiKeysMutex.Unlock()
+	})
+
+	ConfigureJWT(testJWTKey)
+	loadTestAPIKeys(t, keys)
+}
+
+// loadTestAPIKeys writes the keys to a file and loads it
+func loadTestAPIKeys(t *testing.T, keys map[string]models.Identity) {
+	t.Helper()
+	data, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadAPIKeys(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signJWT builds a token from a header and claims, signed with key
+func signJWT(header, claims map[string]interface{}, key string) string {
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := encode(header) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// authenticatedAs runs a request through Authenticate and returns the status and identity seen by the handler
+func authenticatedAs(req *http.Request) (int, models.Identity) {
+	var identity models.Identity
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = IdentityFromContext(r.Context())
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, identity
+}
+
+func TestVerifyJWT(t *testing.T) {
+	withAuth(t, nil)
+	hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	now := time.Now()
+	claims := func(modify func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"sub": "anna", "role": models.RoleSupervisor, "team": "berlin", "exp": now.Add(time.Hour).Unix()}
+		modify(c)
+		return c
+	}
+	valid := signJWT(hs256, claims(func(c map[string]interface{}) {}), testJWTKey)
+	segments := strings.Split(valid, ".")
+	forged := strings.Split(signJWT(hs256, claims(func(c map[string]interface{}) { c["sub"] = "ben" }), "other-secret"), ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"bad signature", signJWT(hs256, claims(func(c map[string]interface{}) {}), "other-secret"), true},
+		{"tampered claims", segments[0] + "." + forged[1] + "." + segments[2], true},
+		{"alg none", signJWT(map[string]interface{}{"alg": "none"}, claims(func(c map[string]interface{}) {}), testJWTKey), true},
+		{"alg none without signature", strings.Join([]string{
+			base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)), segments[1], "",
+		}, "."), true},
+		{"alg RS256", signJWT(map[string]interface{}{"alg": "RS256"}, claims(func(c map[string]interface{}) {}), testJWTKey), true},
+		{"missing exp", signJWT(hs256, claims(func(c map[string]interface{}) { delete(c, "exp") }), testJWTKey), true},
+		{"expired", signJWT(hs256, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Minute).Unix() }), testJWTKey), true},
+		{"expired within leeway", signJWT(hs256, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-jwtLeeway + 5*time.Second).Unix() }), testJWTKey), false},
+		{"expired just past leeway", signJWT(hs256, claims(func(c map[string]interface{}) { c["exp"] = now.Add(-jwtLeeway - 5*time.Second).Unix() }), testJWTKey), true},
+		{"nbf in the future", signJWT(hs256, claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Minute).Unix() }), testJWTKey), true},
+		{"nbf within leeway", signJWT(hs256, claims(func(c map[string]interface{}) { c["nbf"] = now.Add(jwtLeeway - 5*time.Second).Unix() }), testJWTKey), false},
+		{"nbf just past leeway", signJWT(hs256, claims(func(c map[string]interface{}) { c["nbf"] = now.Add(jwtLeeway + 5*time.Second).Unix() }), testJWTKey), true},
+		{"empty sub", signJWT(hs256, claims(func(c map[string]interface{}) { c["sub"] = "" }), testJWTKey), true},
+		{"two segments", segments[0] + "." + segments[1], true},
+		{"four segments", valid + ".extra", true},
+		{"bad base64 header", "!!!." + segments[1] + "." + segments[2], true},
+		{"bad base64 claims", segments[0] + ".!!!." + segments[2], true},
+		{"bad base64 signature", segments[0] + "." + segments[1] + ".!!!", true},
+		{"header is not JSON", base64.RawURLEncoding.EncodeToString([]byte("alg")) + "." + segments[1] + "." + segments[2], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/conversations", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			status, identity := authenticatedAs(req)
+
+			if tt.wantErr {
+				if status != http.StatusUnauthorized {
+					t.Errorf("got status %d, want 401", status)
+				}
+				return
+			}
+			if status != http.StatusOK {
+				t.Fatalf("got status %d, want 200", status)
+			}
+			want := models.Identity{Username: "anna", Role: models.RoleSupervisor, Team: "berlin", Method: "jwt"}
+			if identity != want {
+				t.Errorf("got identity %+v, want %+v", identity, want)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSegmentCount(t *testing.T) {
+	withAuth(t, nil)
+	// Tokens without exactly two dots are looked up as API keys; verifyJWT still rejects them itself
+	for _, token := range []string{"", "a", "a.b", "a.b.c.d"} {
+		if _, err := verifyJWT(token); err == nil {
+			t.Errorf("%q: expected an error", token)
+		}
+	}
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	withAuth(t, map[string]models.Identity{
+		"agent-key": {Username: "anna", Team: "berlin"},
+		"admin-key": {Username: "ben", Role: models.RoleAdmin},
+	})
+	token := signJWT(map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"sub": "carl", "exp": time.Now().Add(time.Hour).Unix()}, testJWTKey)
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		status   int
+		username string
+	}{
+		{"no credentials", nil, http.StatusUnauthorized, ""},
+		{"API key header", map[string]string{"X-API-Key": "agent-key"}, http.StatusOK, "anna"},
+		{"API key as bearer token", map[string]string{"Authorization": "Bearer admin-key"}, http.StatusOK, "ben"},
+		{"unknown API key", map[string]string{"X-API-Key": "guessed-key"}, http.StatusUnauthorized, ""},
+		{"JWT bearer token", map[string]string{"Authorization": "Bearer " + token}, http.StatusOK, "carl"},
+		{"basic scheme", map[string]string{"Authorization": "Basic YW5uYTpwdw=="}, http.StatusUnauthorized, ""},
+		{
+			"WebSocket protocol token",
+			map[string]string{"Upgrade": "websocket", "Sec-WebSocket-Protocol": "bearer, " + token},
+			http.StatusOK, "carl",
+		},
+		{
+			"WebSocket protocol API key",
+			map[string]string{"Upgrade": "websocket", "Sec-WebSocket-Protocol": "bearer, agent-key"},
+			http.StatusOK, "anna",
+		},
+		{
+			"WebSocket protocol without token",
+			map[string]string{"Upgrade": "websocket", "Sec-WebSocket-Protocol": "bearer"},
+			http.StatusUnauthorized, "",
+		},
+		{
+			"protocol token on a plain request",
+			map[string]string{"Sec-WebSocket-Protocol": "bearer, " + token},
+			http.StatusUnauthorized, "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/speech", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			status, identity := authenticatedAs(req)
+			if status != tt.status || identity.Username != tt.username {
+				t.Errorf("got status %d, username %q; want %d, %q", status, identity.Username, tt.status, tt.username)
+			}
+		})
+	}
+}
+
+func TestAPIKeyRoleDefaultsToAgent(t *testing.T) {
+	withAuth(t, map[string]models.Identity{"agent-key": {Username: "anna"}})
+	req := httptest.NewRequest(http.MethodGet, "/api/conversations", nil)
+	req.Header.Set("X-API-Key", "agent-key")
+	if _, identity := authenticatedAs(req); identity.Role != models.RoleAgent || identity.Method != "api_key" {
+		t.Errorf("got %+v, want an agent authenticated by API key", identity)
+	}
+}
+
+func TestRevokedAPIKey(t *testing.T) {
+	withAuth(t, map[string]models.Identity{
+		"old-key":  {Username: "anna"},
+		"kept-key": {Username: "ben"},
+	})
+	// Reloading the key file without the key revokes it
+	loadTestAPIKeys(t, map[string]models.Identity{"kept-key": {Username: "ben"}})
+
+	for key, want := range map[string]int{"old-key": http.StatusUnauthorized, "kept-key": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/api/conversations", nil)
+		req.Header.Set("X-API-Key", key)
+		if status, _ := authenticatedAs(req); status != want {
+			t.Errorf("%s: got status %d, want %d", key, status, want)
+		}
+	}
+}
+
+// createTicket issues a ticket for the identity and returns its ID and expiry
+func createTicket(t *testing.T, identity models.Identity) (string, time.Time) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/ws-ticket", nil)
+	req = req.WithContext(context.WithValue(req.Context(), identityKey{}, identity))
+	rec := httptest.NewRecorder()
+	HandleCreateTicket(rec, req)
+	var response struct {
+		Ticket    string    `json:"ticket"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Ticket, response.ExpiresAt
+}
+
+// ticketRequest is a WebSocket upgrade request carrying a ticket
+func ticketRequest(ticket string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/speech?Ticket="+ticket, nil)
+	req.Header.Set("Upgrade", "websocket")
+	return req
+}
+
+func TestTicketIsSingleUse(t *testing.T) {
+	withAuth(t, nil)
+	ticket, _ := createTicket(t, models.Identity{Username: "anna", Role: models.RoleAgent, Team: "berlin"})
+
+	status, identity := authenticatedAs(ticketRequest(ticket))
+	if status != http.StatusOK || identity.Username != "anna" || identity.Team != "berlin" || identity.Method != "ticket" {
+		t.Fatalf("first use: got status %d, identity %+v", status, identity)
+	}
+	if status, _ := authenticatedAs(ticketRequest(ticket)); status != http.StatusUnauthorized {
+		t.Errorf("second use: got status %d, want 401", status)
+	}
+	if status, _ := authenticatedAs(ticketRequest("unknown")); status != http.StatusUnauthorized {
+		t.Errorf("unknown ticket: got status %d, want 401", status)
+	}
+
+	// Tickets are only accepted on WebSocket upgrades
+	ticket, _ = createTicket(t, models.Identity{Username: "anna"})
+	if status, _ := authenticatedAs(httptest.NewRequest(http.MethodGet, "/api/conversations?Ticket="+ticket, nil)); status != http.StatusUnauthorized {
+		t.Errorf("ticket on a plain request: got status %d, want 401", status)
+	}
+}
+
+func TestTicketExpires(t *testing.T) {
+	withAuth(t, nil)
+	before := time.Now()
+	ticket, expiresAt := createTicket(t, models.Identity{Username: "anna"})
+	if expiresAt.Before(before.Add(ticketTTL)) || expiresAt.After(time.Now().Add(ticketTTL)) {
+		t.Errorf("ticket expires at %v, want %v after issuing", expiresAt, ticketTTL)
+	}
+
+	// Move the ticket past its lifetime
+	wsTicketsMutex.Lock()
+	stored := wsTickets[ticket]
+	stored.expiresAt = time.Now().Add(-time.Second)
+	wsTickets[ticket] = stored
+	wsTicketsMutex.Unlock()
+
+	if status, _ := authenticatedAs(ticketRequest(ticket)); status != http.StatusUnauthorized {
+		t.Errorf("expired ticket: got status %d, want 401", status)
+	}
+}
+
+func TestRequestUsernameRejectsOtherUsers(t *testing.T) {
+	authenticated := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/summary", strings.NewReader(body))
+		return req.WithContext(context.WithValue(req.Context(), identityKey{}, models.Identity{Username: "anna", Role: models.RoleAgent}))
+	}
+
+	for name, tt := range map[string]struct {
+		requested string
+		username  string
+		ok        bool
+	}{
+		"own name":     {"anna", "anna", true},
+		"no name":      {"", "anna", true},
+		"another user": {"ben", "", false},
+	} {
+		username, ok := requestUsername(authenticated(""), tt.requested)
+		if username != tt.username || ok != tt.ok {
+			t.Errorf("%s: got %q, %v; want %q, %v", name, username, ok, tt.username, tt.ok)
+		}
+	}
+
+	// Handlers answer 403 before doing any work
+	rec := httptest.NewRecorder()
+	HandleGenerateSummary(rec, authenticated(`{"username": "ben"}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultBudgetTeam holds the budget of teams without their own entry and meters callers without a team
+const defaultBudgetTeam = "default"
+
+// errBudgetExceeded is returned for OpenAI requests of a team that used up its monthly budget
+var errBudgetExceeded = errors.New("OpenAI budget exceeded")
+
+// budgets maps team names to their monthly OpenAI budget. Teams without an entry use the
+// "default" budget; without that, their usage is metered but not limited.
+var budgets = &serviceStore[models.OpenAIBudget]{
+	name:     "OpenAI budgets",
+	param:    "team",
+	log:      assistLog,
+	validate: validateBudget,
+	empty:    models.OpenAIBudget{},
+}
+
+// openAIUsage counts the chat completion tokens of each team in the current month.
+// It is kept in memory, so a restart starts the month over.
+var openAIUsage = struct {
+	sync.Mutex
+	month  string
+	tokens map[string]int64
+}{tokens: make(map[string]int64)}
+
+// budgetTeamKey is the context key for the team OpenAI requests are charged to
+type budgetTeamKey struct{}
+
+// LoadBudgets reads a JSON file of the form {"team": {"monthly_tokens": 1000000}} into the budgets.
+// Changes made through the admin endpoint are written back to the same file.
+func LoadBudgets(path string) error {
+	return budgets.Load(path)
+}
+
+// validateBudget checks one budget entry
+func validateBudget(team string, budget models.OpenAIBudget) error {
+	if budget.MonthlyTokens <= 0 {
+		return fmt.Errorf("monthly_tokens must be positive")
+	}
+	return nil
+}
+
+// withBudgetTeam charges the OpenAI requests made with ctx to team. Sessions and jobs outlive
+// their request, so they carry the team explicitly instead of the caller's identity.
+func withBudgetTeam(ctx context.Context, team string) context.Context {
+	return context.WithValue(ctx, budgetTeamKey{}, team)
+}
+
+// budgetTeam returns the team OpenAI requests made with ctx are charged to
+func budgetTeam(ctx context.Context) string {
+	team, ok := ctx.Value(budgetTeamKey{}).(string)
+	if !ok {
+		identity, _ := IdentityFromContext(ctx)
+		team = identity.Team
+	}
+	if team == "" {
+		return defaultBudgetTeam
+	}
+	return team
+}
+
+// budgetFor returns the budget that applies to a team
+func budgetFor(team string) (models.OpenAIBudget, bool) {
+	if budget, exists := budgets.get(team); exists {
+		return budget, true
+	}
+	return budgets.get(defaultBudgetTeam)
+}
+
+// currentMonth returns the month usage is counted in, e.g. "2024-05"
+func currentMonth(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
+
+// usedTokens returns the tokens a team used this month
+func usedTokens(team string) (month string, tokens int64) {
+	openAIUsage.Lock()
+	defer openAIUsage.Unlock()
+	month = currentMonth(time.Now())
+	if openAIUsage.month != month {
+		return month, 0
+	}
+	return month, openAIUsage.tokens[team]
+}
+
+// checkBudget returns errBudgetExceeded when the team of ctx used up its monthly budget
+func checkBudget(ctx context.Context) error {
+	team := budgetTeam(ctx)
+	budget, limited := budgetFor(team)
+	if !limited {
+		return nil
+	}
+	if _, used := usedTokens(team); used >= budget.MonthlyTokens {
+		assistLog.WarnContext(ctx, "openai budget exceeded", "team", team, "used_tokens", used, "monthly_tokens", budget.MonthlyTokens)
+		return errBudgetExceeded
+	}
+	return nil
+}
+
+// recordUsage adds the tokens of a completed request to the team of ctx
+func recordUsage(ctx context.Context, tokens int64) {
+	openAIUsage.Lock()
+	defer openAIUsage.Unlock()
+	if month := currentMonth(time.Now()); openAIUsage.month != month {
+		openAIUsage.month = month
+		openAIUsage.tokens = make(map[string]int64)
+	}
+	openAIUsage.tokens[budgetTeam(ctx)] += tokens
+}
+
+// openAIErrorStatus returns the HTTP status for a failed OpenAI request
+func openAIErrorStatus(err error) int {
+	if errors.Is(err, errBudgetExceeded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleListBudgets returns the budgets of all teams
+func HandleListBudgets(w http.ResponseWriter, r *http.Request) {
+	budgets.handleList(w, r)
+}
+
+// HandleGetBudget returns the budget of one team
+func HandleGetBudget(w http.ResponseWriter, r *http.Request) {
+	budgets.handleGet(w, r)
+}
+
+// HandlePutBudget replaces the budget of one team
+func HandlePutBudget(w http.ResponseWriter, r *http.Request) {
+	budgets.handlePut(w, r)
+}
+
+// HandleDeleteBudget removes the budget of one team
+func HandleDeleteBudget(w http.ResponseWriter, r *http.Request) {
+	budgets.handleDelete(w, r)
+}
+
+// HandleGetBudgetUsage returns the tokens a team used this month and the budget that applies to it
+func HandleGetBudgetUsage(w http.ResponseWriter, r *http.Request) {
+	team := budgets.key(r)
+	month, used := usedTokens(team)
+	budget, _ := budgetFor(team)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.OpenAIUsage{Team: team, Month: month, UsedTokens: used, MonthlyTokens: budget.MonthlyTokens})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withBudgets stores budgets for one test and starts it without recorded usage
+func withBudgets(t *testing.T, entries map[string]int64) {
+	t.Helper()
+	resetUsage := func() {
+		openAIUsage.Lock()
+		openAIUsage.month = ""
+		openAIUsage.tokens = make(map[string]int64)
+		openAIUsage.Unlock()
+	}
+	resetUsage()
+	err := budgets.update(func(stored map[string]models.OpenAIBudget) {
+		for team, tokens := range entries {
+			stored[team] = models.OpenAIBudget{MonthlyTokens: tokens}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		budgets.reset()
+		resetUsage()
+	})
+}
+
+func TestBudgetLimitsTeam(t *testing.T) {
+	stub := withOpenAIStub(t, `{}`, 60)
+	withBudgets(t, map[string]int64{"support": 100})
+	support := withBudgetTeam(context.Background(), "support")
+
+	for i := 0; i < 2; i++ {
+		if _, err := callOpenAIChat(support, defaultSystemPrompt, "Hallo"); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if _, err := callOpenAIChat(support, defaultSystemPrompt, "Hallo"); !errors.Is(err, errBudgetExceeded) {
+		t.Errorf("got error %v, want errBudgetExceeded", err)
+	}
+	if n := len(stub.received()); n != 2 {
+		t.Errorf("stub received %d requests, want 2", n)
+	}
+
+	// Teams without a budget and no default budget are metered but not limited
+	sales := withBudgetTeam(context.Background(), "sales")
+	for i := 0; i < 3; i++ {
+		if _, err := callOpenAIChat(sales, defaultSystemPrompt, "Hallo"); err != nil {
+			t.Fatalf("unlimited team: %v", err)
+		}
+	}
+	if _, used := usedTokens("sales"); used != 180 {
+		t.Errorf("got %d tokens used, want 180", used)
+	}
+}
+
+func TestBudgetTeam(t *testing.T) {
+	withIdentity := context.WithValue(context.Background(), identityKey{}, models.Identity{Username: "tina", Team: "billing"})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"no team", context.Background(), defaultBudgetTeam},
+		{"identity", withIdentity, "billing"},
+		{"explicit team wins", withBudgetTeam(withIdentity, "support"), "support"},
+		{"explicit empty team", withBudgetTeam(withIdentity, ""), defaultBudgetTeam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := budgetTeam(tt.ctx); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExceededBudgetReturns429(t *testing.T) {
+	withOpenAIStub(t, `{"corrected": "Guten Tag", "issues": []}`, 10)
+	withBudgets(t, map[string]int64{defaultBudgetTeam: 10})
+
+	check := func() int {
+		rec := httptest.NewRecorder()
+		HandleCheckGrammar(rec, httptest.NewRequest(http.MethodPost, "/api/grammar", strings.NewReader(`{"username": "tina", "text": "Guten Tag"}`)))
+		return rec.Code
+	}
+	if code := check(); code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	if code := check(); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want 429", code)
+	}
+}
+
+func TestValidateBudget(t *testing.T) {
+	if err := validateBudget("support", models.OpenAIBudget{MonthlyTokens: 0}); err == nil {
+		t.Error("expected an error for a zero budget")
+	}
+	if err := validateBudget("support", models.OpenAIBudget{MonthlyTokens: 1000}); err != nil {
+		t.Error(err)
+	}
+}
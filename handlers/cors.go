@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/rs/cors"
+	"net/http"
+	"strings"
+)
+
+// OriginPolicy lists the browser origins, methods and headers allowed to call the API
+type OriginPolicy struct {
+	// AllowedOrigins holds exact origins, "*" or wildcards for one host label below a registrable
+	// domain, like "https://*.example.com"
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+}
+
+// originPolicy is shared by the CORS middleware and the WebSocket origin check.
+// Until it is configured, no browser origin is allowed.
+var originPolicy OriginPolicy
+
+// Validate rejects policies that browsers would refuse or that are unsafe
+func (p OriginPolicy) Validate() error {
+	if len(p.AllowedOrigins) == 0 {
+		return fmt.Errorf("at least one allowed origin is required")
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("credentials cannot be allowed for the wildcard origin")
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("allowed origin %q must start with http:// or https://", origin)
+		}
+		if strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("allowed origin %q may contain at most one wildcard", origin)
+		}
+		// A broad wildcard would admit any site, which is never wanted and must not get credentials
+		if prefix, suffix, found := strings.Cut(origin, "*"); found {
+			if err := validateWildcardOrigin(prefix, suffix); err != nil {
+				return fmt.Errorf("allowed origin %q: %w", origin, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateWildcardOrigin checks that a wildcard replaces the first label of a host below a
+// registrable domain, as in "https://*.example.com" or "https://*.example.com:8443"
+func validateWildcardOrigin(prefix, suffix string) error {
+	if prefix != "http://" && prefix != "https://" {
+		return fmt.Errorf("the wildcard must replace the first label of the host, e.g. https://*.example.com")
+	}
+	domain, port, hasPort := strings.Cut(strings.TrimPrefix(suffix, "."), ":")
+	labels := strings.Split(domain, ".")
+	if !strings.HasPrefix(suffix, ".") || len(labels) < 2 || strings.Contains(domain, "/") {
+		return fmt.Errorf("the wildcard must be followed by a registrable domain, e.g. .example.com")
+	}
+	for _, label := range labels {
+		if label == "" {
+			return fmt.Errorf("the wildcard must be followed by a registrable domain, e.g. .example.com")
+		}
+	}
+	if hasPort && (port == "" || strings.Trim(port, "0123456789") != "") {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// ConfigureOriginPolicy sets the origin policy after validating it
+func ConfigureOriginPolicy(policy OriginPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid CORS policy: %w", err)
+	}
+	originPolicy = policy
+	authLog.Info("origin policy configured", "allowed_origins", policy.AllowedOrigins, "allow_credentials", policy.AllowCredentials)
+	return nil
+}
+
+// GetOriginPolicy returns the configured origin policy
+func GetOriginPolicy() OriginPolicy {
+	return originPolicy
+}
+
+// CORS wraps next with the CORS middleware for the policy. Preflight and actual requests
+// from origins outside the policy get no CORS headers, so browsers block them.
+func (p OriginPolicy) CORS(next http.Handler) http.Handler {
+	return cors.New(cors.Options{
+		AllowOriginFunc:  p.AllowsOrigin,
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		ExposedHeaders:   []string{"Content-Disposition", "X-Request-ID"},
+		AllowCredentials: p.AllowCredentials,
+		MaxAge:           86400, // 24 hours
+	}).Handler(next)
+}
+
+// AllowsOrigin reports whether the policy admits a browser origin
+func (p OriginPolicy) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// The wildcard matches exactly one host label
+		if prefix, suffix, found := strings.Cut(allowed, "*"); found &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "./:") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebSocketOrigin applies the origin policy to WebSocket upgrades.
+// Requests without an Origin header come from non-browser clients and are allowed.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || originPolicy.AllowsOrigin(origin) {
+		return true
+	}
+	authLog.WarnContext(r.Context(), "websocket origin rejected", "origin", origin, "remote_addr", r.RemoteAddr)
+	return false
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOriginPolicyAllowsOrigin(t *testing.T) {
+	policy := OriginPolicy{AllowedOrigins: []string{"https://agent.example.com", "https://*.callcenter.example"}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://agent.example.com", true},
+		{"HTTPS://Agent.Example.COM", true},
+		{"https://berlin.callcenter.example", true},
+		{"https://BERLIN.callcenter.example", true},
+		{"http://agent.example.com", false},
+		{"https://agent.example.com.evil.test", false},
+		{"https://evil.test", false},
+		{"https://callcenter.example", false},
+		{"https://.callcenter.example", false},
+		{"https://evilcallcenter.example", false},
+		// The wildcard matches one label, not several or a different port
+		{"https://a.b.callcenter.example", false},
+		{"https://evil.test/.callcenter.example", false},
+		{"https://evil.test:443.callcenter.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		if got := policy.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !(OriginPolicy{AllowedOrigins: []string{"*"}}).AllowsOrigin("https://anything.test") {
+		t.Error("the * origin should allow every origin")
+	}
+	if (OriginPolicy{}).AllowsOrigin("https://agent.example.com") {
+		t.Error("an empty policy should reject every origin")
+	}
+}
+
+func TestOriginPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  OriginPolicy
+		wantErr string
+	}{
+		{"exact origins", OriginPolicy{AllowedOrigins: []string{"https://agent.example.com"}, AllowCredentials: true}, ""},
+		{"wildcard subdomain", OriginPolicy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, ""},
+		{"star without credentials", OriginPolicy{AllowedOrigins: []string{"*"}}, ""},
+		{"star with credentials", OriginPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "credentials"},
+		{"no origins", OriginPolicy{}, "at least one"},
+		{"missing scheme", OriginPolicy{AllowedOrigins: []string{"agent.example.com"}}, "http:// or https://"},
+		{"two wildcards", OriginPolicy{AllowedOrigins: []string{"https://*.*.example.com"}}, "at most one wildcard"},
+		{"wildcard subdomain with port", OriginPolicy{AllowedOrigins: []string{"https://*.example.com:8443"}}, ""},
+		{"scheme-only wildcard", OriginPolicy{AllowedOrigins: []string{"https://*"}}, "registrable domain"},
+		{"scheme-only wildcard with credentials", OriginPolicy{AllowedOrigins: []string{"https://*"}, AllowCredentials: true}, "registrable domain"},
+		{"top-level domain wildcard", OriginPolicy{AllowedOrigins: []string{"https://*.com"}}, "registrable domain"},
+		{"wildcard without dot", OriginPolicy{AllowedOrigins: []string{"https://*example.com"}}, "registrable domain"},
+		{"wildcard inside a label", OriginPolicy{AllowedOrigins: []string{"https://agent-*.example.com"}}, "first label"},
+		{"wildcard in a deeper label", OriginPolicy{AllowedOrigins: []string{"https://agent.*.example.com"}}, "first label"},
+		{"empty label", OriginPolicy{AllowedOrigins: []string{"https://*..com"}}, "registrable domain"},
+		{"wildcard with path", OriginPolicy{AllowedOrigins: []string{"https://*.example.com/app"}}, "registrable domain"},
+		{"wildcard with bad port", OriginPolicy{AllowedOrigins: []string{"https://*.example.com:x"}}, "invalid port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withOriginPolicy configures a policy for one test and restores the previous one afterwards
+func withOriginPolicy(t *testing.T, policy OriginPolicy) {
+	t.Helper()
+	previous := originPolicy
+	if err := ConfigureOriginPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { originPolicy = previous })
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := OriginPolicy{
+		AllowedOrigins: []string{"https://agent.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+	}
+	handler := policy.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range []struct {
+		origin string
+		allow  bool
+	}{
+		{"https://agent.example.com", true},
+		{"https://evil.test", false},
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "/api/generate-response", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		allowOrigin := rec.Header().Get("Access-Control-Allow-Origin")
+		allowMethods := rec.Header().Get("Access-Control-Allow-Methods")
+		if tt.allow && (allowOrigin != tt.origin || allowMethods == "") {
+			t.Errorf("origin %s: got Allow-Origin %q and Allow-Methods %q, want the preflight allowed", tt.origin, allowOrigin, allowMethods)
+		}
+		if !tt.allow && (allowOrigin != "" || allowMethods != "") {
+			t.Errorf("origin %s: got Allow-Origin %q and Allow-Methods %q, want no CORS headers", tt.origin, allowOrigin, allowMethods)
+		}
+	}
+}
+
+func TestWebSocketUpgradeChecksOrigin(t *testing.T) {
+	withOriginPolicy(t, OriginPolicy{AllowedOrigins: []string{"https://agent.example.com"}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, tt := range []struct {
+		origin string
+		want   int
+	}{
+		{"https://agent.example.com", http.StatusSwitchingProtocols},
+		// Non-browser clients send no Origin header
+		{"", http.StatusSwitchingProtocols},
+		{"https://evil.test", http.StatusForbidden},
+	} {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("origin %q: dial failed without a response: %v", tt.origin, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("origin %q: got status %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+}
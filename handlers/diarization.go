@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"strings"
+)
+
+// diarizer turns word-level speaker tags of a mono recognition into speaker turns
+type diarizer struct {
+	firstSpeaker  string
+	roles         map[int32]string
+	consumedWords int
+}
+
+// newDiarizer creates a diarizer that assigns firstSpeaker to the first speaker tag heard
+func newDiarizer(firstSpeaker string) *diarizer {
+	return &diarizer{firstSpeaker: firstSpeaker, roles: make(map[int32]string)}
+}
+
+// diarizationConfig returns the Google diarization settings for a two-party call
+func diarizationConfig() *speechpb.SpeakerDiarizationConfig {
+	return &speechpb.SpeakerDiarizationConfig{
+		EnableSpeakerDiarization: true,
+		MinSpeakerCount:          2,
+		MaxSpeakerCount:          2,
+	}
+}
+
+// role returns the speaker role for a speaker tag, assigning roles in order of appearance
+func (d *diarizer) role(tag int32) string {
+	if role, ok := d.roles[tag]; ok {
+		return role
+	}
+	role := d.firstSpeaker
+	if len(d.roles) > 0 {
+		role = otherSpeaker(d.firstSpeaker)
+	}
+	d.roles[tag] = role
+	return role
+}
+
+// otherSpeaker returns the opposite party of a call
+func otherSpeaker(speaker string) string {
+	if speaker == models.SpeakerAgent {
+		return models.SpeakerCustomer
+	}
+	return models.SpeakerAgent
+}
+
+// reset prepares the diarizer for a new recognition stream, keeping the assigned roles
+func (d *diarizer) reset() {
+	d.consumedWords = 0
+}
+
+// newWords returns the words of a final result that earlier results did not contain.
+// With diarization enabled, Google repeats all words since the start of the stream
+// in every final result, so words that were already consumed are skipped.
+func (d *diarizer) newWords(alternative *speechpb.SpeechRecognitionAlternative) []*speechpb.WordInfo {
+	words := alternative.Words
+	if len(words) < d.consumedWords {
+		// The stream restarted its word list; treat everything as new
+		d.consumedWords = 0
+	}
+	words = words[d.consumedWords:]
+	d.consumedWords += len(words)
+	return words
+}
+
+// turns groups the new words of a final result, as returned by newWords, into consecutive speaker turns
+func (d *diarizer) turns(words []*speechpb.WordInfo, confidence float32, language string, channel int) []models.Turn {
+	var turns []models.Turn
+	var text []string
+	var turnWords []*speechpb.WordInfo
+	var current int32 = -1
+
+	flush := func() {
+		if len(text) == 0 {
+			return
+		}
+		turns = append(turns, models.Turn{
+			Speaker:    d.role(current),
+			SpeakerTag: current,
+			Text:       strings.Join(text, " "),
+			Language:   language,
+			Channel:    channel,
+			Confidence: confidence,
+			Words:      convertWords(turnWords),
+		})
+		text = nil
+		turnWords = nil
+	}
+
+	for _, word := range words {
+		if word.SpeakerTag != current {
+			flush()
+			current = word.SpeakerTag
+		}
+		text = append(text, word.Word)
+		turnWords = append(turnWords, word)
+	}
+	flush()
+
+	return turns
+}
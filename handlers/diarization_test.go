@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// wordInfo creates a recognized word spoken between startMs and endMs
+func wordInfo(word string, tag int32, startMs, endMs int64) *speechpb.WordInfo {
+	return &speechpb.WordInfo{
+		Word:       word,
+		SpeakerTag: tag,
+		StartTime:  durationpb.New(time.Duration(startMs) * time.Millisecond),
+		EndTime:    durationpb.New(time.Duration(endMs) * time.Millisecond),
+		Confidence: 0.9,
+	}
+}
+
+// diarizedResult creates a final result whose word list holds all words since the start of the stream
+func diarizedResult(transcript string, words ...*speechpb.WordInfo) *speechpb.StreamingRecognizeResponse {
+	return &speechpb.StreamingRecognizeResponse{Results: []*speechpb.StreamingRecognitionResult{{
+		IsFinal:      true,
+		Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: transcript, Confidence: 0.9, Words: words}},
+	}}}
+}
+
+// fakeRecognitionStream returns the responses and then io.EOF
+type fakeRecognitionStream struct {
+	speechpb.Speech_StreamingRecognizeClient
+	responses []*speechpb.StreamingRecognizeResponse
+}
+
+func (f *fakeRecognitionStream) Recv() (*speechpb.StreamingRecognizeResponse, error) {
+	if len(f.responses) == 0 {
+		return nil, io.EOF
+	}
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	return resp, nil
+}
+
+// texts returns the speaker and text of each turn
+func texts(turns []models.Turn) []string {
+	var out []string
+	for _, turn := range turns {
+		out = append(out, turn.Speaker+": "+turn.Text)
+	}
+	return out
+}
+
+func TestDiarizerTurns(t *testing.T) {
+	d := newDiarizer(models.SpeakerAgent)
+	hello := wordInfo("Hallo", 1, 0, 400)
+	question := []*speechpb.WordInfo{wordInfo("Mein", 2, 600, 800), wordInfo("Internet", 2, 800, 1200)}
+	answer := wordInfo("Moment", 1, 1500, 1900)
+
+	first := &speechpb.SpeechRecognitionAlternative{Words: []*speechpb.WordInfo{hello, question[0], question[1]}}
+	words := d.newWords(first)
+	if len(words) != 3 {
+		t.Fatalf("got %d new words, want 3", len(words))
+	}
+	turns := d.turns(words, 0.9, "de-DE", 0)
+	if want := []string{"agent: Hallo", "customer: Mein Internet"}; !reflect.DeepEqual(texts(turns), want) {
+		t.Errorf("got turns %q, want %q", texts(turns), want)
+	}
+	if turns[1].Words[0].StartMs != 600 || turns[1].Words[1].EndMs != 1200 || turns[1].SpeakerTag != 2 {
+		t.Errorf("got customer words %+v with tag %d", turns[1].Words, turns[1].SpeakerTag)
+	}
+
+	// The next result repeats the earlier words; only the answer is new and keeps the agent role
+	second := &speechpb.SpeechRecognitionAlternative{Words: []*speechpb.WordInfo{hello, question[0], question[1], answer}}
+	words = d.newWords(second)
+	if want := []string{"agent: Moment"}; !reflect.DeepEqual(texts(d.turns(words, 0.9, "de-DE", 0)), want) {
+		t.Errorf("got turns %q, want %q", texts(d.turns(words, 0.9, "de-DE", 0)), want)
+	}
+
+	// A new stream starts its word list over
+	d.reset()
+	if words := d.newWords(&speechpb.SpeechRecognitionAlternative{Words: []*speechpb.WordInfo{answer}}); len(words) != 1 {
+		t.Errorf("got %d new words after a reset, want 1", len(words))
+	}
+}
+
+func TestDiarizedFinalEventCarriesOnlyNewWords(t *testing.T) {
+	s := testSession(t)
+	t.Cleanup(func() {
+		s.finish()
+		storeMutex.Lock()
+		delete(conversationStore, "anna")
+		storeMutex.Unlock()
+	})
+
+	hello := wordInfo("Hallo", 1, 0, 400)
+	question := wordInfo("Störung", 2, 600, 1100)
+	recognition := &recognitionChannel{
+		speaker:  models.SpeakerAgent,
+		language: "de-DE",
+		diarizer: newDiarizer(models.SpeakerAgent),
+		stream: &fakeRecognitionStream{responses: []*speechpb.StreamingRecognizeResponse{
+			diarizedResult("Hallo", hello),
+			diarizedResult("Störung", hello, question),
+		}},
+		span:         trace.SpanFromContext(context.Background()),
+		streamBaseMs: 5000,
+	}
+	s.receiveRecognitionResults(context.Background(), recognition)
+
+	s.eventsMu.Lock()
+	events := append([]map[string]interface{}(nil), s.events...)
+	s.eventsMu.Unlock()
+
+	var transcripts [][]models.Word
+	var speakerTurns []string
+	for _, event := range events {
+		switch event["type"] {
+		case "transcript":
+			transcripts = append(transcripts, event["words"].([]models.Word))
+		case "speaker_turns":
+			speakerTurns = append(speakerTurns, texts(event["turns"].([]models.Turn))...)
+		}
+	}
+	if len(transcripts) != 2 || len(transcripts[0]) != 1 || len(transcripts[1]) != 1 {
+		t.Fatalf("got final event words %+v, want one new word per event", transcripts)
+	}
+	if got := transcripts[1][0]; got.Word != "Störung" || got.StartMs != 5600 || got.EndMs != 6100 {
+		t.Errorf("got second event word %+v, want Störung at 5600-6100 ms on the session timeline", got)
+	}
+	if want := []string{"agent: Hallo", "customer: Störung"}; !reflect.DeepEqual(speakerTurns, want) {
+		t.Errorf("got speaker turns %q, want %q", speakerTurns, want)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Issue types reported by the grammar check
+const (
+	IssueGrammar       = "grammar"
+	IssueFormality     = "formality"
+	IssueTone          = "tone"
+	IssueKnowledgeBase = "knowledge_base"
+)
+
+// GrammarIssue describes a single problem found in a German draft
+type GrammarIssue struct {
+	Type              string `json:"type"`
+	Original          string `json:"original"`
+	Suggestion        string `json:"suggestion"`
+	KoreanExplanation string `json:"korean_explanation"`
+}
+
+// GrammarCheckResult is returned by the grammar check endpoint
+type GrammarCheckResult struct {
+	Corrected string         `json:"corrected"`
+	Issues    []GrammarIssue `json:"issues"`
+}
+
+// informalPronouns matches "du" forms that break the formal "Sie" address
+var informalPronouns = regexp.MustCompile(`(?i)\b(du|dich|dir|dein|deine|deinen|deinem|deiner|deines|euch|euer|eure)\b`)
+
+// HandleCheckGrammar handles requests to check a German draft for grammar and politeness issues
+func HandleCheckGrammar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse request body
+	var requestBody struct {
+		Username           string `json:"username"`
+		Text               string `json:"text"`
+		Service            string `json:"service"`
+		CheckKnowledgeBase bool   `json:"check_knowledge_base"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		assistLog.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestBody.Text) == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := requestUsername(r, requestBody.Username)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	requestBody.Username = username
+
+	var facts []string
+	if requestBody.CheckKnowledgeBase {
+		facts = GetKnowledgeBase(requestBody.Service)
+		assistLog.DebugContext(r.Context(), "including knowledge base", "service", requestBody.Service, "facts", len(facts))
+	}
+
+	assistLog.InfoContext(r.Context(), "checking grammar", "username", requestBody.Username, "chars", len(requestBody.Text))
+
+	content, err := callOpenAIChat(r.Context(), defaultSystemPrompt, constructGrammarCheckPrompt(requestBody.Text, facts))
+	if err != nil {
+		assistLog.ErrorContext(r.Context(), "checking grammar failed", logging.Err(err), "username", requestBody.Username)
+		http.Error(w, fmt.Sprintf("Error calling OpenAI API: %v", err), openAIErrorStatus(err))
+		return
+	}
+
+	var result GrammarCheckResult
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		assistLog.ErrorContext(r.Context(), "parsing grammar check failed", logging.Err(err), logging.Text("content", content))
+		http.Error(w, "Grammar check could not be parsed", http.StatusBadGateway)
+		return
+	}
+	if result.Corrected == "" {
+		result.Corrected = requestBody.Text
+	}
+	result.Issues = mergeGrammarIssues(result.Issues, checkFormality(requestBody.Text))
+
+	assistLog.InfoContext(r.Context(), "grammar checked", "username", requestBody.Username, "issues", len(result.Issues))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// constructGrammarCheckPrompt creates a prompt for checking a German draft
+func constructGrammarCheckPrompt(text string, facts []string) string {
+	return renderPrompt(PromptGrammar, promptData{Text: text, Knowledge: facts})
+}
+
+// checkFormality flags informal pronouns locally so they are reported even if the LLM misses them
+func checkFormality(text string) []GrammarIssue {
+	issues := []GrammarIssue{}
+	seen := make(map[string]bool)
+	for _, match := range informalPronouns.FindAllString(text, -1) {
+		key := strings.ToLower(match)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		issues = append(issues, GrammarIssue{
+			Type:              IssueFormality,
+			Original:          match,
+			Suggestion:        "Sie / Ihnen / Ihr",
+			KoreanExplanation: fmt.Sprintf("'%s'는 반말(du) 형태입니다. 고객에게는 존칭(Sie) 형태를 사용하세요.", match),
+		})
+	}
+	return issues
+}
+
+// mergeGrammarIssues appends local issues that the LLM did not already report
+func mergeGrammarIssues(issues, local []GrammarIssue) []GrammarIssue {
+	if issues == nil {
+		issues = []GrammarIssue{}
+	}
+	for _, candidate := range local {
+		duplicate := false
+		for _, issue := range issues {
+			if issue.Type == candidate.Type && strings.Contains(strings.ToLower(issue.Original), strings.ToLower(candidate.Original)) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			issues = append(issues, candidate)
+		}
+	}
+	return issues
+}
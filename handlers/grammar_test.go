@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// checkGrammar posts a body to HandleCheckGrammar and decodes a successful result
+func checkGrammar(t *testing.T, body string) GrammarCheckResult {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	HandleCheckGrammar(rec, httptest.NewRequest(http.MethodPost, "/api/check-grammar", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var result GrammarCheckResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestHandleCheckGrammarMergesLocalIssues(t *testing.T) {
+	withOpenAIStub(t, `{"corrected": "Können Sie dein Passwort ändern?", "issues": [
+		{"type": "grammar", "original": "Konnen", "suggestion": "Können", "korean_explanation": "철자 오류"},
+		{"type": "formality", "original": "dein Passwort", "suggestion": "Ihr Passwort", "korean_explanation": "반말"}
+	]}`, 10)
+
+	result := checkGrammar(t, `{"username": "anna", "text": "Konnen Sie dein Passwort ändern? Ich schicke dir einen Link."}`)
+	var got []string
+	for _, issue := range result.Issues {
+		got = append(got, issue.Type+":"+issue.Original)
+	}
+	// "dein" was already reported by the LLM, "dir" only by the local check
+	want := []string{"grammar:Konnen", "formality:dein Passwort", "formality:dir"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got issues %v, want %v", got, want)
+	}
+	if result.Corrected != "Können Sie dein Passwort ändern?" {
+		t.Errorf("got corrected %q", result.Corrected)
+	}
+}
+
+func TestHandleCheckGrammarWithoutCorrection(t *testing.T) {
+	withOpenAIStub(t, `{"issues": null}`, 10)
+
+	text := "Vielen Dank für Ihren Anruf."
+	result := checkGrammar(t, `{"username": "anna", "text": "`+text+`"}`)
+	if result.Corrected != text {
+		t.Errorf("got corrected %q, want the draft", result.Corrected)
+	}
+	if result.Issues == nil || len(result.Issues) != 0 {
+		t.Errorf("got issues %#v, want an empty list", result.Issues)
+	}
+}
+
+func TestHandleCheckGrammarKnowledgeBase(t *testing.T) {
+	t.Cleanup(knowledgeBase.reset)
+	if err := LoadKnowledgeBase(writeStoreFile(t, `{"internet": ["Routers are sent within 3 days"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	stub := withOpenAIStub(t, `{"corrected": "Guten Tag", "issues": []}`, 10)
+
+	checkGrammar(t, `{"username": "anna", "text": "Guten Tag", "service": "internet"}`)
+	checkGrammar(t, `{"username": "anna", "text": "Guten Tag", "service": "internet", "check_knowledge_base": true}`)
+
+	prompts := stub.received()
+	if len(prompts) != 2 {
+		t.Fatalf("got %d OpenAI requests, want 2", len(prompts))
+	}
+	rule := `(type "` + IssueKnowledgeBase + `")`
+	if strings.Contains(prompts[0], "Routers are sent within 3 days") || strings.Contains(prompts[0], rule) {
+		t.Errorf("prompt includes the knowledge base without check_knowledge_base:\n%s", prompts[0])
+	}
+	if !strings.Contains(prompts[1], "- Routers are sent within 3 days") || !strings.Contains(prompts[1], rule) {
+		t.Errorf("prompt does not include the knowledge base:\n%s", prompts[1])
+	}
+}
+
+func TestHandleCheckGrammarErrors(t *testing.T) {
+	agent := models.Identity{Username: "anna", Role: models.RoleAgent, Team: "berlin"}
+	tests := []struct {
+		name     string
+		content  string
+		body     string
+		identity *models.Identity
+		want     int
+	}{
+		{"invalid body", `{}`, `{"text": `, nil, http.StatusBadRequest},
+		{"empty text", `{}`, `{"username": "anna", "text": ""}`, nil, http.StatusBadRequest},
+		{"check for another agent", `{}`, `{"username": "carl", "text": "Guten Tag"}`, &agent, http.StatusForbidden},
+		{"unparsable result", `not json`, `{"username": "anna", "text": "Guten Tag"}`, nil, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := withOpenAIStub(t, tt.content, 10)
+			req := httptest.NewRequest(http.MethodPost, "/api/check-grammar", strings.NewReader(tt.body))
+			if tt.identity != nil {
+				req = req.WithContext(context.WithValue(req.Context(), identityKey{}, *tt.identity))
+			}
+			rec := httptest.NewRecorder()
+			HandleCheckGrammar(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want != http.StatusBadGateway && len(stub.received()) != 0 {
+				t.Errorf("OpenAI was called for a rejected request")
+			}
+		})
+	}
+}
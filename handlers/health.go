@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	speech "cloud.google.com/go/speech/apiv1"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2/google"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// readinessTimeout bounds all checks of one readiness request
+	readinessTimeout = 5 * time.Second
+	// probeCacheTTL is how long results of external probes are reused
+	probeCacheTTL = 30 * time.Second
+	// storeLockPollInterval is how often the readiness check retries a busy store lock
+	storeLockPollInterval = 10 * time.Millisecond
+)
+
+// openAIModelsURL is probed to check that the OpenAI API is reachable and accepts the key
+const openAIModelsURL = "https://api.openai.com/v1/models"
+
+// healthLog logs failed readiness checks
+var healthLog = logging.For("health")
+
+// cachedProbe runs a check at most once per TTL. Concurrent callers wait for the running check,
+// so a burst of readiness requests causes a single external request.
+type cachedProbe struct {
+	check  func(ctx context.Context) error
+	ttl    time.Duration
+	mu     sync.Mutex
+	result models.ComponentHealth
+}
+
+// run returns the last result while it is fresh and checks again otherwise
+func (p *cachedProbe) run(ctx context.Context) models.ComponentHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.result.CheckedAt.IsZero() && time.Since(p.result.CheckedAt) < p.ttl {
+		cached := p.result
+		cached.Cached = true
+		return cached
+	}
+	p.result = componentHealth(p.check(ctx))
+	return p.result
+}
+
+var (
+	// Loading credentials may query the metadata server, so it is cached like the OpenAI probe
+	googleCredentialsProbe = &cachedProbe{check: checkGoogleCredentials, ttl: probeCacheTTL}
+	openAIProbe            = &cachedProbe{check: checkOpenAI, ttl: probeCacheTTL}
+)
+
+// readinessChecks are the components reported by the readiness endpoint
+var readinessChecks = map[string]func(context.Context) models.ComponentHealth{
+	"google_credentials": func(ctx context.Context) models.ComponentHealth { return googleCredentialsProbe.run(ctx) },
+	"openai":             func(ctx context.Context) models.ComponentHealth { return openAIProbe.run(ctx) },
+	"conversation_store": func(ctx context.Context) models.ComponentHealth {
+		return componentHealth(checkConversationStore(ctx))
+	},
+}
+
+// HandleLiveness reports that the process is running. It does not check dependencies.
+func HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": models.HealthOK})
+}
+
+// HandleReadiness checks the dependencies needed to serve calls and reports each of them.
+// It responds with 503 when a component is down or the server is draining.
+func HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	report := models.ReadinessReport{Status: models.HealthReady, Components: make(map[string]models.ComponentHealth)}
+	var mu sync.Mutex
+	var checks sync.WaitGroup
+	for name, check := range readinessChecks {
+		checks.Add(1)
+		go func(name string, check func(context.Context) models.ComponentHealth) {
+			defer checks.Done()
+			result := check(ctx)
+			mu.Lock()
+			report.Components[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	checks.Wait()
+
+	for name, result := range report.Components {
+		if result.Status != models.HealthOK {
+			report.Status = models.HealthNotReady
+			if !result.Cached {
+				healthLog.WarnContext(r.Context(), "readiness check failed", "component", name, "error", result.Error)
+			}
+		}
+	}
+	// Load balancers should stop sending new calls while sessions drain
+	if isShuttingDown() {
+		report.Status = models.HealthDraining
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != models.HealthReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// componentHealth converts the error of a check into a component status
+func componentHealth(err error) models.ComponentHealth {
+	result := models.ComponentHealth{Status: models.HealthOK, CheckedAt: time.Now()}
+	if err != nil {
+		result.Status = models.HealthDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkGoogleCredentials loads the application default credentials used by the speech client
+func checkGoogleCredentials(ctx context.Context) error {
+	if _, err := google.FindDefaultCredentials(ctx, speech.DefaultAuthScopes()...); err != nil {
+		return fmt.Errorf("loading credentials: %w", err)
+	}
+	return nil
+}
+
+// checkOpenAI lists the models of the OpenAI API, which fails for unreachable endpoints and rejected keys
+func checkOpenAI(ctx context.Context) error {
+	if openAIAPIKey == "" {
+		return errors.New("API key is not configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", openAIModelsURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+openAIAPIKey)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkConversationStore reports whether the store lock can be taken within the readiness timeout.
+// The in-memory store cannot fail otherwise, but a lock that stays held blocks every session
+// from storing turns. The lock is polled, so a timed-out check leaves nothing waiting behind.
+func checkConversationStore(ctx context.Context) error {
+	for !storeMutex.TryRLock() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("conversation store lock not released: %w", ctx.Err())
+		case <-time.After(storeLockPollInterval):
+		}
+	}
+	storeMutex.RUnlock()
+	return nil
+}
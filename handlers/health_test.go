@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckConversationStore(t *testing.T) {
+	if err := checkConversationStore(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A held lock makes the check fail once the context expires, without leaving it waiting
+	storeMutex.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := checkConversationStore(ctx)
+	storeMutex.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+
+	// Nothing from the failed check still holds or waits for the lock
+	if !storeMutex.TryLock() {
+		t.Fatal("store lock is still taken after the failed check")
+	}
+	storeMutex.Unlock()
+}
+
+func TestComponentHealthHidesErrors(t *testing.T) {
+	result := componentHealth(errors.New("open /etc/secrets/google.json: no such file or directory"))
+	if result.Status != models.HealthDown || result.Error == "" {
+		t.Fatalf("got %+v, want a failed component with its error", result)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secrets") {
+		t.Errorf("readiness response contains error detail: %s", data)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"time"
+)
+
+// Close reasons sent by the server
+const (
+	closeReasonIdle        = "idle timeout"
+	closeReasonPingFailed  = "ping failed"
+	closeReasonPongTimeout = "pong timeout"
+	closeReasonResumed     = "session resumed on another connection"
+	closeReasonShutdown    = "server shutting down"
+)
+
+// WebSocketLimits bounds the frame size and lifetime of speech WebSocket connections
+type WebSocketLimits struct {
+	// MaxMessageBytes is the largest message a client may send
+	MaxMessageBytes int64
+	// WriteTimeout bounds every write, including pings
+	WriteTimeout time.Duration
+	// PongTimeout closes the connection when the client stops answering pings
+	PongTimeout time.Duration
+	// PingInterval must be shorter than PongTimeout
+	PingInterval time.Duration
+	// IdleTimeout closes the connection when the client sends no messages
+	IdleTimeout time.Duration
+	// CloseGracePeriod is how long the client may take to answer a close frame
+	CloseGracePeriod time.Duration
+}
+
+// wsLimits applies to all new speech connections
+var wsLimits = WebSocketLimits{
+	MaxMessageBytes:  1 << 20,
+	WriteTimeout:     10 * time.Second,
+	PongTimeout:      60 * time.Second,
+	PingInterval:     54 * time.Second,
+	IdleTimeout:      5 * time.Minute,
+	CloseGracePeriod: 2 * time.Second,
+}
+
+// ConfigureWebSocket sets the limits used for new speech connections
+func ConfigureWebSocket(limits WebSocketLimits) {
+	if limits.PingInterval <= 0 || limits.PingInterval >= limits.PongTimeout {
+		limits.PingInterval = limits.PongTimeout * 9 / 10
+	}
+	if limits.CloseGracePeriod <= 0 {
+		limits.CloseGracePeriod = 2 * time.Second
+	}
+	wsLimits = limits
+	speechLog.Info("websocket limits configured", "max_message_bytes", limits.MaxMessageBytes,
+		"write_timeout", limits.WriteTimeout.String(), "pong_timeout", limits.PongTimeout.String(),
+		"idle_timeout", limits.IdleTimeout.String())
+}
+
+// newSafeConn wraps an upgraded connection and applies the read limit and pong tracking
+func newSafeConn(conn *websocket.Conn) *safeConn {
+	c := &safeConn{conn: conn, limits: wsLimits}
+	conn.SetReadLimit(c.limits.MaxMessageBytes)
+	c.touch()
+	conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+	return c
+}
+
+// touch records client activity and extends the read deadline
+func (c *safeConn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+	c.extendReadDeadline()
+}
+
+// extendReadDeadline gives the client another pong timeout to show it is alive
+func (c *safeConn) extendReadDeadline() {
+	// While closing, the shorter grace period set by closeWith must not be extended. mu orders
+	// this with closeWith, so a deadline checked before closing started is not set after it.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing.Load() {
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.limits.PongTimeout))
+}
+
+// keepAlive pings the client and closes idle connections until ctx is cancelled
+func (c *safeConn) keepAlive(ctx context.Context) {
+	interval := c.limits.PingInterval
+	if c.limits.IdleTimeout > 0 && c.limits.IdleTimeout < interval {
+		interval = c.limits.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+		if c.limits.IdleTimeout > 0 && idle > c.limits.IdleTimeout {
+			speechLog.WarnContext(ctx, "closing idle connection", "idle", idle.Round(time.Second).String())
+			c.closeWith(websocket.CloseNormalClosure, closeReasonIdle)
+			return
+		}
+
+		if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.limits.WriteTimeout)); err != nil {
+			speechLog.WarnContext(ctx, "sending ping failed", logging.Err(err))
+			c.closeWith(websocket.CloseGoingAway, closeReasonPingFailed)
+			return
+		}
+	}
+}
+
+// closeWith starts the closing handshake with a close code and reason.
+// The read loop ends when the client answers or the grace period expires.
+func (c *safeConn) closeWith(code int, reason string) {
+	c.mu.Lock()
+	if !c.closing.CompareAndSwap(false, true) {
+		c.mu.Unlock()
+		return
+	}
+	c.closeReason = reason
+	c.mu.Unlock()
+
+	// The close frame is a control message, so it is not queued behind a blocked write
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(c.limits.WriteTimeout)); err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		speechLog.Warn("sending close frame failed", logging.Err(err))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetReadDeadline(time.Now().Add(c.limits.CloseGracePeriod))
+}
+
+// closeAfterReadError sends a close frame matching the reason the read loop ended
+func (c *safeConn) closeAfterReadError(ctx context.Context, err error) {
+	var netErr net.Error
+	switch {
+	case c.closing.Load():
+		// The server already started the closing handshake
+	case errors.Is(err, websocket.ErrReadLimit):
+		// The websocket package has already sent CloseMessageTooBig
+		speechLog.WarnContext(ctx, "closing connection after oversized message", "limit_bytes", c.limits.MaxMessageBytes)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		speechLog.WarnContext(ctx, "closing connection after pong timeout")
+		c.closeWith(websocket.CloseGoingAway, closeReasonPongTimeout)
+	}
+}
+
+// resumable reports whether the connection ended in a way that allows the client to resume the session.
+// Deliberate closes by either side end the session; network failures keep it for the grace period.
+func (c *safeConn) resumable(err error) bool {
+	c.mu.Lock()
+	reason := c.closeReason
+	c.mu.Unlock()
+
+	switch reason {
+	case closeReasonPingFailed, closeReasonPongTimeout, closeReasonResumed:
+		return true
+	case "":
+		if errors.Is(err, websocket.ErrReadLimit) {
+			return false
+		}
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Code == websocket.CloseAbnormalClosure
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withWebSocketLimits applies limits for one test and restores the previous ones afterwards
+func withWebSocketLimits(t *testing.T, limits WebSocketLimits) {
+	t.Helper()
+	previous := wsLimits
+	ConfigureWebSocket(limits)
+	t.Cleanup(func() { wsLimits = previous })
+}
+
+// keepAliveServer serves WebSocket connections like a speech session does: it keeps the connection
+// alive, reads until an error and closes it the way the read loop does. The read error is sent on
+// the returned channel. serve, when set, runs next to the read loop with the server side of the connection.
+func keepAliveServer(t *testing.T, serve func(conn *safeConn)) (*httptest.Server, <-chan error) {
+	t.Helper()
+	readErrs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer wsConn.Close()
+		conn := newSafeConn(wsConn)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go conn.keepAlive(ctx)
+		if serve != nil {
+			go serve(conn)
+		}
+		for {
+			if _, _, err := wsConn.ReadMessage(); err != nil {
+				conn.closeAfterReadError(ctx, err)
+				readErrs <- err
+				return
+			}
+			conn.touch()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, readErrs
+}
+
+// dial connects a client to the test server
+func dial(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// readClose reads until the server's close frame arrives and returns it
+func readClose(t *testing.T, client *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := client.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("expected a close frame, got %v", err)
+		}
+		return closeErr
+	}
+}
+
+func TestKeepAliveClosesClientThatStopsSendingPongs(t *testing.T) {
+	withWebSocketLimits(t, WebSocketLimits{
+		MaxMessageBytes:  1024,
+		WriteTimeout:     100 * time.Millisecond,
+		PongTimeout:      150 * time.Millisecond,
+		PingInterval:     50 * time.Millisecond,
+		CloseGracePeriod: 100 * time.Millisecond,
+	})
+	server, readErrs := keepAliveServer(t, nil)
+	client := dial(t, server)
+
+	// The client keeps reading but swallows pings instead of answering them
+	client.SetPingHandler(func(string) error { return nil })
+
+	closeErr := readClose(t, client)
+	if closeErr.Code != websocket.CloseGoingAway || closeErr.Text != closeReasonPongTimeout {
+		t.Errorf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.CloseGoingAway, closeReasonPongTimeout)
+	}
+	var netErr net.Error
+	if err := <-readErrs; !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("server read ended with %v, want a timeout", err)
+	}
+}
+
+func TestKeepAliveWriteDeadlineForClientThatStopsReading(t *testing.T) {
+	withWebSocketLimits(t, WebSocketLimits{
+		MaxMessageBytes: 1024,
+		WriteTimeout:    50 * time.Millisecond,
+		PongTimeout:     5 * time.Second,
+	})
+	writeErrs := make(chan error, 1)
+	server, _ := keepAliveServer(t, func(conn *safeConn) {
+		// Transcripts keep coming, but the client never reads them; once the socket
+		// buffers are full, a write blocks until the write deadline
+		payload := make([]byte, 64*1024)
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			start := time.Now()
+			if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+				if blocked := time.Since(start); blocked > time.Second {
+					err = errors.New("write blocked for " + blocked.String())
+				}
+				writeErrs <- err
+				return
+			}
+		}
+		writeErrs <- errors.New("writes never blocked")
+	})
+	dial(t, server)
+
+	var netErr net.Error
+	if err := <-writeErrs; !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got %v, want a write timeout", err)
+	}
+}
+
+func TestKeepAliveClosesIdleClient(t *testing.T) {
+	withWebSocketLimits(t, WebSocketLimits{
+		MaxMessageBytes:  1024,
+		WriteTimeout:     100 * time.Millisecond,
+		PongTimeout:      time.Second,
+		IdleTimeout:      100 * time.Millisecond,
+		CloseGracePeriod: 100 * time.Millisecond,
+	})
+	server, _ := keepAliveServer(t, nil)
+	client := dial(t, server)
+
+	// The client answers pings while reading but never sends audio
+	start := time.Now()
+	closeErr := readClose(t, client)
+	if closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != closeReasonIdle {
+		t.Errorf("got close %d %q, want %d %q", closeErr.Code, closeErr.Text, websocket.CloseNormalClosure, closeReasonIdle)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("closed after %v, before the idle timeout", elapsed)
+	}
+}
+
+func TestClientMessagesDoNotExtendCloseGracePeriod(t *testing.T) {
+	withWebSocketLimits(t, WebSocketLimits{
+		MaxMessageBytes:  1024,
+		WriteTimeout:     100 * time.Millisecond,
+		PongTimeout:      5 * time.Second,
+		CloseGracePeriod: 100 * time.Millisecond,
+	})
+	server, readErrs := keepAliveServer(t, func(conn *safeConn) {
+		conn.closeWith(websocket.CloseGoingAway, closeReasonResumed)
+	})
+	client := dial(t, server)
+
+	// The client keeps sending audio and never reads the close frame, so it does not answer it
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				if err := client.WriteMessage(websocket.BinaryMessage, []byte{0, 0}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case err := <-readErrs:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("server read ended with %v, want a timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client messages extended the close grace period")
+	}
+}
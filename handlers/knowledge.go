@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Limits applied to facts managed through the admin endpoint
+const (
+	maxFactsPerService = 500
+	maxFactLength      = 1000
+)
+
+// knowledgeBase maps service names to facts agents must not contradict
+var knowledgeBase = &serviceStore[[]string]{
+	name:     "knowledge base",
+	param:    "service",
+	log:      assistLog,
+	validate: func(service string, facts []string) error { return validateFacts(facts) },
+	empty:    []string{},
+}
+
+// LoadKnowledgeBase reads a JSON file of the form {"service": ["fact", ...]} into the knowledge base.
+// Changes made through the admin endpoint are written back to the same file.
+func LoadKnowledgeBase(path string) error {
+	return knowledgeBase.Load(path)
+}
+
+// GetKnowledgeBase returns the facts stored for a service
+func GetKnowledgeBase(service string) []string {
+	facts, _ := knowledgeBase.get(service)
+	return facts
+}
+
+// validateFacts checks a fact list against the limits of the grammar check prompt
+func validateFacts(facts []string) error {
+	if len(facts) > maxFactsPerService {
+		return fmt.Errorf("at most %d facts are allowed", maxFactsPerService)
+	}
+	for _, fact := range facts {
+		if strings.TrimSpace(fact) == "" {
+			return fmt.Errorf("fact must not be empty")
+		}
+		if len(fact) > maxFactLength {
+			return fmt.Errorf("facts must not be longer than %d characters", maxFactLength)
+		}
+	}
+	return nil
+}
+
+// HandleListKnowledgeBase returns the facts of all services
+func HandleListKnowledgeBase(w http.ResponseWriter, r *http.Request) {
+	knowledgeBase.handleList(w, r)
+}
+
+// HandleGetKnowledgeBase returns the facts of one service
+func HandleGetKnowledgeBase(w http.ResponseWriter, r *http.Request) {
+	knowledgeBase.handleGet(w, r)
+}
+
+// HandlePutKnowledgeBase replaces the facts of one service
+func HandlePutKnowledgeBase(w http.ResponseWriter, r *http.Request) {
+	knowledgeBase.handlePut(w, r)
+}
+
+// HandleDeleteKnowledgeBase removes the facts of one service
+func HandleDeleteKnowledgeBase(w http.ResponseWriter, r *http.Request) {
+	knowledgeBase.handleDelete(w, r)
+}
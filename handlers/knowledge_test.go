@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFacts(t *testing.T) {
+	tooMany := make([]string, maxFactsPerService+1)
+	for i := range tooMany {
+		tooMany[i] = "Routers are sent within 3 days"
+	}
+
+	tests := []struct {
+		name    string
+		facts   []string
+		wantErr bool
+	}{
+		{"valid", []string{"Routers are sent within 3 days", "Contracts run for 24 months"}, false},
+		{"no facts", nil, false},
+		{"empty fact", []string{"Contracts run for 24 months", " "}, true},
+		{"too long", []string{strings.Repeat("a", maxFactLength+1)}, true},
+		{"longest allowed", []string{strings.Repeat("a", maxFactLength)}, false},
+		{"too many", tooMany, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateFacts(tt.facts); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKnowledgeBaseLookupIgnoresCase(t *testing.T) {
+	t.Cleanup(knowledgeBase.reset)
+	if err := LoadKnowledgeBase(writeStoreFile(t, `{"Internet": ["Routers are sent within 3 days"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if facts := GetKnowledgeBase("internet"); len(facts) != 1 {
+		t.Errorf("got %v, want the internet facts", facts)
+	}
+	if facts := GetKnowledgeBase("mobile"); facts != nil {
+		t.Errorf("got %v for a service without facts", facts)
+	}
+}
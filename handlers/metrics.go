@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsRegistry holds the service metrics; it is separate from the global default registry
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	audioReceivedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "speech_audio_received_bytes_total",
+		Help: "Audio bytes received over speech WebSockets, before conversion.",
+	})
+	recognitionLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "speech_recognition_latency_seconds",
+		Help:    "Time from the start of speech to the first interim and the final result of an utterance. Speech starts at the VAD speech_start event, or without VAD at the first interim result, which is then not observed.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30},
+	}, []string{"result"})
+	generateResponseDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "assist_generate_response_duration_seconds",
+		Help:    "Time to generate suggested responses, including the OpenAI request.",
+		Buckets: []float64{0.5, 1, 2, 4, 6, 8, 12, 20, 30},
+	})
+	openAIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "openai_errors_total",
+		Help: "Failed OpenAI requests by HTTP status; transport failures are counted as status \"error\".",
+	}, []string{"api", "status"})
+	fallbackResponses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "assist_fallback_responses_total",
+		Help: "Suggestions replaced by the fallback response because OpenAI returned invalid JSON.",
+	})
+	summaryLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "summary_lookups_total",
+		Help: "Reads of stored call summaries by result (found or not_found).",
+	}, []string{"result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		audioReceivedBytes,
+		recognitionLatency,
+		generateResponseDuration,
+		openAIErrors,
+		fallbackResponses,
+		summaryLookups,
+	)
+
+	for _, state := range []sessionState{sessionAttached, sessionParked} {
+		state := state
+		metricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speech_sessions",
+			Help:        "Speech sessions by state; attached sessions have an open WebSocket.",
+			ConstLabels: prometheus.Labels{"state": state.String()},
+		}, func() float64 { return float64(countSessions(state)) }))
+	}
+
+	for store, size := range map[string]func() int{
+		"conversations":  conversationStoreSize,
+		"summaries":      func() int { return lockedLen(&summaryStoreMutex, func() int { return len(summaryStore) }) },
+		"transcriptions": func() int { return lockedLen(&transcriptionJobsMutex, func() int { return len(transcriptionJobs) }) },
+		"recordings":     func() int { return lockedLen(&recordingStoreMutex, func() int { return len(recordingStore) }) },
+	} {
+		size := size
+		metricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "store_entries",
+			Help:        "Entries in the in-memory stores; conversations counts question/answer pairs.",
+			ConstLabels: prometheus.Labels{"store": store},
+		}, func() float64 { return float64(size()) }))
+	}
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{Registry: metricsRegistry})
+}
+
+// String names the state in metrics
+func (s sessionState) String() string {
+	switch s {
+	case sessionAttached:
+		return "attached"
+	case sessionParked:
+		return "parked"
+	}
+	return "finished"
+}
+
+// countSessions returns how many registered sessions are in a state
+func countSessions(state sessionState) int {
+	speechSessionsMutex.Lock()
+	defer speechSessionsMutex.Unlock()
+	count := 0
+	for _, s := range speechSessions {
+		if s.state == state {
+			count++
+		}
+	}
+	return count
+}
+
+// conversationStoreSize returns the number of stored conversation entries across all users and batch jobs
+func conversationStoreSize() int {
+	storeMutex.RLock()
+	defer storeMutex.RUnlock()
+	count := 0
+	for _, conversations := range conversationStore {
+		count += len(conversations)
+	}
+	return count
+}
+
+// locker is the read side of a store mutex
+type locker interface {
+	RLock()
+	RUnlock()
+}
+
+// lockedLen evaluates size while holding the store's read lock
+func lockedLen(mu locker, size func() int) int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return size()
+}
+
+// observeOpenAIError counts a failed OpenAI request; status is 0 for transport failures
+func observeOpenAIError(api string, status int) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	openAIErrors.WithLabelValues(api, label).Inc()
+}
+
+// observeSince records the seconds elapsed since start
+func observeSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scrape gathers metricsRegistry and returns the value of a counter, or the sample count of a
+// histogram, with the given label value
+func scrape(t *testing.T, name, label, value string) float64 {
+	t.Helper()
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() != label || pair.GetValue() != value {
+					continue
+				}
+				if metric.GetHistogram() != nil {
+					return float64(metric.GetHistogram().GetSampleCount())
+				}
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetricsLint(t *testing.T) {
+	problems, err := testutil.GatherAndLint(metricsRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Errorf("%s: %s", problem.Metric, problem.Text)
+	}
+}
+
+func TestSummaryLookupMetric(t *testing.T) {
+	t.Cleanup(func() { summaryStore = make(map[string]models.CallSummary) })
+	saveSummary(context.Background(), models.CallSummary{SessionID: "call-1", Username: "anna", CreatedAt: time.Now()})
+	found := scrape(t, "summary_lookups_total", "result", "found")
+	notFound := scrape(t, "summary_lookups_total", "result", "not_found")
+
+	for _, query := range []string{"SessionID=call-1", "SessionID=call-2", "Username=carl"} {
+		HandleGetSummary(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/summary?"+query, nil))
+	}
+
+	if got := scrape(t, "summary_lookups_total", "result", "found") - found; got != 1 {
+		t.Errorf("found lookups increased by %v, want 1", got)
+	}
+	if got := scrape(t, "summary_lookups_total", "result", "not_found") - notFound; got != 2 {
+		t.Errorf("not_found lookups increased by %v, want 2", got)
+	}
+}
+
+func TestRecognitionLatencyStartsAtSpeech(t *testing.T) {
+	tests := []struct {
+		name             string
+		vad              bool
+		wantFirstInterim float64
+	}{
+		// Without VAD the first interim result marks the start of speech
+		{"without vad", false, 0},
+		{"with vad", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			firstInterim := scrape(t, "speech_recognition_latency_seconds", "result", "first_interim")
+			final := scrape(t, "speech_recognition_latency_seconds", "result", "final")
+
+			recognition := &recognitionChannel{}
+			// Results of an earlier utterance before any speech was detected are not measured
+			recognition.observeLatency(true)
+			if tt.vad {
+				recognition.markSpeechStart()
+			}
+			recognition.observeLatency(false)
+			recognition.observeLatency(false)
+			recognition.observeLatency(true)
+
+			if got := scrape(t, "speech_recognition_latency_seconds", "result", "first_interim") - firstInterim; got != tt.wantFirstInterim {
+				t.Errorf("first_interim observations increased by %v, want %v", got, tt.wantFirstInterim)
+			}
+			if got := scrape(t, "speech_recognition_latency_seconds", "result", "final") - final; got != 1 {
+				t.Errorf("final observations increased by %v, want 1", got)
+			}
+			if recognition.speechStart.Load() != 0 {
+				t.Error("the final result did not end the utterance")
+			}
+		})
+	}
+}
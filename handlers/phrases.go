@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Limits applied to phrase lists managed through the admin endpoint
+const (
+	maxPhrasesPerService = 5000
+	maxPhraseLength      = 100
+	maxPhraseBoost       = 20
+)
+
+// phraseHints maps service names to the phrases passed to the recognizer
+var phraseHints = &serviceStore[[]models.PhraseHint]{
+	name:     "phrase hints",
+	param:    "service",
+	log:      speechLog,
+	validate: func(service string, phrases []models.PhraseHint) error { return validatePhraseHints(phrases) },
+	empty:    []models.PhraseHint{},
+}
+
+// LoadPhraseHints reads a JSON file of the form {"service": [{"phrase": "...", "boost": 10}]}.
+// Changes made through the admin endpoint are written back to the same file.
+func LoadPhraseHints(path string) error {
+	return phraseHints.Load(path)
+}
+
+// GetPhraseHints returns the phrase hints configured for a service
+func GetPhraseHints(service string) []models.PhraseHint {
+	hints, _ := phraseHints.get(service)
+	return hints
+}
+
+// validatePhraseHints checks a phrase list against the recognizer limits
+func validatePhraseHints(phrases []models.PhraseHint) error {
+	if len(phrases) > maxPhrasesPerService {
+		return fmt.Errorf("at most %d phrases are allowed", maxPhrasesPerService)
+	}
+	for _, hint := range phrases {
+		if strings.TrimSpace(hint.Phrase) == "" {
+			return fmt.Errorf("phrase must not be empty")
+		}
+		if len(hint.Phrase) > maxPhraseLength {
+			return fmt.Errorf("phrase %q is longer than %d characters", hint.Phrase, maxPhraseLength)
+		}
+		if hint.Boost < 0 || hint.Boost > maxPhraseBoost {
+			return fmt.Errorf("boost for %q must be between 0 and %d", hint.Phrase, maxPhraseBoost)
+		}
+	}
+	return nil
+}
+
+// speechContexts converts the phrase hints of a service into Google speech contexts.
+// Boost is set per context, so phrases are grouped by their boost value.
+func speechContexts(service string) []*speechpb.SpeechContext {
+	hints := GetPhraseHints(service)
+	if len(hints) == 0 {
+		return nil
+	}
+
+	byBoost := make(map[float32][]string)
+	for _, hint := range hints {
+		byBoost[hint.Boost] = append(byBoost[hint.Boost], hint.Phrase)
+	}
+
+	boosts := make([]float32, 0, len(byBoost))
+	for boost := range byBoost {
+		boosts = append(boosts, boost)
+	}
+	sort.Slice(boosts, func(i, j int) bool { return boosts[i] < boosts[j] })
+
+	contexts := make([]*speechpb.SpeechContext, 0, len(boosts))
+	for _, boost := range boosts {
+		contexts = append(contexts, &speechpb.SpeechContext{
+			Phrases: byBoost[boost],
+			Boost:   boost,
+		})
+	}
+	return contexts
+}
+
+// HandleListPhraseHints returns the phrase hints of all services
+func HandleListPhraseHints(w http.ResponseWriter, r *http.Request) {
+	phraseHints.handleList(w, r)
+}
+
+// HandleGetPhraseHints returns the phrase hints of one service
+func HandleGetPhraseHints(w http.ResponseWriter, r *http.Request) {
+	phraseHints.handleGet(w, r)
+}
+
+// HandlePutPhraseHints replaces the phrase hints of one service
+func HandlePutPhraseHints(w http.ResponseWriter, r *http.Request) {
+	phraseHints.handlePut(w, r)
+}
+
+// HandleDeletePhraseHints removes the phrase hints of one service
+func HandleDeletePhraseHints(w http.ResponseWriter, r *http.Request) {
+	phraseHints.handleDelete(w, r)
+}
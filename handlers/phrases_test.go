@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"strings"
+	"testing"
+)
+
+func TestValidatePhraseHints(t *testing.T) {
+	tests := []struct {
+		name    string
+		hints   []models.PhraseHint
+		wantErr bool
+	}{
+		{"valid", []models.PhraseHint{{Phrase: "Glasfaser", Boost: 10}, {Phrase: "eSIM"}}, false},
+		{"empty phrase", []models.PhraseHint{{Phrase: " ", Boost: 5}}, true},
+		{"too long", []models.PhraseHint{{Phrase: strings.Repeat("a", maxPhraseLength+1)}}, true},
+		{"negative boost", []models.PhraseHint{{Phrase: "Glasfaser", Boost: -1}}, true},
+		{"boost too high", []models.PhraseHint{{Phrase: "Glasfaser", Boost: maxPhraseBoost + 1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePhraseHints(tt.hints); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSpeechContextsGroupByBoost(t *testing.T) {
+	t.Cleanup(phraseHints.reset)
+	err := LoadPhraseHints(writeStoreFile(t, `{"internet": [
+		{"phrase": "Glasfaser", "boost": 10},
+		{"phrase": "Router", "boost": 5},
+		{"phrase": "FRITZ!Box", "boost": 10}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contexts := speechContexts("Internet")
+	if len(contexts) != 2 {
+		t.Fatalf("got %d contexts, want 2", len(contexts))
+	}
+	if contexts[0].Boost != 5 || len(contexts[0].Phrases) != 1 || contexts[1].Boost != 10 || len(contexts[1].Phrases) != 2 {
+		t.Errorf("got contexts %v", contexts)
+	}
+	if speechContexts("mobile") != nil {
+		t.Error("expected no contexts for a service without phrase hints")
+	}
+}
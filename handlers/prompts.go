@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Names of the prompts admins can replace
+const (
+	PromptSuggestions = "suggestions"
+	PromptReply       = "reply"
+	PromptGrammar     = "grammar"
+	PromptSummary     = "summary"
+	PromptAnalysis    = "analysis"
+)
+
+// maxPromptTemplateLength limits templates managed through the admin endpoint
+const maxPromptTemplateLength = 20000
+
+// promptData is passed to the prompt templates; each prompt uses the fields it needs
+type promptData struct {
+	Service          string
+	Issue            string
+	Question         string
+	PreviousQuestion string
+	PreviousAnswer   string
+	// History is the formatted conversation so far
+	History string
+	// Text is the agent's draft or the customer's statement
+	Text      string
+	Language  string
+	Knowledge []string
+}
+
+// defaultPrompts are used for prompts without a stored template
+var defaultPrompts = map[string]string{
+	PromptSuggestions: `You are a customer service assistant for German customers.
+Context: User is contacting about {{.Service}} service regarding {{.Issue}} issue.
+
+{{if .PreviousQuestion}}Previous user question: {{.PreviousQuestion}}
+Previous response: {{.PreviousAnswer}}
+
+{{end}}Latest user question: {{.Question}}
+
+Please provide:
+1. Korean translation of the latest user's question
+2. Two recommended responses in German for a customer service agent to reply with
+3. Korean translations of each of those recommended responses
+
+Format your response as a JSON object with the following structure:
+{
+  "korean_translation": "Korean translation of user's question",
+  "responses": [
+    {
+      "german": "First recommended response in German",
+      "korean": "Korean translation of first response"
+    },
+    {
+      "german": "Second recommended response in German",
+      "korean": "Korean translation of second response"
+    }
+  ]
+}`,
+	PromptReply: `You are a customer service assistant for German customers.
+Context: User is contacting about {{.Service}} service regarding {{.Issue}} issue.
+
+{{if .History}}Conversation so far:
+{{.History}}
+{{end}}The customer service agent wrote the following reply in Korean:
+{{.Text}}
+
+Please provide:
+1. A natural, polite German customer service reply that conveys the same meaning, using the formal "Sie" form
+2. A Korean back-translation of your German reply so the agent can confirm the meaning
+
+Format your response as a JSON object with the following structure:
+{
+  "german": "German reply",
+  "back_translation": "Korean back-translation of the German reply"
+}`,
+	PromptGrammar: `You are a proofreader for German customer service agents. The agent must address the customer formally with "Sie".
+
+{{if .Knowledge}}Service knowledge base:
+{{range .Knowledge}}- {{.}}
+{{end}}
+{{end}}Draft reply:
+{{.Text}}
+
+Please check the draft for:
+1. Grammar, spelling and case errors (type "grammar")
+2. Inconsistent or informal address, e.g. mixing "Sie" and "du" (type "formality")
+3. Impolite or unsuitable tone for customer service (type "tone"){{if .Knowledge}}
+4. Phrases that contradict the service knowledge base (type "knowledge_base"){{end}}
+
+Explain each issue in Korean so the agent understands it.
+
+Format your response as a JSON object with the following structure:
+{
+  "corrected": "Corrected German reply",
+  "issues": [
+    {
+      "type": "grammar | formality | tone | knowledge_base",
+      "original": "Problematic phrase from the draft",
+      "suggestion": "Corrected phrase",
+      "korean_explanation": "Explanation in Korean"
+    }
+  ]
+}`,
+	PromptSummary: `You are a customer service assistant for German customers.
+Context: User contacted us about {{.Service}} service regarding {{.Issue}} issue.
+
+Full conversation:
+{{.History}}
+Summarize the call for the customer service ticket. Provide the summary once in German and once in {{.Language}}.
+
+Format your response as a JSON object with the following structure:
+{
+  "german": {
+    "problem": "The customer's problem",
+    "steps": ["Steps taken during the call"],
+    "resolution": "How the problem was resolved, or that it is still open",
+    "follow_up_actions": ["Actions that still need to be taken"],
+    "sentiment_summary": "How the customer's mood developed during the call"
+  },
+  "translated": {
+    "problem": "...",
+    "steps": ["..."],
+    "resolution": "...",
+    "follow_up_actions": ["..."],
+    "sentiment_summary": "..."
+  }
+}`,
+	PromptAnalysis: `Analyze the following statement of a German customer calling customer service.
+
+Statement: {{.Text}}
+
+The score is a number between -1 (very angry) and 1 (very satisfied).
+
+Format your response as a JSON object with the following structure:
+{
+  "sentiment": "positive | neutral | negative",
+  "score": 0.0,
+  "urgency": "low | medium | high",
+  "escalation_keywords": ["words indicating cancellation, complaints or legal threats, e.g. Kündigung, Anwalt, Beschwerde"]
+}`,
+}
+
+// parsedDefaultPrompts holds the default templates, parsed once
+var parsedDefaultPrompts = func() map[string]*template.Template {
+	parsed := make(map[string]*template.Template, len(defaultPrompts))
+	for name, text := range defaultPrompts {
+		parsed[name] = template.Must(parsePromptTemplate(name, text))
+	}
+	return parsed
+}()
+
+// promptTemplates maps prompt names to templates that replace the defaults
+var promptTemplates = &serviceStore[string]{
+	name:     "prompt templates",
+	param:    "name",
+	log:      assistLog,
+	validate: validatePromptTemplate,
+}
+
+// LoadPromptTemplates reads a JSON file of the form {"name": "template", ...} into the prompt templates.
+// Changes made through the admin endpoint are written back to the same file.
+func LoadPromptTemplates(path string) error {
+	return promptTemplates.Load(path)
+}
+
+// parsePromptTemplate parses a prompt template; referencing an unknown field is an error
+func parsePromptTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// validatePromptTemplate checks that a template is known, parses and renders sample data
+func validatePromptTemplate(name, text string) error {
+	if _, known := defaultPrompts[name]; !known {
+		return fmt.Errorf("unknown prompt %q, expected one of %s", name, strings.Join(promptNames(), ", "))
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("template must not be empty")
+	}
+	if len(text) > maxPromptTemplateLength {
+		return fmt.Errorf("template must not be longer than %d characters", maxPromptTemplateLength)
+	}
+	tmpl, err := parsePromptTemplate(name, text)
+	if err != nil {
+		return err
+	}
+	sample := promptData{
+		Service: "internet", Issue: "outage", Question: "Mein Internet geht nicht.",
+		PreviousQuestion: "Hallo?", PreviousAnswer: "Guten Tag!", History: "Turn 1\nCustomer: Hallo?\n",
+		Text: "Guten Tag", Language: "Korean", Knowledge: []string{"Fact"},
+	}
+	return tmpl.Execute(&strings.Builder{}, sample)
+}
+
+// promptNames returns the names of all prompts in sorted order
+func promptNames() []string {
+	names := make([]string, 0, len(defaultPrompts))
+	for name := range defaultPrompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderPrompt renders the stored template for a prompt, or the default when none is stored.
+// A stored template that fails to render falls back to the default as well.
+func renderPrompt(name string, data promptData) string {
+	var b strings.Builder
+	if text, exists := promptTemplates.get(name); exists {
+		tmpl, err := parsePromptTemplate(name, text)
+		if err == nil {
+			err = tmpl.Execute(&b, data)
+		}
+		if err == nil {
+			return b.String()
+		}
+		assistLog.Warn("rendering prompt template failed, using the default", "prompt", name, "error", err)
+		b.Reset()
+	}
+	// The defaults are rendered in tests, so they cannot fail on promptData
+	parsedDefaultPrompts[name].Execute(&b, data)
+	return b.String()
+}
+
+// HandleListPromptTemplates returns the effective template of every prompt
+func HandleListPromptTemplates(w http.ResponseWriter, r *http.Request) {
+	templates := make(map[string]string, len(defaultPrompts))
+	for name, text := range defaultPrompts {
+		if stored, exists := promptTemplates.get(name); exists {
+			text = stored
+		}
+		templates[name] = text
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// HandleGetPromptTemplate returns the effective template of one prompt
+func HandleGetPromptTemplate(w http.ResponseWriter, r *http.Request) {
+	name := promptTemplates.key(r)
+	text, known := defaultPrompts[name]
+	if !known {
+		http.Error(w, fmt.Sprintf("Unknown prompt %s", name), http.StatusNotFound)
+		return
+	}
+	if stored, exists := promptTemplates.get(name); exists {
+		text = stored
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(text)
+}
+
+// HandlePutPromptTemplate replaces the template of one prompt
+func HandlePutPromptTemplate(w http.ResponseWriter, r *http.Request) {
+	promptTemplates.handlePut(w, r)
+}
+
+// HandleDeletePromptTemplate restores the default template of one prompt
+func HandleDeletePromptTemplate(w http.ResponseWriter, r *http.Request) {
+	promptTemplates.handleDelete(w, r)
+}
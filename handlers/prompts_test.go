@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withPromptTemplate stores a template for one test
+func withPromptTemplate(t *testing.T, name, text string) {
+	t.Helper()
+	if err := promptTemplates.update(func(entries map[string]string) { entries[name] = text }); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(promptTemplates.reset)
+}
+
+func TestValidatePromptTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		prompt   string
+		template string
+		wantErr  bool
+	}{
+		{name: "valid", prompt: PromptReply, template: "Translate {{.Text}} for {{.Service}}"},
+		{name: "conditional knowledge", prompt: PromptGrammar, template: "{{range .Knowledge}}- {{.}}\n{{end}}{{.Text}}"},
+		{name: "unknown prompt", prompt: "greeting", template: "{{.Text}}", wantErr: true},
+		{name: "empty", prompt: PromptReply, template: "  ", wantErr: true},
+		{name: "syntax error", prompt: PromptReply, template: "{{.Text", wantErr: true},
+		{name: "unknown field", prompt: PromptReply, template: "{{.Customer}}", wantErr: true},
+		{name: "too long", prompt: PromptReply, template: strings.Repeat("x", maxPromptTemplateLength+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePromptTemplate(tt.prompt, tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultPromptsRender(t *testing.T) {
+	for _, name := range promptNames() {
+		if err := validatePromptTemplate(name, defaultPrompts[name]); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	withoutFacts := constructGrammarCheckPrompt("Hallo", nil)
+	withFacts := constructGrammarCheckPrompt("Hallo", []string{"Kündigung nur schriftlich"})
+	if strings.Contains(withoutFacts, "knowledge base") {
+		t.Error("grammar prompt mentions the knowledge base without facts")
+	}
+	if !strings.Contains(withFacts, "Service knowledge base:\n- Kündigung nur schriftlich\n\nDraft reply:") ||
+		!strings.Contains(withFacts, `4. Phrases that contradict the service knowledge base (type "knowledge_base")`) {
+		t.Errorf("grammar prompt does not list the facts:\n%s", withFacts)
+	}
+
+	if prompt := constructGPT4oPrompt("internet", "outage", "Geht nicht", "", ""); strings.Contains(prompt, "Previous user question") {
+		t.Error("suggestion prompt has a previous question without one")
+	}
+}
+
+func TestStoredPromptTemplateReplacesDefault(t *testing.T) {
+	withPromptTemplate(t, PromptReply, "Reply for {{.Service}}: {{.Text}}")
+
+	if got := constructTranslateReplyPrompt("internet", "outage", "", "안녕하세요"); got != "Reply for internet: 안녕하세요" {
+		t.Errorf("got prompt %q", got)
+	}
+	if got := constructGrammarCheckPrompt("Hallo", nil); !strings.HasPrefix(got, "You are a proofreader") {
+		t.Errorf("prompt without a stored template does not use the default: %q", got)
+	}
+}
+
+func TestPromptTemplateHandlers(t *testing.T) {
+	t.Cleanup(promptTemplates.reset)
+	router := mux.NewRouter()
+	router.HandleFunc("/prompt-templates/{name}", HandleGetPromptTemplate).Methods("GET")
+	router.HandleFunc("/prompt-templates/{name}", HandlePutPromptTemplate).Methods("PUT")
+	router.HandleFunc("/prompt-templates/{name}", HandleDeletePromptTemplate).Methods("DELETE")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do("GET", "/prompt-templates/summary", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Full conversation") {
+		t.Errorf("got %d %s, want the default summary template", rec.Code, rec.Body.String())
+	}
+	if rec := do("GET", "/prompt-templates/greeting", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown prompt: got status %d, want 404", rec.Code)
+	}
+	if rec := do("PUT", "/prompt-templates/summary", `"{{.Unknown}}"`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid template: got status %d, want 400", rec.Code)
+	}
+	if rec := do("PUT", "/prompt-templates/Summary", `"Summarize {{.History}} in {{.Language}}"`); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("GET", "/prompt-templates/summary", ""); !strings.Contains(rec.Body.String(), "Summarize {{.History}}") {
+		t.Errorf("got %s, want the stored template", rec.Body.String())
+	}
+	if rec := do("DELETE", "/prompt-templates/summary", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want 204", rec.Code)
+	}
+	if rec := do("GET", "/prompt-templates/summary", ""); !strings.Contains(rec.Body.String(), "Full conversation") {
+		t.Errorf("deleting the template did not restore the default: %s", rec.Body.String())
+	}
+}
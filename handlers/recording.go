@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"awesomeProject2/audio"
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordingLog logs call recording and retention
+var recordingLog = logging.For("recording")
+
+var (
+	// recordingDir is the archive directory; recording is disabled when it is empty
+	recordingDir       string
+	recordingRetention = 30 * 24 * time.Hour
+
+	// Index of archived recordings; every WAV file has a JSON sidecar with its metadata,
+	// from which the index is rebuilt at startup
+	recordingStore      = make(map[string]models.Recording)
+	recordingStoreMutex sync.RWMutex
+)
+
+// ConfigureRecording enables call recording into dir, sets the retention period and
+// indexes the recordings of previous runs
+func ConfigureRecording(dir string, retention time.Duration) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("creating recording directory: %w", err)
+	}
+	recordingDir = dir
+	recordingRetention = retention
+	indexed, err := loadRecordingIndex(dir)
+	if err != nil {
+		return fmt.Errorf("indexing recordings: %w", err)
+	}
+	recordingLog.Info("call recording enabled", "dir", dir, "retention", retention.String(), "recordings", indexed)
+	return nil
+}
+
+// sidecarPath returns the path of the metadata file stored next to a recording
+func sidecarPath(wavPath string) string {
+	return strings.TrimSuffix(wavPath, filepath.Ext(wavPath)) + ".json"
+}
+
+// writeSidecar stores the metadata of a recording next to its WAV file
+func writeSidecar(meta models.Recording) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(sidecarPath(meta.Path), data, 0640)
+}
+
+// loadRecordingIndex rebuilds the index from the sidecars in dir. Recordings that were still
+// running when the server stopped get their WAV header repaired and are marked as ended.
+func loadRecordingIndex(dir string) (int, error) {
+	loaded := make(map[string]models.Recording)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var meta models.Recording
+		if err := json.Unmarshal(data, &meta); err != nil || meta.ID == "" {
+			recordingLog.Warn("skipping unreadable recording metadata", "path", path)
+			return nil
+		}
+		meta.Path = strings.TrimSuffix(path, ".json") + ".wav"
+		info, err := os.Stat(meta.Path)
+		if err != nil {
+			recordingLog.Warn("skipping recording metadata without audio", "path", path)
+			return nil
+		}
+
+		if meta.EndedAt.IsZero() {
+			meta.Bytes, err = repairRecording(meta, info.Size())
+			if err != nil {
+				recordingLog.Error("repairing unfinished recording failed", logging.Err(err), "recording_id", meta.ID)
+				return nil
+			}
+			meta.EndedAt = info.ModTime()
+			if err := writeSidecar(meta); err != nil {
+				recordingLog.Error("writing recording metadata failed", logging.Err(err), "recording_id", meta.ID)
+			}
+			recordingLog.Warn("unfinished recording of a previous run repaired", "recording_id", meta.ID)
+		}
+		loaded[meta.ID] = meta
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	recordingStoreMutex.Lock()
+	recordingStore = loaded
+	recordingStoreMutex.Unlock()
+	return len(loaded), nil
+}
+
+// repairRecording writes the final WAV header of a recording that was never closed and
+// returns the size of the usable file; a partial last frame is left out
+func repairRecording(meta models.Recording, size int64) (int64, error) {
+	if size < audio.WAVHeaderSize || meta.Channels <= 0 {
+		return 0, fmt.Errorf("file is not a recording")
+	}
+	file, err := os.OpenFile(meta.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	dataSize := size - audio.WAVHeaderSize
+	dataSize -= dataSize % int64(2*meta.Channels)
+	if err := audio.WriteWAVHeader(file, int(dataSize), meta.SampleRate, meta.Channels); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return audio.WAVHeaderSize + dataSize, file.Close()
+}
+
+// RecordingEnabled reports whether call recording is configured
+func RecordingEnabled() bool {
+	return recordingDir != ""
+}
+
+// newID returns a random hex identifier
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// callRecording writes the raw audio of one speech session to a WAV file.
+// It is only used from the session's read loop.
+type callRecording struct {
+	meta   models.Recording
+	file   *os.File
+	writer *audio.WAVWriter
+}
+
+// startRecording creates a new WAV file for the session
+func startRecording(username, team string, sampleRate, channels int) (*callRecording, error) {
+	id := newID()
+	dir := filepath.Join(recordingDir, sanitizePathComponent(username))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.wav", started.Format("20060102-150405"), id))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := audio.NewWAVWriter(file, sampleRate, channels)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	recording := &callRecording{
+		meta: models.Recording{
+			ID:         id,
+			Username:   username,
+			Team:       team,
+			Path:       path,
+			SampleRate: sampleRate,
+			Channels:   channels,
+			StartedAt:  started,
+		},
+		file:   file,
+		writer: writer,
+	}
+
+	if err := writeSidecar(recording.meta); err != nil {
+		writer.Close()
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("writing recording metadata: %w", err)
+	}
+
+	recordingStoreMutex.Lock()
+	recordingStore[id] = recording.meta
+	recordingStoreMutex.Unlock()
+
+	recordingLog.Info("recording started", "username", username, "recording_id", id)
+	return recording, nil
+}
+
+// write appends raw audio to the recording
+func (c *callRecording) write(data []byte) error {
+	_, err := c.writer.Write(data)
+	return err
+}
+
+// close finalizes the WAV header and updates the index
+func (c *callRecording) close() {
+	if err := c.writer.Close(); err != nil {
+		recordingLog.Error("updating recording header failed", logging.Err(err), "recording_id", c.meta.ID)
+	}
+	if err := c.file.Close(); err != nil {
+		recordingLog.Error("closing recording failed", logging.Err(err), "recording_id", c.meta.ID)
+	}
+
+	c.meta.Bytes = int64(audio.WAVHeaderSize + c.writer.DataSize())
+	c.meta.EndedAt = time.Now()
+	if err := writeSidecar(c.meta); err != nil {
+		recordingLog.Error("writing recording metadata failed", logging.Err(err), "recording_id", c.meta.ID)
+	}
+
+	recordingStoreMutex.Lock()
+	recordingStore[c.meta.ID] = c.meta
+	recordingStoreMutex.Unlock()
+
+	recordingLog.Info("recording stopped", "username", c.meta.Username, "recording_id", c.meta.ID, "bytes", c.meta.Bytes)
+}
+
+// sanitizePathComponent keeps user-provided names from escaping the recording directory
+func sanitizePathComponent(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// GetRecording returns the metadata of an archived recording
+func GetRecording(id string) (models.Recording, bool) {
+	recordingStoreMutex.RLock()
+	defer recordingStoreMutex.RUnlock()
+	recording, exists := recordingStore[id]
+	return recording, exists
+}
+
+// StartRecordingRetention periodically deletes recordings older than the retention period
+func StartRecordingRetention(interval time.Duration) {
+	go func() {
+		for {
+			purgeExpiredRecordings()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// purgeExpiredRecordings removes expired files from disk, including those of previous runs
+func purgeExpiredRecordings() {
+	if recordingDir == "" {
+		return
+	}
+	cutoff := time.Now().Add(-recordingRetention)
+
+	removed := 0
+	filepath.Walk(recordingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".wav" {
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(path); err != nil {
+				recordingLog.Error("removing expired recording failed", logging.Err(err), "path", path)
+				return nil
+			}
+			if err := os.Remove(sidecarPath(path)); err != nil && !os.IsNotExist(err) {
+				recordingLog.Error("removing recording metadata failed", logging.Err(err), "path", path)
+			}
+			removed++
+		}
+		return nil
+	})
+
+	recordingStoreMutex.Lock()
+	for id, recording := range recordingStore {
+		if _, err := os.Stat(recording.Path); os.IsNotExist(err) {
+			delete(recordingStore, id)
+		}
+	}
+	recordingStoreMutex.Unlock()
+
+	if removed > 0 {
+		recordingLog.Info("expired recordings removed", "files", removed)
+	}
+}
+
+// HandleListRecordings returns the recordings of a user
+func HandleListRecordings(w http.ResponseWriter, r *http.Request) {
+	username, ok := viewUsername(r, r.URL.Query().Get("Username"))
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if username == "" {
+		http.Error(w, "Username query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	recordingStoreMutex.RLock()
+	recordings := []models.Recording{}
+	for _, recording := range recordingStore {
+		if recording.Username == username && canAccessUser(r, recording.Username, recording.Team) {
+			recordings = append(recordings, recording)
+		}
+	}
+	recordingStoreMutex.RUnlock()
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.Before(recordings[j].StartedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recordings)
+}
+
+// HandleDownloadRecording serves the WAV file of a recording
+func HandleDownloadRecording(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	recording, exists := GetRecording(id)
+	if !exists || !canAccessUser(r, recording.Username, recording.Team) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if recording.EndedAt.IsZero() {
+		http.Error(w, "Recording is still in progress", http.StatusConflict)
+		return
+	}
+
+	recordingLog.InfoContext(r.Context(), "recording downloaded", "recording_id", id)
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(recording.Path)))
+	http.ServeFile(w, r, recording.Path)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"awesomeProject2/audio"
+	"awesomeProject2/models"
+	"os"
+	"testing"
+	"time"
+)
+
+// withRecordingDir enables recording into a temporary directory for one test
+func withRecordingDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ConfigureRecording(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		recordingDir = ""
+		recordingStore = make(map[string]models.Recording)
+	})
+	return dir
+}
+
+func TestRecordingIndexSurvivesRestart(t *testing.T) {
+	dir := withRecordingDir(t)
+
+	finished, err := startRecording("anna", "berlin", 16000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished.write(make([]byte, 3200))
+	finished.close()
+
+	// The server stops while this recording is running, so its header is never finalized
+	unfinished, err := startRecording("carl", "berlin", 16000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfinished.write(make([]byte, 6401))
+	unfinished.file.Close()
+
+	recordingStore = make(map[string]models.Recording)
+	if err := ConfigureRecording(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, exists := GetRecording(finished.meta.ID)
+	if !exists {
+		t.Fatal("finished recording missing from the rebuilt index")
+	}
+	if got.Username != "anna" || got.Team != "berlin" || got.Path != finished.meta.Path || got.Bytes != audio.WAVHeaderSize+3200 || got.EndedAt.IsZero() {
+		t.Errorf("rebuilt finished recording %+v", got)
+	}
+
+	got, exists = GetRecording(unfinished.meta.ID)
+	if !exists {
+		t.Fatal("unfinished recording missing from the rebuilt index")
+	}
+	if got.EndedAt.IsZero() || got.Bytes != audio.WAVHeaderSize+6400 {
+		t.Errorf("unfinished recording not marked as ended: %+v", got)
+	}
+	data, err := os.ReadFile(got.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, pcm, err := audio.ParseWAV(data)
+	if err != nil {
+		t.Fatalf("repaired recording is not a valid WAV file: %v", err)
+	}
+	if info.Channels != 2 || len(pcm) != 6400 {
+		t.Errorf("repaired recording has %d channels and %d bytes, want 2 and 6400", info.Channels, len(pcm))
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxReplyContextTurns limits how many previous turns are sent as context for reply translation
+const maxReplyContextTurns = 5
+
+// TranslatedReply represents an agent's own reply translated into German
+type TranslatedReply struct {
+	Original        string `json:"original"`
+	German          string `json:"german"`
+	BackTranslation string `json:"back_translation"`
+}
+
+// HandleTranslateReply handles requests to turn the agent's Korean reply into polished German
+func HandleTranslateReply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse request body
+	var requestBody struct {
+		Username string `json:"username"`
+		Text     string `json:"text"`
+		Context  struct {
+			Service string `json:"service"`
+			Issue   string `json:"issue"`
+		} `json:"context"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		assistLog.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestBody.Text) == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := requestUsername(r, requestBody.Username)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	requestBody.Username = username
+
+	assistLog.InfoContext(r.Context(), "translating reply", "username", requestBody.Username, "chars", len(requestBody.Text))
+
+	// The conversation is optional context; an agent may reply before anything was transcribed
+	history := ""
+	if conversations, exists := GetConversations(r.Context(), requestBody.Username); exists {
+		if len(conversations) > maxReplyContextTurns {
+			conversations = conversations[len(conversations)-maxReplyContextTurns:]
+		}
+		history = formatConversationHistory(conversations)
+	}
+
+	prompt := constructTranslateReplyPrompt(
+		requestBody.Context.Service,
+		requestBody.Context.Issue,
+		history,
+		requestBody.Text,
+	)
+
+	content, err := callOpenAIChat(r.Context(), defaultSystemPrompt, prompt)
+	if err != nil {
+		assistLog.ErrorContext(r.Context(), "translating reply failed", logging.Err(err), "username", requestBody.Username)
+		http.Error(w, fmt.Sprintf("Error calling OpenAI API: %v", err), openAIErrorStatus(err))
+		return
+	}
+
+	var reply TranslatedReply
+	if err := json.Unmarshal([]byte(content), &reply); err != nil || reply.German == "" {
+		assistLog.ErrorContext(r.Context(), "parsing translation failed", logging.Err(err), logging.Text("content", content))
+		http.Error(w, "Translation could not be parsed", http.StatusBadGateway)
+		return
+	}
+	reply.Original = requestBody.Text
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// constructTranslateReplyPrompt creates a prompt that turns a Korean draft into a German reply
+func constructTranslateReplyPrompt(service, issue, history, text string) string {
+	return renderPrompt(PromptReply, promptData{Service: service, Issue: issue, History: history, Text: text})
+}
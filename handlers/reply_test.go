@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleTranslateReply(t *testing.T) {
+	stub := withOpenAIStub(t, `{"german": "Gerne helfe ich Ihnen weiter.", "back_translation": "기꺼이 도와드리겠습니다."}`, 10)
+	t.Cleanup(func() {
+		storeMutex.Lock()
+		delete(conversationStore, "anna")
+		storeMutex.Unlock()
+	})
+	newTurnRecorder("anna", "", "call-1").record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Mein Internet geht nicht."}, nil)
+
+	body := `{"username": "anna", "text": "도와드리겠습니다", "context": {"service": "internet", "issue": "outage"}}`
+	rec := httptest.NewRecorder()
+	HandleTranslateReply(rec, httptest.NewRequest(http.MethodPost, "/api/translate-reply", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var reply TranslatedReply
+	if err := json.NewDecoder(rec.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	want := TranslatedReply{Original: "도와드리겠습니다", German: "Gerne helfe ich Ihnen weiter.", BackTranslation: "기꺼이 도와드리겠습니다."}
+	if reply != want {
+		t.Errorf("got %+v, want %+v", reply, want)
+	}
+
+	prompts := stub.received()
+	if len(prompts) != 1 {
+		t.Fatalf("got %d OpenAI requests, want 1", len(prompts))
+	}
+	for _, part := range []string{"internet service regarding outage issue", "Mein Internet geht nicht.", "도와드리겠습니다"} {
+		if !strings.Contains(prompts[0], part) {
+			t.Errorf("prompt does not contain %q:\n%s", part, prompts[0])
+		}
+	}
+}
+
+func TestHandleTranslateReplyErrors(t *testing.T) {
+	agent := models.Identity{Username: "anna", Role: models.RoleAgent, Team: "berlin"}
+	tests := []struct {
+		name     string
+		content  string
+		body     string
+		identity *models.Identity
+		want     int
+	}{
+		{"invalid body", `{"german": "Hallo"}`, `{"text": `, nil, http.StatusBadRequest},
+		{"empty text", `{"german": "Hallo"}`, `{"username": "anna", "text": "  "}`, nil, http.StatusBadRequest},
+		{"reply for another agent", `{"german": "Hallo"}`, `{"username": "carl", "text": "안녕하세요"}`, &agent, http.StatusForbidden},
+		{"unparsable translation", `not json`, `{"username": "anna", "text": "안녕하세요"}`, nil, http.StatusBadGateway},
+		{"empty translation", `{"german": ""}`, `{"username": "anna", "text": "안녕하세요"}`, nil, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := withOpenAIStub(t, tt.content, 10)
+			req := httptest.NewRequest(http.MethodPost, "/api/translate-reply", strings.NewReader(tt.body))
+			if tt.identity != nil {
+				req = req.WithContext(context.WithValue(req.Context(), identityKey{}, *tt.identity))
+			}
+			rec := httptest.NewRecorder()
+			HandleTranslateReply(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want != http.StatusBadGateway && len(stub.received()) != 0 {
+				t.Errorf("OpenAI was called for a rejected request")
+			}
+		})
+	}
+}
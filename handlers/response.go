@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"awesomeProject2/logging"
 	"awesomeProject2/models"
+	"awesomeProject2/tracing"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
-	"log"
 	"net/http"
 	"time"
 )
@@ -25,23 +30,7 @@ type Response struct {
 
 // HandleGenerateResponse handles requests to generate responses using GPT-4o
 func HandleGenerateResponse(w http.ResponseWriter, r *http.Request) {
-	// 요청 로깅
-	log.Printf("[INFO] Generate Response API 요청: %s %s, RemoteAddr: %s", r.Method, r.URL.Path, r.RemoteAddr)
-
-	// Set CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	// Handle preflight OPTIONS request
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
 	if r.Method != http.MethodPost {
-		log.Printf("[ERROR] 잘못된 HTTP 메서드: %s, RemoteAddr: %s", r.Method, r.RemoteAddr)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -56,13 +45,20 @@ func HandleGenerateResponse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		log.Printf("[ERROR] 요청 본문 파싱 실패: %v, RemoteAddr: %s", err, r.RemoteAddr)
+		assistLog.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[INFO] 응답 생성 요청 - Username: %s, Service: %s, Issue: %s",
-		requestBody.Username, requestBody.Context.Service, requestBody.Context.Issue)
+	username, ok := requestUsername(r, requestBody.Username)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	requestBody.Username = username
+
+	assistLog.InfoContext(r.Context(), "generating suggestions",
+		"username", requestBody.Username, "service", requestBody.Context.Service, "issue", requestBody.Context.Issue)
 
 	// Get user conversations with retry
 	var conversations []models.Conversation
@@ -71,121 +67,168 @@ func HandleGenerateResponse(w http.ResponseWriter, r *http.Request) {
 	retryDelay := 200 * time.Millisecond
 
 	for i := 0; i < maxRetries; i++ {
-		conversations, exists = GetConversations(requestBody.Username)
-		if exists && len(conversations) > 0 {
-			log.Printf("[INFO] 사용자 대화 기록 찾음 - Username: %s, 대화 수: %d",
-				requestBody.Username, len(conversations))
+		conversations, exists = GetConversations(r.Context(), requestBody.Username)
+		if exists && latestCustomerIndex(conversations) >= 0 {
+			assistLog.DebugContext(r.Context(), "conversations found", "conversations", len(conversations))
 			break // Found conversations, no need to retry
 		}
 
 		if i < maxRetries-1 {
-			log.Printf("[WARN] 사용자 대화 기록 없음: %s, %v 후 재시도... (시도 %d/%d)",
-				requestBody.Username, retryDelay, i+1, maxRetries)
+			assistLog.WarnContext(r.Context(), "no conversations yet, retrying",
+				"username", requestBody.Username, "delay", retryDelay.String(), "attempt", i+1, "max_attempts", maxRetries)
 			time.Sleep(retryDelay)
 		}
 	}
 
-	if !exists || len(conversations) == 0 {
-		log.Printf("[ERROR] 최대 재시도 후에도 대화 기록 없음 - Username: %s", requestBody.Username)
+	if !exists || latestCustomerIndex(conversations) < 0 {
+		assistLog.WarnContext(r.Context(), "no conversations found", "username", requestBody.Username)
 		http.Error(w, "No conversations found for this user", http.StatusNotFound)
 		return
 	}
 
-	// Extract latest question and previous conversation
-	latestIdx := len(conversations) - 1
+	response, err := generateSuggestions(r.Context(), requestBody.Username, requestBody.Context.Service, requestBody.Context.Issue, conversations)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error calling OpenAI API: %v", err), openAIErrorStatus(err))
+		return
+	}
+
+	// Return the response directly to client
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// generateSuggestions builds the prompt from the latest customer question and asks GPT-4o for suggested responses
+func generateSuggestions(ctx context.Context, username, service, issue string, conversations []models.Conversation) (response []byte, err error) {
+	defer observeSince(generateResponseDuration, time.Now())
+	ctx, span := assistTracer.Start(ctx, "assist.generate_suggestions",
+		trace.WithAttributes(attribute.String("assist.service", service), attribute.Int("assist.conversations", len(conversations))))
+	defer func() { tracing.End(span, err) }()
+
+	// Extract latest question and previous conversation; only customer speech counts as a question
+	latestIdx := latestCustomerIndex(conversations)
+	if latestIdx < 0 {
+		return nil, fmt.Errorf("no customer question found")
+	}
 	latestQuestion := conversations[latestIdx].Question
-	log.Printf("[INFO] 최신 질문: %s", latestQuestion)
+	assistLog.DebugContext(ctx, "latest question", logging.Text("question", latestQuestion))
 
 	var previousQuestion, previousAnswer string
 	if latestIdx > 0 {
 		previousQuestion = conversations[latestIdx-1].Question
 		previousAnswer = conversations[latestIdx-1].Answer
-		log.Printf("[INFO] 이전 대화 존재 - 질문: %s, 답변: %s", previousQuestion, previousAnswer)
-	} else {
-		log.Printf("[INFO] 이전 대화 없음")
+		assistLog.DebugContext(ctx, "previous exchange",
+			logging.Text("question", previousQuestion), logging.Text("answer", previousAnswer))
 	}
 
 	// Construct prompt for GPT-4o
+	_, promptSpan := assistTracer.Start(ctx, "assist.build_prompt",
+		trace.WithAttributes(attribute.String("assist.prompt", "suggestions")))
 	prompt := constructGPT4oPrompt(
-		requestBody.Context.Service,
-		requestBody.Context.Issue,
+		service,
+		issue,
 		latestQuestion,
 		previousQuestion,
 		previousAnswer,
 	)
+	promptSpan.End()
 
 	// Call OpenAI API
-	log.Printf("[INFO] OpenAI API 호출 시작 - Username: %s", requestBody.Username)
-	response, err := callOpenAIAPI(prompt)
+	response, err = callOpenAIAPI(ctx, prompt)
 	if err != nil {
-		log.Printf("[ERROR] OpenAI API 호출 실패: %v, Username: %s", err, requestBody.Username)
-		http.Error(w, fmt.Sprintf("Error calling OpenAI API: %v", err), http.StatusInternalServerError)
-		return
+		assistLog.ErrorContext(ctx, "generating suggestions failed", logging.Err(err), "username", username)
+		return nil, err
 	}
-	log.Printf("[INFO] OpenAI API 응답 수신 완료 - Username: %s, 응답 길이: %d bytes",
-		requestBody.Username, len(response))
+	return response, nil
+}
 
-	// Return the response directly to client
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(response)
-	log.Printf("[INFO] 클라이언트에 응답 전송 완료 - Username: %s", requestBody.Username)
+// latestCustomerIndex returns the index of the latest conversation entry containing customer speech, or -1
+func latestCustomerIndex(conversations []models.Conversation) int {
+	for i := len(conversations) - 1; i >= 0; i-- {
+		if conversations[i].Question != "" {
+			return i
+		}
+	}
+	return -1
 }
 
 // constructGPT4oPrompt creates a prompt for GPT-4o
 func constructGPT4oPrompt(service, issue, latestQuestion, previousQuestion, previousAnswer string) string {
-	previousConversationContext := ""
-	if previousQuestion != "" {
-		previousConversationContext = fmt.Sprintf(
-			"Previous user question: %s\nPrevious response: %s\n\n",
-			previousQuestion,
-			previousAnswer,
-		)
+	return renderPrompt(PromptSuggestions, promptData{
+		Service:          service,
+		Issue:            issue,
+		Question:         latestQuestion,
+		PreviousQuestion: previousQuestion,
+		PreviousAnswer:   previousAnswer,
+	})
+}
+
+// callOpenAIAPI sends a request to the OpenAI API and returns the response
+func callOpenAIAPI(ctx context.Context, prompt string) ([]byte, error) {
+	responseContent, err := callOpenAIChat(ctx, defaultSystemPrompt, prompt)
+	if err != nil {
+		return nil, err
 	}
 
-	return fmt.Sprintf(
-		`You are a customer service assistant for German customers. 
-Context: User is contacting about %s service regarding %s issue.
-
-%sLatest user question: %s
-
-Please provide:
-1. Korean translation of the latest user's question
-2. Two recommended responses in German for a customer service agent to reply with
-3. Korean translations of each of those recommended responses
-
-Format your response as a JSON object with the following structure:
-{
-  "korean_translation": "Korean translation of user's question",
-  "responses": [
-    {
-      "german": "First recommended response in German",
-      "korean": "Korean translation of first response"
-    },
-    {
-      "german": "Second recommended response in German",
-      "korean": "Korean translation of second response"
-    }
-  ]
-}`,
-		service,
-		issue,
-		previousConversationContext,
-		latestQuestion,
-	)
+	// Parse the content to verify it's valid JSON
+	var parsedResponse GPT4ResponseFormat
+	if err := json.Unmarshal([]byte(responseContent), &parsedResponse); err != nil {
+		// If it's not valid JSON, return a formatted JSON response
+		assistLog.WarnContext(ctx, "openai returned invalid JSON, using fallback response",
+			logging.Err(err), logging.Text("content", responseContent))
+
+		// Try to extract information and create a valid JSON response
+		// This is a fallback in case GPT doesn't return proper JSON
+		fallbackResponses.Inc()
+		return createFallbackResponse()
+	}
+
+	assistLog.DebugContext(ctx, "suggestions parsed", logging.Text("translation", parsedResponse.KoreanTranslation),
+		"suggestions", len(parsedResponse.Responses))
+
+	// If it's valid JSON, return it
+	return []byte(responseContent), nil
 }
 
-// callOpenAIAPI sends a request to the OpenAI API and returns the response
-func callOpenAIAPI(prompt string) ([]byte, error) {
-	log.Printf("[INFO] OpenAI API 요청 준비")
-	url := "https://api.openai.com/v1/chat/completions"
+// assistLog logs suggestions, translations, grammar checks, summaries and turn analysis
+var assistLog = logging.For("assist")
+
+// assistTracer traces suggestion generation, prompt construction and OpenAI requests
+var assistTracer = tracing.Tracer("assist")
+
+// defaultSystemPrompt is the system message used for customer service prompts
+const defaultSystemPrompt = "You are a customer service assistant that helps with German and Korean languages."
+
+var (
+	// openAIAPIKey and openAIModel are used for all chat completion requests
+	openAIAPIKey string
+	openAIModel  = "gpt-4o"
+	// openAIChatURL is the chat completions endpoint; tests point it at a stub server
+	openAIChatURL = "https://api.openai.com/v1/chat/completions"
+)
+
+// ConfigureOpenAI sets the API key and chat model used for OpenAI requests
+func ConfigureOpenAI(apiKey, model string) {
+	openAIAPIKey = apiKey
+	openAIModel = model
+}
+
+// callOpenAIChat sends a chat completion request to the OpenAI API and returns the content of the first choice
+func callOpenAIChat(ctx context.Context, systemPrompt, prompt string) (content string, err error) {
+	ctx, span := assistTracer.Start(ctx, "openai.chat", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("openai.model", openAIModel), attribute.Int("openai.prompt_chars", len(prompt))))
+	defer func() { tracing.End(span, err) }()
+
+	if err := checkBudget(ctx); err != nil {
+		return "", err
+	}
 
 	// Create request body
 	requestBody := map[string]interface{}{
-		"model": "gpt-4o",
+		"model": openAIModel,
 		"messages": []map[string]string{
 			{
 				"role":    "system",
-				"content": "You are a customer service assistant that helps with German and Korean languages.",
+				"content": systemPrompt,
 			},
 			{
 				"role":    "user",
@@ -197,49 +240,45 @@ func callOpenAIAPI(prompt string) ([]byte, error) {
 
 	requestJSON, err := json.Marshal(requestBody)
 	if err != nil {
-		log.Printf("[ERROR] 요청 JSON 생성 실패: %v", err)
-		return nil, err
+		return "", err
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestJSON))
+	req, err := http.NewRequestWithContext(ctx, "POST", openAIChatURL, bytes.NewBuffer(requestJSON))
 	if err != nil {
-		log.Printf("[ERROR] HTTP 요청 생성 실패: %v", err)
-		return nil, err
+		return "", err
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+models.GetOpenAIAPIKey())
-
-	log.Printf("[INFO] OpenAI API 요청 시작")
+	req.Header.Set("Authorization", "Bearer "+openAIAPIKey)
 
 	// Send request
+	start := time.Now()
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("[ERROR] API 요청 전송 실패: %v", err)
-		return nil, err
+		assistLog.ErrorContext(ctx, "openai request failed", logging.Err(err))
+		observeOpenAIError("chat", 0)
+		return "", err
 	}
 	defer resp.Body.Close()
-
-	log.Printf("[INFO] OpenAI API 응답 수신 - 상태 코드: %d", resp.StatusCode)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
 
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("[ERROR] 응답 본문 읽기 실패: %v", err)
-		return nil, err
+		assistLog.ErrorContext(ctx, "reading openai response failed", logging.Err(err))
+		return "", err
 	}
 
 	// Check for error status code
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("[ERROR] OpenAI API 오류 상태 코드: %d, 응답: %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("OpenAI API error: %s", string(body))
+		assistLog.ErrorContext(ctx, "openai request failed", "status", resp.StatusCode, "response", string(body))
+		observeOpenAIError("chat", resp.StatusCode)
+		return "", fmt.Errorf("OpenAI API error: %s", string(body))
 	}
 
-	log.Printf("[INFO] OpenAI API 응답 본문 크기: %d bytes", len(body))
-
 	// Parse OpenAI response
 	var openAIResponse struct {
 		Choices []struct {
@@ -247,45 +286,34 @@ func callOpenAIAPI(prompt string) ([]byte, error) {
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage struct {
+			TotalTokens int64 `json:"total_tokens"`
+		} `json:"usage"`
 	}
 
 	if err := json.Unmarshal(body, &openAIResponse); err != nil {
-		log.Printf("[ERROR] OpenAI 응답 파싱 실패: %v", err)
-		return nil, err
+		assistLog.ErrorContext(ctx, "parsing openai response failed", logging.Err(err))
+		return "", err
 	}
 
+	recordUsage(ctx, openAIResponse.Usage.TotalTokens)
+
 	if len(openAIResponse.Choices) == 0 {
-		log.Printf("[ERROR] OpenAI 응답에 선택지 없음")
-		return nil, fmt.Errorf("no response from OpenAI")
+		assistLog.ErrorContext(ctx, "openai response has no choices")
+		return "", fmt.Errorf("no response from OpenAI")
 	}
 
 	// Extract the content (should be a JSON string)
 	responseContent := openAIResponse.Choices[0].Message.Content
-	log.Printf("[INFO] OpenAI 응답 콘텐츠 추출 - 길이: %d 문자", len(responseContent))
-
-	// Parse the content to verify it's valid JSON
-	var parsedResponse GPT4ResponseFormat
-	if err := json.Unmarshal([]byte(responseContent), &parsedResponse); err != nil {
-		// If it's not valid JSON, return a formatted JSON response
-		log.Printf("[WARN] OpenAI가 유효한 JSON을 반환하지 않음. 오류: %v", err)
-		log.Printf("[WARN] 응답 내용: %s", responseContent)
-
-		// Try to extract information and create a valid JSON response
-		// This is a fallback in case GPT doesn't return proper JSON
-		log.Printf("[INFO] 대체 응답 생성 중")
-		return createFallbackResponse()
-	}
-
-	log.Printf("[INFO] 유효한 JSON 응답 확인됨 - 번역: %s, 추천 응답 수: %d",
-		parsedResponse.KoreanTranslation, len(parsedResponse.Responses))
+	assistLog.InfoContext(ctx, "openai request completed", "model", openAIModel,
+		"duration_ms", time.Since(start).Milliseconds(), "chars", len(responseContent),
+		"tokens", openAIResponse.Usage.TotalTokens)
 
-	// If it's valid JSON, return it
-	return []byte(responseContent), nil
+	return responseContent, nil
 }
 
 // createFallbackResponse attempts to create a valid JSON response when GPT doesn't return proper JSON
 func createFallbackResponse() ([]byte, error) {
-	log.Printf("[INFO] 대체 응답 생성")
 	// Simple fallback - in a real application, you might want to do more sophisticated parsing
 	fallback := GPT4ResponseFormat{
 		KoreanTranslation: "Translation could not be parsed",
@@ -303,10 +331,7 @@ func createFallbackResponse() ([]byte, error) {
 
 	result, err := json.Marshal(fallback)
 	if err != nil {
-		log.Printf("[ERROR] 대체 응답 생성 실패: %v", err)
 		return nil, err
 	}
-
-	log.Printf("[INFO] 대체 응답 생성 완료")
 	return result, nil
 }
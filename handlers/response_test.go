@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// openAIStub answers chat completion requests with fixed content and records the prompts it received
+type openAIStub struct {
+	content string
+	tokens  int64
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+// received returns the user prompts of all requests so far
+func (s *openAIStub) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.prompts...)
+}
+
+// withOpenAIStub points chat completion requests at a stub server for one test
+func withOpenAIStub(t *testing.T, content string, tokens int64) *openAIStub {
+	t.Helper()
+	stub := &openAIStub{content: content, tokens: tokens}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Messages) == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		stub.mu.Lock()
+		stub.prompts = append(stub.prompts, request.Messages[len(request.Messages)-1].Content)
+		stub.mu.Unlock()
+
+		response := map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": stub.content}}},
+			"usage":   map[string]int64{"total_tokens": stub.tokens},
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	previous := openAIChatURL
+	openAIChatURL = server.URL
+	t.Cleanup(func() {
+		openAIChatURL = previous
+		server.Close()
+	})
+	return stub
+}
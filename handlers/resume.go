@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"errors"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+// sessionState tracks whether a speech session is connected, waiting for a reconnect or finished
+type sessionState int
+
+const (
+	sessionAttached sessionState = iota
+	sessionParked
+	sessionFinished
+)
+
+// Limits for resuming speech sessions
+const (
+	// maxReplayEvents is how many server events are kept for replay after a reconnect
+	maxReplayEvents = 1000
+	// backgroundFlushTimeout bounds the wait for running suggestions and analyses when a session
+	// finishes; it stays below shutdownFlushTimeout so draining sessions can still store them
+	backgroundFlushTimeout = 10 * time.Second
+)
+
+var (
+	// resumeGracePeriod is how long a dropped session waits for its client to reconnect
+	resumeGracePeriod = 30 * time.Second
+	// resumeTakeoverTimeout bounds the wait for a stale connection to let go of its session
+	resumeTakeoverTimeout = 10 * time.Second
+
+	// Speech sessions by resume token
+	speechSessions      = make(map[string]*speechSession)
+	speechSessionsMutex sync.Mutex
+)
+
+// errSessionNotFound is returned for unknown, expired or foreign resume tokens
+var errSessionNotFound = errors.New("unknown or expired resume token")
+
+// errSessionBusy is returned when the previous connection does not release the session in time
+var errSessionBusy = errors.New("session is still attached to another connection")
+
+// ConfigureSessionResume sets how long dropped speech sessions can be resumed
+func ConfigureSessionResume(grace time.Duration) {
+	resumeGracePeriod = grace
+	speechLog.Info("session resume grace period configured", "grace", grace.String())
+}
+
+// registerSession makes a new session resumable by its token. It fails once the server is shutting down.
+func registerSession(s *speechSession) error {
+	speechSessionsMutex.Lock()
+	defer speechSessionsMutex.Unlock()
+	if shuttingDown {
+		return errShuttingDown
+	}
+	s.state = sessionAttached
+	s.detached = make(chan struct{})
+	speechSessions[s.token] = s
+	liveSessions.Add(1)
+	return nil
+}
+
+// resumeSession reattaches a parked session. A session still attached to a stale connection is taken over.
+func resumeSession(token, username string) (*speechSession, error) {
+	speechSessionsMutex.Lock()
+	s, exists := speechSessions[token]
+	if !exists || s.username != username {
+		speechSessionsMutex.Unlock()
+		return nil, errSessionNotFound
+	}
+
+	if s.state == sessionAttached {
+		// The server may not have noticed the network drop yet
+		detached := s.detached
+		speechSessionsMutex.Unlock()
+
+		s.eventsMu.Lock()
+		conn := s.conn
+		s.eventsMu.Unlock()
+		if conn != nil {
+			conn.closeWith(websocket.CloseGoingAway, closeReasonResumed)
+		}
+
+		select {
+		case <-detached:
+		case <-time.After(resumeTakeoverTimeout):
+			return nil, errSessionBusy
+		}
+		speechSessionsMutex.Lock()
+	}
+	defer speechSessionsMutex.Unlock()
+
+	if s.state != sessionParked {
+		return nil, errSessionNotFound
+	}
+	s.graceTimer.Stop()
+	s.state = sessionAttached
+	s.detached = make(chan struct{})
+	return s, nil
+}
+
+// park keeps a disconnected session for the grace period. Once draining started, the session
+// is finished instead, because DrainSpeechSessions no longer looks for parked sessions.
+func (s *speechSession) park() {
+	speechSessionsMutex.Lock()
+	if shuttingDown {
+		speechSessionsMutex.Unlock()
+		s.finish()
+		return
+	}
+	defer speechSessionsMutex.Unlock()
+	if s.state == sessionFinished {
+		return
+	}
+	if s.state == sessionAttached {
+		close(s.detached)
+	}
+	s.state = sessionParked
+	s.graceTimer = time.AfterFunc(resumeGracePeriod, s.expire)
+	trace.SpanFromContext(s.ctx).AddEvent("session parked")
+	speechLog.InfoContext(s.ctx, "session parked", "grace", resumeGracePeriod.String())
+}
+
+// expire finishes a parked session whose client did not reconnect in time
+func (s *speechSession) expire() {
+	speechSessionsMutex.Lock()
+	if s.state != sessionParked {
+		speechSessionsMutex.Unlock()
+		return
+	}
+	s.state = sessionFinished
+	delete(speechSessions, s.token)
+	speechSessionsMutex.Unlock()
+
+	speechLog.InfoContext(s.ctx, "session resume grace period expired")
+	trace.SpanFromContext(s.ctx).AddEvent("resume grace period expired")
+	s.close()
+	liveSessions.Done()
+}
+
+// finish ends the session immediately
+func (s *speechSession) finish() {
+	speechSessionsMutex.Lock()
+	if s.state == sessionFinished {
+		speechSessionsMutex.Unlock()
+		return
+	}
+	if s.state == sessionAttached {
+		close(s.detached)
+	}
+	s.state = sessionFinished
+	delete(speechSessions, s.token)
+	speechSessionsMutex.Unlock()
+
+	s.close()
+	liveSessions.Done()
+}
+
+// info describes the session for monitoring. Callers hold speechSessionsMutex.
+func (s *speechSession) info() models.SessionInfo {
+	state := "active"
+	if s.state == sessionParked {
+		state = "reconnecting"
+	}
+	return models.SessionInfo{
+		ID:        s.id,
+		Username:  s.username,
+		Team:      s.team,
+		Service:   s.service,
+		State:     state,
+		Channels:  s.channels,
+		Turns:     s.recorder.turnCount(),
+		StartedAt: s.startedAt,
+	}
+}
+
+// close releases the resources of a finished session
+func (s *speechSession) close() {
+	s.stopRecording()
+	s.cancel()
+	s.client.Close()
+	s.waitForBackground(backgroundFlushTimeout)
+
+	speechLog.InfoContext(s.ctx, "speech session finished", "username", s.username, "turns", s.recorder.turnCount())
+
+	for _, recognition := range s.recognitions {
+		if recognition.vad != nil {
+			speechLog.DebugContext(s.ctx, "silence dropped by vad", "channel", recognition.channel, "bytes", recognition.vad.DroppedBytes())
+		}
+	}
+
+	if s.recorder.turnCount() == 0 {
+		speechLog.WarnContext(s.ctx, "session produced no transcripts", "username", s.username)
+	}
+
+	span := trace.SpanFromContext(s.ctx)
+	span.SetAttributes(attribute.Int("speech.turns", s.recorder.turnCount()))
+	span.End()
+}
+
+// attach makes conn the session's connection and replays the events the client missed
+func (s *speechSession) attach(conn *safeConn, lastEventSeq int64, resumed bool) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	s.conn = conn
+
+	// Events older than the replay buffer are lost; the client is told so it can refetch the conversation
+	replayComplete := len(s.events) == 0 || s.events[0]["event_seq"].(int64) <= lastEventSeq+1
+	if err := conn.WriteJSON(map[string]interface{}{
+		"type":            "session",
+		"session_id":      s.id,
+		"resume_token":    s.token,
+		"resumed":         resumed,
+		"grace_seconds":   int(resumeGracePeriod / time.Second),
+		"event_seq":       s.eventSeq,
+		"replay_complete": replayComplete,
+	}); err != nil {
+		speechLog.ErrorContext(s.ctx, "sending session info failed", logging.Err(err))
+		return
+	}
+
+	replayed := 0
+	for _, event := range s.events {
+		if event["event_seq"].(int64) <= lastEventSeq {
+			continue
+		}
+		if err := conn.WriteJSON(event); err != nil {
+			speechLog.ErrorContext(s.ctx, "replaying events failed", logging.Err(err))
+			return
+		}
+		replayed++
+	}
+	if replayed > 0 {
+		speechLog.InfoContext(s.ctx, "missed events replayed", "events", replayed)
+	}
+}
+
+// detach forgets the current connection; later events are only buffered
+func (s *speechSession) detach() {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	s.conn = nil
+}
+
+// send numbers a session event, keeps it for replay and writes it to the attached connection, if any
+func (s *speechSession) send(event map[string]interface{}) error {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	s.eventSeq++
+	event["event_seq"] = s.eventSeq
+	s.events = append(s.events, event)
+	if len(s.events) > maxReplayEvents {
+		s.events = s.events[len(s.events)-maxReplayEvents:]
+	}
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.WriteJSON(event)
+}
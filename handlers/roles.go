@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+)
+
+// roleRanks orders roles so that higher roles include the permissions of lower ones
+var roleRanks = map[string]int{
+	models.RoleAgent:      1,
+	models.RoleSupervisor: 2,
+	models.RoleAdmin:      3,
+}
+
+// RequireRole is a mux middleware that only admits identities with at least the given role.
+// Without an identity, which only happens when authentication is disabled, agent routes pass
+// and supervisor and admin routes are refused.
+func RequireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			identity, authenticated := IdentityFromContext(r.Context())
+			if !authenticated {
+				if role == models.RoleAgent && !AuthEnabled() {
+					next.ServeHTTP(w, r)
+					return
+				}
+				authLog.WarnContext(r.Context(), "unauthenticated request to a restricted route",
+					"required_role", role, "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			rank, known := roleRanks[identity.Role]
+			if !known || rank < roleRanks[role] {
+				authLog.WarnContext(r.Context(), "insufficient role", "username", identity.Username,
+					"role", identity.Role, "required_role", role, "method", r.Method, "path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestTeam returns the team of the authenticated caller. Records are stamped with the
+// team of their owner when they are created, so supervisor access does not depend on later requests.
+func requestTeam(r *http.Request) string {
+	identity, _ := IdentityFromContext(r.Context())
+	return identity.Team
+}
+
+// teamOf returns the team the API-key file assigns to a user
+func teamOf(username string) string {
+	apiKeysMutex.RLock()
+	defer apiKeysMutex.RUnlock()
+	return apiKeyTeams[username]
+}
+
+// canAccessUser reports whether the request may read data owned by username. team is the owner's
+// team recorded on the data; when it is empty the API-key file is consulted.
+// Agents see only their own data, supervisors also their team's and admins everything.
+func canAccessUser(r *http.Request, username, team string) bool {
+	identity, authenticated := IdentityFromContext(r.Context())
+	if !authenticated || identity.Username == username {
+		return true
+	}
+	switch identity.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleSupervisor:
+		if team == "" {
+			team = teamOf(username)
+		}
+		return identity.Team != "" && team == identity.Team
+	}
+	return false
+}
+
+// viewUsername returns whose data a read request asks for, defaulting to the caller.
+// ok is false when an agent asks for someone else's data. Supervisors may ask for other users;
+// callers check the team recorded on the data with canAccessUser once it is loaded.
+func viewUsername(r *http.Request, requested string) (username string, ok bool) {
+	identity, authenticated := IdentityFromContext(r.Context())
+	if !authenticated {
+		return requested, true
+	}
+	if requested == "" {
+		return identity.Username, true
+	}
+	if requested != identity.Username && identity.Role != models.RoleSupervisor && identity.Role != models.RoleAdmin {
+		authLog.WarnContext(r.Context(), "access to another user's data denied", "username", identity.Username, "requested", requested)
+		return "", false
+	}
+	return requested, true
+}
+
+// conversationsTeam returns the team recorded on the latest conversation entry that has one
+func conversationsTeam(conversations []models.Conversation) string {
+	for i := len(conversations) - 1; i >= 0; i-- {
+		if conversations[i].Team != "" {
+			return conversations[i].Team
+		}
+	}
+	return ""
+}
+
+// HandleGetConversations returns the stored conversation of a user
+func HandleGetConversations(w http.ResponseWriter, r *http.Request) {
+	// Turns of batch transcription jobs are stored under the job ID rather than the user
+	if _, conversations, isJob := transcriptionConversations(r, r.URL.Query().Get("SessionID")); isJob {
+		if len(conversations) == 0 {
+			http.Error(w, "No conversations found for this session", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversations)
+		return
+	}
+
+	username, ok := viewUsername(r, r.URL.Query().Get("Username"))
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if username == "" {
+		http.Error(w, "Username query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	conversations, exists := GetConversations(r.Context(), username)
+	if !exists {
+		http.Error(w, "No conversations found for this user", http.StatusNotFound)
+		return
+	}
+	if !canAccessUser(r, username, conversationsTeam(conversations)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if sessionID := r.URL.Query().Get("SessionID"); sessionID != "" {
+		conversations = sessionConversations(conversations, sessionID)
+		if len(conversations) == 0 {
+			http.Error(w, "No conversations found for this session", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// HandleListSessions lists the live speech sessions the caller may monitor
+func HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	speechSessionsMutex.Lock()
+	sessions := []models.SessionInfo{}
+	for _, s := range speechSessions {
+		if !canAccessUser(r, s.username, s.team) {
+			continue
+		}
+		sessions = append(sessions, s.info())
+	}
+	speechSessionsMutex.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
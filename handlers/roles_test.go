@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireRole(t *testing.T) {
+	agent := &models.Identity{Username: "anna", Role: models.RoleAgent}
+	admin := &models.Identity{Username: "ben", Role: models.RoleAdmin}
+	tests := []struct {
+		name     string
+		identity *models.Identity
+		role     string
+		method   string
+		want     int
+	}{
+		{"no identity on agent route", nil, models.RoleAgent, http.MethodGet, http.StatusOK},
+		{"no identity on supervisor route", nil, models.RoleSupervisor, http.MethodGet, http.StatusUnauthorized},
+		{"no identity on admin route", nil, models.RoleAdmin, http.MethodPut, http.StatusUnauthorized},
+		{"preflight on admin route", nil, models.RoleAdmin, http.MethodOptions, http.StatusOK},
+		{"agent on admin route", agent, models.RoleAdmin, http.MethodDelete, http.StatusForbidden},
+		{"admin on admin route", admin, models.RoleAdmin, http.MethodPut, http.StatusOK},
+		{"admin on supervisor route", admin, models.RoleSupervisor, http.MethodGet, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireRole(tt.role)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, "/api/admin/phrase-hints/internet", nil)
+			if tt.identity != nil {
+				req = req.WithContext(context.WithValue(req.Context(), identityKey{}, *tt.identity))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanAccessUser(t *testing.T) {
+	apiKeysMutex.Lock()
+	previous := apiKeyTeams
+	apiKeyTeams = map[string]string{"carl": "berlin", "dora": "hamburg"}
+	apiKeysMutex.Unlock()
+	t.Cleanup(func() {
+		apiKeysMutex.Lock()
+		apiKeyTeams = previous
+		apiKeysMutex.Unlock()
+	})
+
+	supervisor := models.Identity{Username: "sam", Role: models.RoleSupervisor, Team: "berlin"}
+	tests := []struct {
+		name     string
+		identity models.Identity
+		username string
+		team     string
+		want     bool
+	}{
+		{"own data", models.Identity{Username: "anna", Role: models.RoleAgent}, "anna", "", true},
+		{"agent reads another agent", models.Identity{Username: "anna", Role: models.RoleAgent, Team: "berlin"}, "carl", "berlin", false},
+		{"supervisor reads team record", supervisor, "erik", "berlin", true},
+		{"supervisor reads other team record", supervisor, "erik", "hamburg", false},
+		{"supervisor falls back to key file", supervisor, "carl", "", true},
+		{"key file team of other team", supervisor, "dora", "", false},
+		{"unknown team", supervisor, "erik", "", false},
+		{"supervisor without team", models.Identity{Username: "sue", Role: models.RoleSupervisor}, "erik", "", false},
+		{"admin", models.Identity{Username: "ben", Role: models.RoleAdmin}, "dora", "hamburg", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/recordings", nil)
+			req = req.WithContext(context.WithValue(req.Context(), identityKey{}, tt.identity))
+			if got := canAccessUser(req, tt.username, tt.team); got != tt.want {
+				t.Errorf("canAccessUser(%q, %q) = %v, want %v", tt.username, tt.team, got, tt.want)
+			}
+		})
+	}
+}
+
+// asIdentity returns a request carrying identity, or none when identity is nil
+func asIdentity(method, target string, identity *models.Identity) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if identity != nil {
+		req = req.WithContext(context.WithValue(req.Context(), identityKey{}, *identity))
+	}
+	return req
+}
+
+func TestViewUsername(t *testing.T) {
+	tests := []struct {
+		name      string
+		identity  *models.Identity
+		requested string
+		want      string
+		wantOK    bool
+	}{
+		{"auth disabled trusts the request", nil, "carl", "carl", true},
+		{"defaults to the caller", &models.Identity{Username: "anna", Role: models.RoleAgent}, "", "anna", true},
+		{"agent asks for own data", &models.Identity{Username: "anna", Role: models.RoleAgent}, "anna", "anna", true},
+		{"agent asks for another user", &models.Identity{Username: "anna", Role: models.RoleAgent}, "carl", "", false},
+		{"unknown role asks for another user", &models.Identity{Username: "anna", Role: "guest"}, "carl", "", false},
+		{"supervisor asks for another user", &models.Identity{Username: "sam", Role: models.RoleSupervisor}, "carl", "carl", true},
+		{"admin asks for another user", &models.Identity{Username: "ben", Role: models.RoleAdmin}, "carl", "carl", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := viewUsername(asIdentity(http.MethodGet, "/api/conversations", tt.identity), tt.requested)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("viewUsername(%q) = %q, %v, want %q, %v", tt.requested, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHandleGetConversationsChecksAccess(t *testing.T) {
+	t.Cleanup(func() {
+		storeMutex.Lock()
+		delete(conversationStore, "carl")
+		storeMutex.Unlock()
+	})
+	newTurnRecorder("carl", "berlin", "call-1").record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Hallo?"}, nil)
+
+	tests := []struct {
+		name     string
+		identity *models.Identity
+		want     int
+	}{
+		{"owner", &models.Identity{Username: "carl", Role: models.RoleAgent, Team: "berlin"}, http.StatusOK},
+		{"agent of the same team", &models.Identity{Username: "anna", Role: models.RoleAgent, Team: "berlin"}, http.StatusForbidden},
+		{"supervisor of the team", &models.Identity{Username: "sam", Role: models.RoleSupervisor, Team: "berlin"}, http.StatusOK},
+		{"supervisor of another team", &models.Identity{Username: "sue", Role: models.RoleSupervisor, Team: "hamburg"}, http.StatusForbidden},
+		{"admin", &models.Identity{Username: "ben", Role: models.RoleAdmin}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HandleGetConversations(rec, asIdentity(http.MethodGet, "/api/conversations?Username=carl", tt.identity))
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleListSessions(t *testing.T) {
+	started := time.Now()
+	speechSessionsMutex.Lock()
+	for i, owner := range []struct{ username, team string }{{"carl", "berlin"}, {"dora", "hamburg"}, {"erik", "berlin"}} {
+		token := "list-sessions-" + owner.username
+		speechSessions[token] = &speechSession{
+			id: owner.username + "-call", token: token, username: owner.username, team: owner.team,
+			startedAt: started.Add(time.Duration(i) * time.Second), recorder: newTurnRecorder(owner.username, owner.team, ""),
+		}
+	}
+	speechSessionsMutex.Unlock()
+	t.Cleanup(func() {
+		speechSessionsMutex.Lock()
+		for _, username := range []string{"carl", "dora", "erik"} {
+			delete(speechSessions, "list-sessions-"+username)
+		}
+		speechSessionsMutex.Unlock()
+	})
+
+	handler := RequireRole(models.RoleSupervisor)(http.HandlerFunc(HandleListSessions))
+	tests := []struct {
+		name     string
+		identity *models.Identity
+		want     int
+		wantIDs  []string
+	}{
+		{"agent", &models.Identity{Username: "carl", Role: models.RoleAgent, Team: "berlin"}, http.StatusForbidden, nil},
+		{"supervisor sees the team", &models.Identity{Username: "sam", Role: models.RoleSupervisor, Team: "berlin"}, http.StatusOK, []string{"carl-call", "erik-call"}},
+		{"supervisor without team", &models.Identity{Username: "sue", Role: models.RoleSupervisor}, http.StatusOK, []string{}},
+		{"admin sees all", &models.Identity{Username: "ben", Role: models.RoleAdmin}, http.StatusOK, []string{"carl-call", "dora-call", "erik-call"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, asIdentity(http.MethodGet, "/api/sessions", tt.identity))
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var sessions []models.SessionInfo
+			if err := json.NewDecoder(rec.Body).Decode(&sessions); err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, session := range sessions {
+				if session.State != "active" {
+					t.Errorf("session %s has state %q, want active", session.ID, session.State)
+				}
+				ids = append(ids, session.ID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("got sessions %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("got sessions %v, want %v in start order", ids, tt.wantIDs)
+					break
+				}
+			}
+		})
+	}
+}
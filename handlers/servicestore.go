@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// serviceStore holds settings keyed by a lower-cased name, such as the phrase hints or the
+// knowledge base of each service. Once loaded from a JSON file, changes made through the
+// admin handlers are written back to it before they take effect.
+type serviceStore[T any] struct {
+	// name is used in errors and log messages, e.g. "phrase hints"
+	name string
+	// param is the route variable holding the key, e.g. "service"
+	param string
+	log   *slog.Logger
+	// validate checks one entry before it is loaded or stored
+	validate func(key string, value T) error
+	// empty is returned by the get handler for keys without an entry
+	empty T
+
+	mu      sync.RWMutex
+	entries map[string]T
+	path    string
+}
+
+// Load replaces the entries with the contents of a JSON file of the form {"key": value}
+// and remembers the file for saving changes
+func (s *serviceStore[T]) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", s.name, err)
+	}
+
+	var entries map[string]T
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.name, err)
+	}
+
+	loaded := make(map[string]T, len(entries))
+	for key, value := range entries {
+		key = strings.ToLower(key)
+		if err := s.validate(key, value); err != nil {
+			return fmt.Errorf("%s for %s: %w", s.name, key, err)
+		}
+		loaded[key] = value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = loaded
+	s.path = path
+	s.log.Info(s.name+" loaded", "entries", len(loaded))
+	return nil
+}
+
+// get returns the entry stored under key
+func (s *serviceStore[T]) get(key string) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, exists := s.entries[strings.ToLower(key)]
+	return value, exists
+}
+
+// update applies change to a copy of the entries, saves the copy to the loaded file, if any,
+// and swaps it in only when saving succeeded
+func (s *serviceStore[T]) update(change func(entries map[string]T)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make(map[string]T, len(s.entries)+1)
+	for key, value := range s.entries {
+		entries[key] = value
+	}
+	change(entries)
+
+	if s.path != "" {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.path, data, 0644); err != nil {
+			return err
+		}
+	}
+	s.entries = entries
+	return nil
+}
+
+// reset drops all entries and forgets the file
+func (s *serviceStore[T]) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.path = ""
+}
+
+// key returns the lower-cased route variable that selects the entry
+func (s *serviceStore[T]) key(r *http.Request) string {
+	return strings.ToLower(mux.Vars(r)[s.param])
+}
+
+// handleList returns all entries
+func (s *serviceStore[T]) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := s.entries
+	if entries == nil {
+		entries = map[string]T{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleGet returns one entry, or the empty value when none is stored
+func (s *serviceStore[T]) handleGet(w http.ResponseWriter, r *http.Request) {
+	value, exists := s.get(s.key(r))
+	if !exists {
+		value = s.empty
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// handlePut replaces one entry
+func (s *serviceStore[T]) handlePut(w http.ResponseWriter, r *http.Request) {
+	key := s.key(r)
+
+	var value T
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		s.log.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.validate(key, value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.update(func(entries map[string]T) { entries[key] = value }); err != nil {
+		s.log.ErrorContext(r.Context(), "saving "+s.name+" failed", logging.Err(err))
+		http.Error(w, fmt.Sprintf("Saving %s failed", s.name), http.StatusInternalServerError)
+		return
+	}
+
+	s.log.InfoContext(r.Context(), s.name+" updated", s.param, key)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// handleDelete removes one entry
+func (s *serviceStore[T]) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key := s.key(r)
+
+	if _, exists := s.get(key); !exists {
+		http.Error(w, fmt.Sprintf("No %s found for %s", s.name, key), http.StatusNotFound)
+		return
+	}
+	if err := s.update(func(entries map[string]T) { delete(entries, key) }); err != nil {
+		s.log.ErrorContext(r.Context(), "saving "+s.name+" failed", logging.Err(err))
+		http.Error(w, fmt.Sprintf("Saving %s failed", s.name), http.StatusInternalServerError)
+		return
+	}
+
+	s.log.InfoContext(r.Context(), s.name+" deleted", s.param, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it over
+// path, so a crash leaves either the old or the new file but never a truncated one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
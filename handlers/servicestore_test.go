@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestStore creates a store of word lists that rejects empty words
+func newTestStore() *serviceStore[[]string] {
+	return &serviceStore[[]string]{
+		name:  "word lists",
+		param: "service",
+		log:   assistLog,
+		validate: func(service string, words []string) error {
+			for _, word := range words {
+				if word == "" {
+					return errors.New("word must not be empty")
+				}
+			}
+			return nil
+		},
+		empty: []string{},
+	}
+}
+
+// writeStoreFile writes a JSON store file and returns its path
+func writeStoreFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "store.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// storeRouter serves the admin handlers of a store
+func storeRouter(store *serviceStore[[]string]) func(method, path, body string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc("/words", store.handleList).Methods("GET")
+	router.HandleFunc("/words/{service}", store.handleGet).Methods("GET")
+	router.HandleFunc("/words/{service}", store.handlePut).Methods("PUT")
+	router.HandleFunc("/words/{service}", store.handleDelete).Methods("DELETE")
+	return func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+}
+
+func TestServiceStoreLoad(t *testing.T) {
+	store := newTestStore()
+	if err := store.Load(writeStoreFile(t, `{"Internet": ["Glasfaser"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if words, exists := store.get("INTERNET"); !exists || len(words) != 1 {
+		t.Errorf("got %v, %v; want keys to match regardless of case", words, exists)
+	}
+
+	for name, content := range map[string]string{
+		"invalid JSON":  `{"internet": [`,
+		"invalid entry": `{"internet": [""]}`,
+	} {
+		if err := store.Load(writeStoreFile(t, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, exists := store.get("internet"); !exists {
+		t.Error("a failed load replaced the entries")
+	}
+	if err := store.Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file: expected an error")
+	}
+}
+
+func TestServiceStoreHandlers(t *testing.T) {
+	store := newTestStore()
+	path := writeStoreFile(t, `{"internet": ["Glasfaser"]}`)
+	if err := store.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	serve := storeRouter(store)
+
+	if rec := serve(http.MethodPut, "/words/Mobile", `["eSIM"]`); rec.Code != http.StatusOK {
+		t.Fatalf("put: got status %d, want 200", rec.Code)
+	}
+	if rec := serve(http.MethodPut, "/words/mobile", `[""]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid put: got status %d, want 400", rec.Code)
+	}
+	if rec := serve(http.MethodPut, "/words/mobile", `{`); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed put: got status %d, want 400", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/words/MOBILE", ""); strings.TrimSpace(rec.Body.String()) != `["eSIM"]` {
+		t.Errorf("get: got %s", rec.Body)
+	}
+	if rec := serve(http.MethodGet, "/words/tv", ""); strings.TrimSpace(rec.Body.String()) != `[]` {
+		t.Errorf("get without entry: got %s, want []", rec.Body)
+	}
+
+	// Changes are written back to the loaded file
+	saved := newTestStore()
+	if err := saved.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := saved.get("mobile"); !exists {
+		t.Error("saved file does not hold the new entry")
+	}
+
+	if rec := serve(http.MethodDelete, "/words/internet", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: got status %d, want 204", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/words/internet", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: got status %d, want 404", rec.Code)
+	}
+	var listed map[string][]string
+	if err := json.NewDecoder(serve(http.MethodGet, "/words", "").Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || len(listed["mobile"]) != 1 {
+		t.Errorf("list: got %v, want only mobile", listed)
+	}
+}
+
+func TestServiceStoreChangesAreSavedBeforeTheySwapIn(t *testing.T) {
+	store := newTestStore()
+	if err := store.Load(writeStoreFile(t, `{"internet": ["Glasfaser"], "mobile": ["eSIM"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	serve := storeRouter(store)
+
+	// Once the file cannot be written, neither a replacement nor a deletion takes effect
+	store.path = filepath.Join(t.TempDir(), "missing", "store.json")
+	if rec := serve(http.MethodPut, "/words/mobile", `["Roaming"]`); rec.Code != http.StatusInternalServerError {
+		t.Errorf("failed put: got status %d, want 500", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/words/internet", ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("failed delete: got status %d, want 500", rec.Code)
+	}
+	if words, _ := store.get("mobile"); len(words) != 1 || words[0] != "eSIM" {
+		t.Errorf("mobile changed to %v after a failed save", words)
+	}
+	if _, exists := store.get("internet"); !exists {
+		t.Error("internet deleted after a failed save")
+	}
+}
+
+func TestServiceStoreWithoutFile(t *testing.T) {
+	store := newTestStore()
+	serve := storeRouter(store)
+	if rec := serve(http.MethodPut, "/words/internet", `["Glasfaser"]`); rec.Code != http.StatusOK {
+		t.Fatalf("put: got status %d, want 200", rec.Code)
+	}
+	if _, exists := store.get("internet"); !exists {
+		t.Error("entry not kept in memory")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Fatalf("got %q, %v; want the new content", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, want 0640", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	// A failed write leaves the existing file untouched
+	if err := os.Mkdir(filepath.Join(dir, "target"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "target"), []byte("new"), 0644); err == nil {
+		t.Error("expected an error when replacing a directory")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"awesomeProject2/audio"
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	speech "cloud.google.com/go/speech/apiv1"
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Modes for generating suggestions automatically during a speech session
+const (
+	// autoSuggestSpeechEnd generates suggestions when VAD detects the end of a customer utterance
+	autoSuggestSpeechEnd = "speech_end"
+	// autoSuggestFinal generates suggestions for every finalized customer turn
+	autoSuggestFinal = "final"
+)
+
+// speechSession holds the state of one speech session, which may span several WebSocket connections
+type speechSession struct {
+	// ctx is cancelled when the session finishes
+	ctx    context.Context
+	cancel context.CancelFunc
+	// id identifies the session in logs, traces and stored conversations; token is the secret used to resume it
+	id        string
+	token     string
+	startedAt time.Time
+	username  string
+	// team is the owner's team from the credential that opened the session
+	team         string
+	service      string
+	issue        string
+	client       *speech.Client
+	recognitions []*recognitionChannel
+	converter    *audio.Converter
+	// deinterleaver splits stereo audio and holds back frames split across messages
+	deinterleaver audio.Deinterleaver
+	recorder      *turnRecorder
+	autoSuggest   string
+	channels      int
+
+	// state, detached and graceTimer are guarded by speechSessionsMutex
+	state      sessionState
+	detached   chan struct{}
+	graceTimer *time.Timer
+
+	// eventsMu guards the attached connection and the replay buffer
+	eventsMu sync.Mutex
+	conn     *safeConn
+	events   []map[string]interface{}
+	eventSeq int64
+
+	// recording is only touched by the goroutine serving the session, so it needs no locking
+	recordingConsent bool
+	recording        *callRecording
+
+	// ttsBusy is set while speech is synthesized for the client
+	ttsBusy atomic.Bool
+
+	mu sync.Mutex
+	// pendingSpeechEnd is set when the customer stopped speaking before the final result arrived
+	pendingSpeechEnd bool
+	// finalSinceSpeechStart is set once a customer turn was finalized in the current utterance
+	finalSinceSpeechStart bool
+	// cancelSuggestions cancels the in-flight generation; suggestionSeq identifies the latest one
+	cancelSuggestions context.CancelFunc
+	suggestionSeq     int
+	// background counts running suggestion generations and turn analyses, so a finishing
+	// session can wait for them
+	background sync.WaitGroup
+}
+
+// analysisTimeout bounds a background turn analysis; afterwards the rule-based analyzer is used
+const analysisTimeout = 10 * time.Second
+
+// commitTurn stores the finalized turn and starts the analysis of customer speech
+func (s *speechSession) commitTurn(ctx context.Context, turn models.Turn) {
+	entry, seq := s.recorder.record(ctx, turn, nil)
+
+	if turn.Speaker == models.SpeakerCustomer {
+		s.analyzeInBackground(ctx, turn, entry, seq)
+		s.onCustomerFinal()
+	}
+}
+
+// analyzeInBackground analyzes a customer turn without blocking the receive loop, since the
+// LLM analyzer can take seconds. The result is sent to the client and attached to the
+// conversation entry when it arrives.
+func (s *speechSession) analyzeInBackground(ctx context.Context, turn models.Turn, entry, seq int) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), analysisTimeout)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer cancel()
+
+		// 감정 및 긴급도 분석 후 클라이언트에 전송
+		result := analyzeTurn(ctx, turn.Text)
+		if result.Escalate {
+			speechLog.WarnContext(ctx, "escalation detected", "username", s.username,
+				"keywords", len(result.EscalationKeywords), "urgency", result.Urgency)
+		}
+		s.recorder.storeAnalysis(ctx, entry, seq, result)
+		if err := s.send(map[string]interface{}{
+			"type":       "analysis",
+			"transcript": turn.Text,
+			"analysis":   result,
+		}); err != nil {
+			speechLog.ErrorContext(ctx, "sending analysis failed", logging.Err(err))
+		}
+	}()
+}
+
+// handleVADEvent forwards a speech start/end event to the client and tracks utterance boundaries
+func (s *speechSession) handleVADEvent(recognition *recognitionChannel, event audio.VADEvent) {
+	speechLog.DebugContext(s.ctx, "vad event", "channel", recognition.channel, "event", event.Type, "offset_ms", event.OffsetMs)
+	if event.Type == audio.EventSpeechStart {
+		recognition.markSpeechStart()
+	}
+
+	if err := s.send(map[string]interface{}{
+		"type":      event.Type,
+		"offset_ms": event.OffsetMs,
+		"speaker":   recognition.speaker,
+		"channel":   recognition.channel,
+	}); err != nil {
+		speechLog.ErrorContext(s.ctx, "sending vad event failed", logging.Err(err))
+	}
+
+	if recognition.speaker != models.SpeakerCustomer || s.autoSuggest != autoSuggestSpeechEnd {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch event.Type {
+	case audio.EventSpeechStart:
+		// A new utterance makes suggestions for the previous one obsolete
+		s.finalSinceSpeechStart = false
+		s.pendingSpeechEnd = false
+		s.cancelPendingSuggestions()
+	case audio.EventSpeechEnd:
+		if s.finalSinceSpeechStart {
+			s.triggerSuggestions()
+		} else {
+			// Wait for the recognizer to finalize the utterance before generating
+			s.pendingSpeechEnd = true
+		}
+	}
+}
+
+// onCustomerFinal triggers a pending generation once the customer's utterance is stored
+func (s *speechSession) onCustomerFinal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finalSinceSpeechStart = true
+	if s.autoSuggest == autoSuggestFinal {
+		s.triggerSuggestions()
+		return
+	}
+	if s.pendingSpeechEnd {
+		s.pendingSpeechEnd = false
+		s.triggerSuggestions()
+	}
+}
+
+// cancelPendingSuggestions cancels the in-flight generation, if any. Callers hold s.mu.
+func (s *speechSession) cancelPendingSuggestions() {
+	if s.cancelSuggestions != nil {
+		s.cancelSuggestions()
+		s.cancelSuggestions = nil
+	}
+}
+
+// triggerSuggestions generates suggested responses in the background, pushes them to the client and
+// stores them with the conversation entry they answer. A newer trigger cancels the previous generation;
+// finishing the session does not, so close can wait for it.
+// Callers hold s.mu.
+func (s *speechSession) triggerSuggestions() {
+	s.cancelPendingSuggestions()
+	ctx, cancel := context.WithCancel(context.WithoutCancel(s.ctx))
+	s.cancelSuggestions = cancel
+	s.suggestionSeq++
+	seq := s.suggestionSeq
+	entry := s.recorder.currentEntry()
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer cancel()
+		speechLog.InfoContext(ctx, "generating suggestions", "seq", seq)
+
+		conversations, _ := GetConversations(ctx, s.username)
+		response, err := generateSuggestions(ctx, s.username, s.service, s.issue, conversations)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// Drop results of generations that were superseded while the request was running
+		if ctx.Err() != nil || seq != s.suggestionSeq {
+			speechLog.InfoContext(ctx, "suggestions superseded", "seq", seq)
+			return
+		}
+		s.cancelSuggestions = nil
+
+		if err != nil {
+			s.send(map[string]interface{}{
+				"type":  "suggestions_error",
+				"seq":   seq,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		s.recorder.storeSuggestions(ctx, entry, response)
+		if err := s.send(map[string]interface{}{
+			"type":        "suggestions",
+			"seq":         seq,
+			"suggestions": json.RawMessage(response),
+		}); err != nil {
+			speechLog.ErrorContext(ctx, "sending suggestions failed", logging.Err(err))
+		}
+	}()
+}
+
+// waitForBackground lets running generations and analyses finish and be stored. After timeout
+// generations are cancelled; analyses end on their own timeout.
+func (s *speechSession) waitForBackground(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	speechLog.WarnContext(s.ctx, "suggestions did not finish in time", "timeout", timeout.String())
+	s.mu.Lock()
+	s.cancelPendingSuggestions()
+	s.mu.Unlock()
+	<-done
+}
+
+// setRecordingConsent starts or stops the call recording according to the customer's consent
+func (s *speechSession) setRecordingConsent(granted bool) {
+	s.recordingConsent = granted
+	if !granted {
+		s.stopRecording()
+		return
+	}
+	if !RecordingEnabled() || s.recording != nil {
+		return
+	}
+
+	recording, err := startRecording(s.username, s.team, 16000, s.channels)
+	if err != nil {
+		speechLog.ErrorContext(s.ctx, "starting recording failed", logging.Err(err))
+		return
+	}
+	s.recording = recording
+	s.send(map[string]interface{}{
+		"type":         "recording_started",
+		"recording_id": recording.meta.ID,
+	})
+}
+
+// recordAudio appends incoming audio to the recording when consent was given
+func (s *speechSession) recordAudio(data []byte) {
+	if s.recording == nil {
+		return
+	}
+	if err := s.recording.write(data); err != nil {
+		speechLog.ErrorContext(s.ctx, "writing recording failed", logging.Err(err))
+		s.stopRecording()
+	}
+}
+
+// stopRecording finalizes the current recording, if any
+func (s *speechSession) stopRecording() {
+	if s.recording == nil {
+		return
+	}
+	s.recording.close()
+	s.send(map[string]interface{}{
+		"type":         "recording_stopped",
+		"recording_id": s.recording.meta.ID,
+	})
+	s.recording = nil
+}
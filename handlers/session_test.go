@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"awesomeProject2/audio"
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingAnalyzer returns its analysis once release is closed
+type blockingAnalyzer struct {
+	release chan struct{}
+}
+
+func (a blockingAnalyzer) Analyze(ctx context.Context, text string) (models.TurnAnalysis, error) {
+	<-a.release
+	return models.TurnAnalysis{Sentiment: models.SentimentNegative, Urgency: models.UrgencyHigh, Source: "llm"}, nil
+}
+
+func TestCommitTurnAnalyzesInBackground(t *testing.T) {
+	analyzer := blockingAnalyzer{release: make(chan struct{})}
+	SetTurnAnalyzer(analyzer)
+	t.Cleanup(func() { SetTurnAnalyzer(NewRuleBasedAnalyzer()) })
+
+	username := "async-analysis"
+	t.Cleanup(func() {
+		storeMutex.Lock()
+		delete(conversationStore, username)
+		storeMutex.Unlock()
+	})
+	s := &speechSession{ctx: context.Background(), username: username, recorder: newTurnRecorder(username, "", "call-1")}
+
+	committed := make(chan struct{})
+	go func() {
+		s.commitTurn(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Das ist unzumutbar!"})
+		close(committed)
+	}()
+	select {
+	case <-committed:
+	case <-time.After(time.Second):
+		t.Fatal("commitTurn waited for the analysis")
+	}
+
+	conversations, _ := GetConversations(context.Background(), username)
+	if len(conversations) != 1 || conversations[0].Analysis != nil {
+		t.Fatalf("got %+v, want the turn stored without an analysis", conversations)
+	}
+
+	close(analyzer.release)
+	s.waitForBackground(time.Second)
+
+	conversations, _ = GetConversations(context.Background(), username)
+	if analysis := conversations[0].Analysis; analysis == nil || analysis.Source != "llm" {
+		t.Errorf("got analysis %+v, want the LLM result attached", analysis)
+	}
+	if len(s.events) != 1 || s.events[0]["type"] != "analysis" {
+		t.Errorf("got events %v, want the analysis sent", s.events)
+	}
+}
+
+// gatedOpenAI holds chat completion requests until release is closed and reports requests whose
+// client gave up waiting
+type gatedOpenAI struct {
+	arrived   chan struct{}
+	cancelled chan struct{}
+	release   chan struct{}
+}
+
+// withGatedOpenAI points chat completion requests at a gated stub server for one test
+func withGatedOpenAI(t *testing.T) *gatedOpenAI {
+	t.Helper()
+	gate := &gatedOpenAI{arrived: make(chan struct{}, 10), cancelled: make(chan struct{}, 10), release: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server notices a client that gave up only once the body was read
+		io.Copy(io.Discard, r.Body)
+		gate.arrived <- struct{}{}
+		select {
+		case <-gate.release:
+		case <-r.Context().Done():
+			gate.cancelled <- struct{}{}
+			return
+		}
+		content := `{"korean_translation": "인터넷이 안 돼요", "responses": [{"german": "Das tut mir leid.", "korean": "죄송합니다."}]}`
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": content}}},
+		})
+	}))
+	previous := openAIChatURL
+	openAIChatURL = server.URL
+	t.Cleanup(func() {
+		openAIChatURL = previous
+		server.Close()
+	})
+	return gate
+}
+
+// wait fails the test unless ch receives within a second
+func wait(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// suggestionSession returns a session with one stored customer turn
+func suggestionSession(t *testing.T, autoSuggest string) *speechSession {
+	t.Helper()
+	username := "auto-suggest"
+	t.Cleanup(func() {
+		storeMutex.Lock()
+		delete(conversationStore, username)
+		storeMutex.Unlock()
+	})
+	s := &speechSession{ctx: context.Background(), username: username, autoSuggest: autoSuggest, recorder: newTurnRecorder(username, "", "call-1")}
+	s.recorder.record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Mein Internet geht nicht."}, nil)
+	return s
+}
+
+// sentEventTypes returns the types of the events sent so far, with the seq of suggestion events
+func sentEventTypes(s *speechSession) []interface{} {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	var types []interface{}
+	for _, event := range s.events {
+		types = append(types, event["type"])
+		if seq, ok := event["seq"]; ok {
+			types = append(types, seq)
+		}
+	}
+	return types
+}
+
+func TestNewerSuggestionsSupersedePending(t *testing.T) {
+	gate := withGatedOpenAI(t)
+	s := suggestionSession(t, autoSuggestFinal)
+
+	s.onCustomerFinal()
+	wait(t, gate.arrived, "the first generation")
+	s.onCustomerFinal()
+	wait(t, gate.cancelled, "the first generation to be cancelled")
+	wait(t, gate.arrived, "the second generation")
+
+	close(gate.release)
+	s.waitForBackground(time.Second)
+
+	got := sentEventTypes(s)
+	if len(got) != 2 || got[0] != "suggestions" || got[1] != 2 {
+		t.Errorf("got events %v, want only the suggestions of the second generation", got)
+	}
+	if s.cancelSuggestions != nil {
+		t.Errorf("the finished generation is still registered")
+	}
+}
+
+func TestSpeechStartCancelsSuggestions(t *testing.T) {
+	gate := withGatedOpenAI(t)
+	s := suggestionSession(t, autoSuggestSpeechEnd)
+	customer := &recognitionChannel{speaker: models.SpeakerCustomer}
+
+	// The end of speech waits for the final result of the utterance
+	s.handleVADEvent(customer, audio.VADEvent{Type: audio.EventSpeechEnd})
+	select {
+	case <-gate.arrived:
+		t.Fatal("suggestions were generated before the final result")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.onCustomerFinal()
+	wait(t, gate.arrived, "the generation")
+
+	// The customer speaking again makes the pending suggestions obsolete
+	s.handleVADEvent(customer, audio.VADEvent{Type: audio.EventSpeechStart})
+	wait(t, gate.cancelled, "the generation to be cancelled")
+	s.waitForBackground(time.Second)
+
+	got := sentEventTypes(s)
+	if len(got) != 2 || got[0] != audio.EventSpeechEnd || got[1] != audio.EventSpeechStart {
+		t.Errorf("got events %v, want only the VAD events", got)
+	}
+}
+
+func TestAgentSpeechDoesNotCancelSuggestions(t *testing.T) {
+	gate := withGatedOpenAI(t)
+	s := suggestionSession(t, autoSuggestSpeechEnd)
+
+	s.handleVADEvent(&recognitionChannel{speaker: models.SpeakerCustomer}, audio.VADEvent{Type: audio.EventSpeechEnd})
+	s.onCustomerFinal()
+	wait(t, gate.arrived, "the generation")
+	s.handleVADEvent(&recognitionChannel{speaker: models.SpeakerAgent}, audio.VADEvent{Type: audio.EventSpeechStart})
+
+	close(gate.release)
+	s.waitForBackground(time.Second)
+
+	got := sentEventTypes(s)
+	if len(got) != 4 || got[2] != "suggestions" || got[3] != 1 {
+		t.Errorf("got events %v, want the suggestions after the VAD events", got)
+	}
+}
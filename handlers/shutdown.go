@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+// shutdownFlushTimeout bounds the wait for final results after connections were closed
+const shutdownFlushTimeout = 15 * time.Second
+
+var (
+	// shuttingDown is set once draining started; guarded by speechSessionsMutex
+	shuttingDown bool
+
+	// liveSessions counts registered sessions until they are finished and their resources released
+	liveSessions sync.WaitGroup
+)
+
+// errShuttingDown is returned for new sessions while the server shuts down
+var errShuttingDown = errors.New("server is shutting down")
+
+// isShuttingDown reports whether draining started
+func isShuttingDown() bool {
+	speechSessionsMutex.Lock()
+	defer speechSessionsMutex.Unlock()
+	return shuttingDown
+}
+
+// DrainSpeechSessions stops new speech sessions and asks connected clients to wrap up.
+// Sessions still running when ctx is done are closed; their final transcripts are stored before it returns.
+func DrainSpeechSessions(ctx context.Context) error {
+	speechSessionsMutex.Lock()
+	shuttingDown = true
+	var attached, parked []*speechSession
+	for _, s := range speechSessions {
+		if s.state == sessionParked {
+			parked = append(parked, s)
+		} else {
+			attached = append(attached, s)
+		}
+	}
+	speechSessionsMutex.Unlock()
+
+	speechLog.Info("draining speech sessions", "attached", len(attached), "parked", len(parked))
+
+	// Parked sessions cannot be resumed anymore; they finish concurrently because each may
+	// still wait for its running suggestions
+	for _, s := range parked {
+		go s.finish()
+	}
+
+	drainSeconds := 0
+	if deadline, ok := ctx.Deadline(); ok {
+		drainSeconds = int(time.Until(deadline) / time.Second)
+	}
+	for _, s := range attached {
+		if err := s.send(map[string]interface{}{
+			"type":          "shutdown",
+			"drain_seconds": drainSeconds,
+		}); err != nil {
+			speechLog.ErrorContext(s.ctx, "sending shutdown notice failed", logging.Err(err))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		liveSessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		speechLog.Info("all speech sessions finished")
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Closing the connection ends the audio; serve then drains the final results and finishes the session
+	speechSessionsMutex.Lock()
+	var remaining []*speechSession
+	for _, s := range speechSessions {
+		remaining = append(remaining, s)
+	}
+	speechSessionsMutex.Unlock()
+
+	speechLog.Warn("drain period expired, closing speech sessions", "sessions", len(remaining))
+	for _, s := range remaining {
+		s.eventsMu.Lock()
+		conn := s.conn
+		s.eventsMu.Unlock()
+		// Sessions without a connection are between connections and finish on their own
+		if conn != nil {
+			conn.closeWith(websocket.CloseGoingAway, closeReasonShutdown)
+		}
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(shutdownFlushTimeout):
+		return fmt.Errorf("%d speech sessions did not finish in time", len(remaining))
+	}
+}
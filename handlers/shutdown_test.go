@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	speech "cloud.google.com/go/speech/apiv1"
+	"context"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testSession registers a session that is not connected to a recognizer
+func testSession(t *testing.T) *speechSession {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := speech.NewClient(ctx, option.WithEndpoint("localhost:1"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &speechSession{
+		ctx:      ctx,
+		cancel:   cancel,
+		id:       newID(),
+		token:    newID(),
+		username: "anna",
+		client:   client,
+		recorder: newTurnRecorder("anna", "", ""),
+	}
+	if err := registerSession(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParkFinishesSessionsWhileShuttingDown(t *testing.T) {
+	t.Cleanup(func() {
+		speechSessionsMutex.Lock()
+		shuttingDown = false
+		speechSessionsMutex.Unlock()
+	})
+	s := testSession(t)
+
+	// The drain starts after the connection dropped but before the session is parked
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- DrainSpeechSessions(ctx) }()
+	for !isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	s.park()
+
+	speechSessionsMutex.Lock()
+	state := s.state
+	_, registered := speechSessions[s.token]
+	speechSessionsMutex.Unlock()
+	if state != sessionFinished || registered {
+		t.Errorf("got state %v, registered %v; want the session finished", state, registered)
+	}
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("drain failed: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("drain still waits for the session")
+	}
+}
+
+func TestResumeStatus(t *testing.T) {
+	previous := resumeTakeoverTimeout
+	resumeTakeoverTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { resumeTakeoverTimeout = previous })
+
+	// The session stays attached because its connection never lets go
+	s := testSession(t)
+	t.Cleanup(s.finish)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"unknown token", "unknown", http.StatusNotFound},
+		{"session still attached", s.token, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/speech?Username=anna&LastEventSeq=0&ResumeToken="+tt.token, nil)
+			rec := httptest.NewRecorder()
+			HandleSpeechToText(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,25 +1,59 @@
 package handlers
 
 import (
+	"awesomeProject2/audio"
+	"awesomeProject2/logging"
 	"awesomeProject2/models"
+	"awesomeProject2/tracing"
 	speech "cloud.google.com/go/speech/apiv1"
 	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// speechLog logs speech sessions and recognition
+var speechLog = logging.For("speech")
+
+// Tracers of speech sessions and of the conversation and summary stores
+var (
+	speechTracer = tracing.Tracer("speech")
+	storeTracer  = tracing.Tracer("store")
+)
+
+// Default recognition languages for each side of the call and the default summary language
+var (
+	defaultCustomerLanguage = "de-DE"
+	defaultAgentLanguage    = "de-DE"
+	defaultSummaryLanguage  = "ko"
+)
+
+// ConfigureLanguages sets the languages used when a request does not name one
+func ConfigureLanguages(customer, agent, summary string) {
+	defaultCustomerLanguage = customer
+	defaultAgentLanguage = agent
+	defaultSummaryLanguage = summary
+}
+
 var (
 	upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all connections for local testing
-		},
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		CheckOrigin:       checkWebSocketOrigin,
 		EnableCompression: true,
+		// Selected when the client authenticates with "Sec-WebSocket-Protocol: bearer, <token>"
+		Subprotocols: []string{wsAuthProtocol},
 	}
 
 	// In-memory data store for conversations
@@ -27,232 +61,897 @@ var (
 	storeMutex        sync.RWMutex
 )
 
+// safeConn serializes writes to a WebSocket connection shared by several goroutines
+type safeConn struct {
+	conn   *websocket.Conn
+	mu     sync.Mutex
+	limits WebSocketLimits
+	// lastActivity is the time of the last client message in Unix nanoseconds
+	lastActivity atomic.Int64
+	// closing is set once the server sent a close frame; closeReason is guarded by mu
+	closing     atomic.Bool
+	closeReason string
+	// closed is set by Close and guarded by mu
+	closed bool
+}
+
+// errConnClosed is returned for writes to a connection that was closed
+var errConnClosed = errors.New("websocket connection closed")
+
+// Close closes the connection; later writes fail with errConnClosed instead of reaching the closed socket
+func (c *safeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return c.conn.Close()
+}
+
+// WriteJSON writes a JSON message to the connection
+func (c *safeConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errConnClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.limits.WriteTimeout))
+	return c.conn.WriteJSON(v)
+}
+
+// WriteMessage writes a raw message to the connection
+func (c *safeConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errConnClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.limits.WriteTimeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// recognitionChannel is one Google streaming recognition bound to one audio channel
+type recognitionChannel struct {
+	channel  int
+	speaker  string
+	language string
+	stream   speechpb.Speech_StreamingRecognizeClient
+	// span covers the current stream from opening until its last result
+	span trace.Span
+	// diarizer is set when speaker diarization splits a mono channel into speakers
+	diarizer        *diarizer
+	maxAlternatives int32
+	speechContexts  []*speechpb.SpeechContext
+	// vad drops silence before it is sent and reports speech start/end
+	vad *audio.VAD
+	// sentBytes counts the audio sent over all streams of the channel; streamBaseMs is the
+	// duration sent before the current stream opened, since word times restart with every stream
+	sentBytes    atomic.Int64
+	streamBaseMs int64
+	// speechStart is when the customer started the current utterance, in Unix nanoseconds: the VAD
+	// speech_start event or, without one, the first interim result. interimObserved is set once the
+	// first interim result was measured.
+	speechStart     atomic.Int64
+	interimObserved atomic.Bool
+}
+
+// config builds the Google recognition config for a single mono channel
+func (c *recognitionChannel) config() *speechpb.RecognitionConfig {
+	config := &speechpb.RecognitionConfig{
+		Encoding:        speechpb.RecognitionConfig_LINEAR16,
+		SampleRateHertz: 16000,
+		LanguageCode:    c.language,
+		// 오디오 채널 수 (mono) - 스테레오 입력은 채널별로 분리하여 각각 인식
+		AudioChannelCount: 1,
+		// 다른 인코딩 포맷도 지원
+		// Encoding: speechpb.RecognitionConfig_WEBM_OPUS, // WEBM_OPUS 인코딩 사용 시
+		MaxAlternatives:       c.maxAlternatives,
+		EnableWordTimeOffsets: true,
+		EnableWordConfidence:  true,
+		SpeechContexts:        c.speechContexts,
+	}
+	if c.diarizer != nil {
+		config.DiarizationConfig = diarizationConfig()
+	}
+	return config
+}
+
+// sentMs returns the duration of the audio sent to the recognizer, without the silence dropped by VAD
+func (c *recognitionChannel) sentMs() int64 {
+	if c.vad != nil {
+		return c.vad.ForwardedMs()
+	}
+	return c.sentBytes.Load() / (16000 * 2 / 1000)
+}
+
+// alignWords moves word times of the current stream onto the timeline of the session's
+// audio and recording by adding the audio of earlier streams and the silence dropped by VAD
+func (c *recognitionChannel) alignWords(words []models.Word) {
+	for i := range words {
+		words[i].StartMs = c.streamOffsetMs(words[i].StartMs)
+		words[i].EndMs = c.streamOffsetMs(words[i].EndMs)
+	}
+}
+
+// streamOffsetMs maps a time of the current recognition stream to the session's timeline
+func (c *recognitionChannel) streamOffsetMs(ms int64) int64 {
+	ms += c.streamBaseMs
+	if c.vad != nil {
+		return c.vad.StreamOffsetMs(ms)
+	}
+	return ms
+}
+
+// markSpeechStart starts the latency measurement of an utterance at a VAD speech_start event.
+// An utterance whose final result is still pending keeps its start.
+func (c *recognitionChannel) markSpeechStart() {
+	c.speechStart.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// observeLatency records the recognition latency of the current utterance, measured from the start of
+// speech so that silence before it is not counted. Without VAD the first interim result marks the start,
+// so only the final latency is observed. A final result ends the utterance.
+func (c *recognitionChannel) observeLatency(final bool) {
+	start := c.speechStart.Load()
+	if start == 0 {
+		if !final {
+			c.speechStart.CompareAndSwap(0, time.Now().UnixNano())
+			c.interimObserved.Store(true)
+		}
+		return
+	}
+	elapsed := time.Since(time.Unix(0, start)).Seconds()
+	if !final {
+		if c.interimObserved.CompareAndSwap(false, true) {
+			recognitionLatency.WithLabelValues("first_interim").Observe(elapsed)
+		}
+		return
+	}
+	recognitionLatency.WithLabelValues("final").Observe(elapsed)
+	c.interimObserved.Store(false)
+	c.speechStart.Store(0)
+}
+
 // HandleSpeechToText handles WebSocket connections for streaming audio data
 func HandleSpeechToText(w http.ResponseWriter, r *http.Request) {
-	// 요청 로깅
-	log.Printf("[INFO] Speech-to-Text API 요청: %s %s, RemoteAddr: %s", r.Method, r.URL.Path, r.RemoteAddr)
-
-	// Set CORS headers for WebSocket
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	// Handle preflight OPTIONS request
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	// Extract username from the authenticated identity or the query parameter
+	username, ok := requestUsername(r, r.URL.Query().Get("Username"))
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
-
-	// Extract username from query parameter
-	username := r.URL.Query().Get("Username")
 	if username == "" {
-		log.Printf("[ERROR] Username query parameter is missing. RemoteAddr: %s", r.RemoteAddr)
+		speechLog.WarnContext(r.Context(), "username query parameter is missing")
 		http.Error(w, "Username query parameter is required", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[INFO] Speech-to-Text 연결 시작 - Username: %s", username)
+	// Draining sessions must not be replaced by new ones
+	if isShuttingDown() {
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Reconnects within the grace period reattach to their parked session
+	if token := r.URL.Query().Get("ResumeToken"); token != "" {
+		lastEventSeq, err := strconv.ParseInt(r.URL.Query().Get("LastEventSeq"), 10, 64)
+		if err != nil || lastEventSeq < 0 {
+			http.Error(w, "LastEventSeq must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		session, err := resumeSession(token, username)
+		if err != nil {
+			speechLog.WarnContext(r.Context(), "session resume failed", logging.Err(err), "username", username)
+			status := http.StatusNotFound
+			if errors.Is(err, errSessionBusy) {
+				// The session exists, so the client should retry instead of starting a new one
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		trace.SpanFromContext(session.ctx).AddEvent("session resumed",
+			trace.WithAttributes(attribute.Int64("speech.last_event_seq", lastEventSeq)))
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			speechLog.ErrorContext(r.Context(), "websocket upgrade failed", logging.Err(err))
+			session.park()
+			return
+		}
+		speechLog.InfoContext(r.Context(), "session resumed",
+			"username", username, "session_id", logging.SessionID(session.ctx), "last_event_seq", lastEventSeq)
+		session.serve(wsConn, lastEventSeq, true)
+		return
+	}
+
+	// Stereo input carries the customer on the left channel and the agent on the right
+	channels := 1
+	if value := r.URL.Query().Get("Channels"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || (parsed != 1 && parsed != 2) {
+			http.Error(w, "Channels must be 1 or 2", http.StatusBadRequest)
+			return
+		}
+		channels = parsed
+	}
+
+	customerLanguage := r.URL.Query().Get("CustomerLanguage")
+	if customerLanguage == "" {
+		customerLanguage = defaultCustomerLanguage
+	}
+	agentLanguage := r.URL.Query().Get("AgentLanguage")
+	if agentLanguage == "" {
+		agentLanguage = defaultAgentLanguage
+	}
+
+	// Speaker diarization separates customer and agent when both are on a single channel
+	diarization := r.URL.Query().Get("Diarization") == "true"
+	if diarization && channels != 1 {
+		http.Error(w, "Diarization is only supported for single-channel audio", http.StatusBadRequest)
+		return
+	}
+	firstSpeaker := r.URL.Query().Get("FirstSpeaker")
+	if firstSpeaker == "" {
+		// The agent usually greets first when answering the call
+		firstSpeaker = models.SpeakerAgent
+	}
+	if firstSpeaker != models.SpeakerAgent && firstSpeaker != models.SpeakerCustomer {
+		http.Error(w, "FirstSpeaker must be agent or customer", http.StatusBadRequest)
+		return
+	}
+
+	// Phrase hints for product, tariff and street names are chosen by the session's service
+	service := r.URL.Query().Get("Service")
+
+	// Number of recognition hypotheses included in final events
+	maxAlternatives := defaultMaxAlternatives
+	if value := r.URL.Query().Get("Alternatives"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxAlternativesLimit {
+			http.Error(w, "Alternatives must be between 1 and 30", http.StatusBadRequest)
+			return
+		}
+		maxAlternatives = parsed
+	}
+
+	// Local voice activity detection drops silence and reports utterance boundaries
+	vad := r.URL.Query().Get("VAD") == "true"
+	var vadThreshold float64
+	if value := r.URL.Query().Get("VADThreshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "VADThreshold must be a positive number", http.StatusBadRequest)
+			return
+		}
+		vadThreshold = parsed
+	}
+
+	// AutoSuggest generates suggestions when the customer stops speaking (speech_end)
+	// or for every finalized customer turn (final)
+	autoSuggest := r.URL.Query().Get("AutoSuggest")
+	if autoSuggest != "" && autoSuggest != autoSuggestSpeechEnd && autoSuggest != autoSuggestFinal {
+		http.Error(w, "AutoSuggest must be speech_end or final", http.StatusBadRequest)
+		return
+	}
+	if autoSuggest == autoSuggestSpeechEnd && !vad {
+		http.Error(w, "AutoSuggest=speech_end requires VAD=true", http.StatusBadRequest)
+		return
+	}
+
+	// Input audio in other formats is converted to 16 kHz LINEAR16 before recognition.
+	// Stereo input is downmixed unless Channels=2 keeps customer and agent separate.
+	input := audio.Format{Encoding: audio.EncodingPCM16, SampleRate: audio.TargetSampleRate, Channels: channels}
+	if value := r.URL.Query().Get("InputEncoding"); value != "" {
+		input.Encoding = value
+	}
+	if value := r.URL.Query().Get("InputSampleRate"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "InputSampleRate must be a number", http.StatusBadRequest)
+			return
+		}
+		input.SampleRate = parsed
+	}
+	if value := r.URL.Query().Get("InputChannels"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "InputChannels must be 1 or 2", http.StatusBadRequest)
+			return
+		}
+		input.Channels = parsed
+	}
+	var converter *audio.Converter
+	if !input.IsTarget(channels) {
+		var err error
+		converter, err = audio.NewConverter(input, channels)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unsupported input audio: %v", err), http.StatusBadRequest)
+			return
+		}
+		speechLog.InfoContext(r.Context(), "converting input audio", "username", username,
+			"encoding", input.Encoding, "sample_rate", input.SampleRate, "channels", input.Channels)
+	}
 
 	// Upgrade the HTTP connection to a WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("[ERROR] WebSocket 업그레이드 실패: %v, RemoteAddr: %s", err, r.RemoteAddr)
+		speechLog.ErrorContext(r.Context(), "websocket upgrade failed", logging.Err(err))
 		return
 	}
-	defer conn.Close()
 
-	// Create Google Cloud Speech client
-	ctx := context.Background()
+	// The session span continues the trace of the upgrade request and ends when the session finishes
+	sessionID := newID()
+	ctx, span := speechTracer.Start(withBudgetTeam(logging.WithSessionID(tracing.Detach(r.Context()), sessionID), requestTeam(r)), "speech.session",
+		trace.WithAttributes(
+			attribute.String("speech.session_id", sessionID),
+			attribute.String("speech.service", service),
+			attribute.Int("speech.channels", channels),
+			attribute.Bool("speech.diarization", diarization),
+			attribute.Bool("speech.vad", vad),
+		))
+
+	// Create Google Cloud Speech client; it lives as long as the session, across reconnects
+	ctx, cancel := context.WithCancel(ctx)
 	client, err := speech.NewClient(ctx)
 	if err != nil {
-		log.Printf("[ERROR] Google Speech 클라이언트 생성 실패: %v, Username: %s", err, username)
+		speechLog.ErrorContext(ctx, "creating speech client failed", logging.Err(err), "username", username)
+		newSafeConn(wsConn).closeWith(websocket.CloseInternalServerErr, "speech recognition unavailable")
+		wsConn.Close()
+		cancel()
+		tracing.End(span, err)
 		return
 	}
-	defer client.Close()
 
-	log.Printf("[INFO] Google Speech 클라이언트 생성 성공 - Username: %s", username)
+	// One recognition stream per audio channel, each with its own language
+	recognitions := []*recognitionChannel{
+		{channel: 0, speaker: models.SpeakerCustomer, language: customerLanguage},
+	}
+	if channels == 2 {
+		recognitions = append(recognitions, &recognitionChannel{
+			channel: 1, speaker: models.SpeakerAgent, language: agentLanguage,
+		})
+	}
+	if diarization {
+		recognitions[0].diarizer = newDiarizer(firstSpeaker)
+	}
+	contexts := speechContexts(service)
+	for _, recognition := range recognitions {
+		recognition.maxAlternatives = int32(maxAlternatives)
+		recognition.speechContexts = contexts
+		if vad {
+			recognition.vad = audio.NewVAD(16000)
+			if vadThreshold > 0 {
+				recognition.vad.Threshold = vadThreshold
+			}
+		}
+	}
+	if len(contexts) > 0 {
+		speechLog.DebugContext(ctx, "applying phrase hints", "service", service, "contexts", len(contexts))
+	}
 
-	// Create a speech recognition stream
-	stream, err := client.StreamingRecognize(ctx)
-	if err != nil {
-		log.Printf("Failed to create streaming recognition: %v", err)
+	session := &speechSession{
+		ctx:          ctx,
+		cancel:       cancel,
+		id:           sessionID,
+		token:        newID(),
+		startedAt:    time.Now(),
+		username:     username,
+		team:         requestTeam(r),
+		service:      service,
+		issue:        r.URL.Query().Get("Issue"),
+		client:       client,
+		recognitions: recognitions,
+		converter:    converter,
+		recorder:     newTurnRecorder(username, requestTeam(r), sessionID),
+		autoSuggest:  autoSuggest,
+		channels:     channels,
+	}
+	session.recorder.customerOnly = channels == 1 && !diarization
+	session.recorder.service, session.recorder.issue = session.service, session.issue
+	if err := registerSession(session); err != nil {
+		speechLog.WarnContext(ctx, "session rejected", logging.Err(err), "username", username)
+		newSafeConn(wsConn).closeWith(websocket.CloseGoingAway, closeReasonShutdown)
+		wsConn.Close()
+		client.Close()
+		cancel()
+		tracing.End(span, err)
 		return
 	}
+	speechLog.InfoContext(r.Context(), "speech session started", "session_id", logging.SessionID(ctx),
+		"username", username, "service", service, "channels", channels, "diarization", diarization, "vad", vad)
 
-	// Configure the recognition
-	if err := stream.Send(&speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
-			StreamingConfig: &speechpb.StreamingRecognitionConfig{
-				Config: &speechpb.RecognitionConfig{
-					Encoding:        speechpb.RecognitionConfig_LINEAR16,
-					SampleRateHertz: 16000,
-					LanguageCode:    "de-DE", // German language
-					// 오디오 채널 수 (mono)
-					AudioChannelCount: 1,
-					// 다른 인코딩 포맷도 지원
-					// Encoding: speechpb.RecognitionConfig_WEBM_OPUS, // WEBM_OPUS 인코딩 사용 시
-				},
-				InterimResults: true,
-			},
-		},
-	}); err != nil {
-		log.Printf("[ERROR] Speech 설정 전송 실패: %v", err)
-		return
+	// Recording only happens when it is configured and the customer consented
+	if r.URL.Query().Get("RecordingConsent") == "true" {
+		session.setRecordingConsent(true)
 	}
 
-	log.Printf("[INFO] Google Speech API 설정 완료 - 인코딩: LINEAR16, 샘플 레이트: 16000Hz, 언어: de-DE")
+	session.serve(wsConn, 0, false)
+}
 
-	// Channel to signal when WebSocket is closed
-	done := make(chan struct{})
+// serve runs the session on one WebSocket connection. When the connection drops,
+// the session is parked for the resume grace period instead of being torn down.
+func (s *speechSession) serve(wsConn *websocket.Conn, lastEventSeq int64, resumed bool) {
+	conn := newSafeConn(wsConn)
+	defer conn.Close()
 
-	// Channel for storing final transcription
-	transcriptionChan := make(chan string, 1)
+	// Every connection is a child span of the session, so reconnects show up in the trace
+	ctx, span := speechTracer.Start(s.ctx, "speech.connection", trace.WithAttributes(attribute.Bool("speech.resumed", resumed)))
+	defer span.End()
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer cancelConn()
+
+	// Every connection gets fresh recognition streams, so audio recognized before a drop is never sent twice.
+	// A resume therefore keeps the session's conversation, events and word offsets, but not the
+	// recognizer's state: an utterance cut by the drop ends with the old stream and restarts on the new one.
+	if err := s.openStreams(ctx); err != nil {
+		tracing.Fail(span, err)
+		speechLog.ErrorContext(s.ctx, "opening recognition streams failed", logging.Err(err))
+		conn.closeWith(websocket.CloseInternalServerErr, "speech recognition unavailable")
+		s.finish()
+		return
+	}
+	s.attach(conn, lastEventSeq, resumed)
+
+	// Receive recognition results for every channel until the stream ends
+	var receivers sync.WaitGroup
+	for _, recognition := range s.recognitions {
+		receivers.Add(1)
+		go func(recognition *recognitionChannel) {
+			defer receivers.Done()
+			s.receiveRecognitionResults(ctx, recognition)
+		}(recognition)
+	}
 
-	// Handle incoming audio data from WebSocket
-	go func() {
-		defer close(done)
+	// Ping the client and close the connection when it stalls or stays idle
+	go conn.keepAlive(connCtx)
 
-		var finalTranscription string
+	err := s.readAudio(connCtx, wsConn, conn)
+	// Nobody listens to speech synthesized for this connection anymore
+	cancelConn()
 
-		for {
-			// Read message from WebSocket
-			messageType, data, err := conn.ReadMessage()
-			log.Printf("[INFO] WebSocket 수신 메시지 타입: %d (1=텍스트, 2=바이너리), 데이터 길이: %d 바이트", messageType, len(data))
+	// Signal the end of audio and wait for the remaining final results
+	for _, recognition := range s.recognitions {
+		if err := recognition.stream.CloseSend(); err != nil {
+			speechLog.WarnContext(s.ctx, "closing recognition stream failed", "channel", recognition.channel, logging.Err(err))
+		}
+	}
+	receivers.Wait()
+	s.detach()
 
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("[ERROR] WebSocket 에러: %v", err)
-				} else {
-					log.Printf("[INFO] WebSocket 연결 종료: %v", err)
-				}
-				// When connection closes, send the final transcription
-				transcriptionChan <- finalTranscription
-				return
+	// Sessions are not kept for a reconnect while the server shuts down; park checks that
+	if conn.resumable(err) {
+		s.park()
+		return
+	}
+	s.finish()
+}
+
+// openStreams creates and configures one recognition stream per channel. Each stream gets a span
+// under the connection span of ctx; ctx is not canceled when the connection drops.
+func (s *speechSession) openStreams(ctx context.Context) error {
+	for i, recognition := range s.recognitions {
+		streamCtx, span := speechTracer.Start(ctx, "speech.recognition_stream", trace.WithAttributes(
+			attribute.Int("speech.channel", recognition.channel),
+			attribute.String("speech.speaker", recognition.speaker),
+			attribute.String("speech.language", recognition.language),
+		))
+		recognition.span = span
+
+		// Streams opened before a failure never get a receiver, so their spans end here
+		fail := func(err error) error {
+			for _, opened := range s.recognitions[:i+1] {
+				tracing.End(opened.span, err)
 			}
+			return err
+		}
+
+		// Create a speech recognition stream
+		stream, err := s.client.StreamingRecognize(streamCtx)
+		if err != nil {
+			return fail(fmt.Errorf("creating streaming recognition: %w", err))
+		}
+
+		// Configure the recognition
+		if err := stream.Send(&speechpb.StreamingRecognizeRequest{
+			StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
+				StreamingConfig: &speechpb.StreamingRecognitionConfig{
+					Config:         recognition.config(),
+					InterimResults: true,
+				},
+			},
+		}); err != nil {
+			return fail(fmt.Errorf("sending streaming config: %w", err))
+		}
+		recognition.stream = stream
+		recognition.streamBaseMs = recognition.sentMs()
+		if recognition.diarizer != nil {
+			recognition.diarizer.reset()
+		}
 
-			if messageType == websocket.CloseMessage {
-				log.Printf("[INFO] WebSocket 클라이언트에 의해 정상 종료됨")
-				transcriptionChan <- finalTranscription
-				return
+		speechLog.DebugContext(s.ctx, "recognition stream opened",
+			"channel", recognition.channel, "speaker", recognition.speaker, "language", recognition.language)
+	}
+	return nil
+}
+
+// readAudio forwards audio and control messages from the WebSocket until it closes and returns the read error
+func (s *speechSession) readAudio(ctx context.Context, wsConn *websocket.Conn, conn *safeConn) error {
+	for {
+		// Read message from WebSocket
+		messageType, data, err := wsConn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				speechLog.WarnContext(ctx, "websocket read failed", logging.Err(err))
+			} else {
+				speechLog.InfoContext(ctx, "websocket closed", logging.Err(err))
 			}
+			conn.closeAfterReadError(ctx, err)
+			return err
+		}
+		conn.touch()
 
-			if messageType == websocket.TextMessage {
-				log.Printf("[INFO] 텍스트 메시지 수신: %s", string(data))
-				// 텍스트 메시지 처리 (필요시)
-				continue
+		if messageType == websocket.TextMessage {
+			speechLog.DebugContext(ctx, "control message received", logging.Text("message", string(data)))
+			s.handleControlMessage(ctx, conn, data)
+			continue
+		}
+
+		// Only process binary messages (audio data)
+		if messageType == websocket.BinaryMessage {
+			audioReceivedBytes.Add(float64(len(data)))
+
+			if s.converter != nil {
+				data = s.converter.Convert(data)
+				if len(data) == 0 {
+					continue
+				}
 			}
 
-			// Only process binary messages (audio data)
-			if messageType == websocket.BinaryMessage {
-				log.Printf("[INFO] 오디오 바이너리 데이터 수신 - 크기: %d 바이트", len(data))
+			s.recordAudio(data)
+
+			chunks := [][]byte{data}
+			if s.channels == 2 {
+				left, right := s.deinterleaver.Split(data)
+				chunks = [][]byte{left, right}
+			}
 
-				// 첫 16바이트를 로깅 (디버깅용)
-				if len(data) > 16 {
-					log.Printf("[DEBUG] 오디오 데이터 시작 바이트: % x", data[:16])
+			// 클라이언트가 전송한 바이너리 데이터를 Google Speech API로 전송
+			for i, recognition := range s.recognitions {
+				chunk := chunks[i]
+				if recognition.vad != nil {
+					var events []audio.VADEvent
+					chunk, events = recognition.vad.Process(chunk)
+					for _, event := range events {
+						s.handleVADEvent(recognition, event)
+					}
+					if len(chunk) == 0 {
+						continue
+					}
 				}
-				// 클라이언트가 전송한 바이너리 데이터를 Google Speech API로 전송
-				if err := stream.Send(&speechpb.StreamingRecognizeRequest{
+
+				if err := recognition.stream.Send(&speechpb.StreamingRecognizeRequest{
 					StreamingRequest: &speechpb.StreamingRecognizeRequest_AudioContent{
-						AudioContent: data,
+						AudioContent: chunk,
 					},
 				}); err != nil {
-					log.Printf("[ERROR] 오디오 데이터 전송 실패: %v", err)
+					speechLog.ErrorContext(ctx, "sending audio failed", "channel", recognition.channel, logging.Err(err))
 					continue
 				}
+				recognition.sentBytes.Add(int64(len(chunk)))
+			}
 
-				log.Printf("[INFO] 오디오 데이터 청크 (%d 바이트) 전송 완료", len(data))
+			speechLog.DebugContext(ctx, "audio forwarded", "bytes", len(data))
+		}
+	}
+}
 
-				// Google Speech API로부터 변환 결과 수신
-				resp, err := stream.Recv()
-				if err != nil {
-					if err == io.EOF {
-						log.Printf("[INFO] 스트림 종료 (EOF)")
-						break
-					}
-					log.Printf("[ERROR] 응답 수신 실패: %v", err)
-					continue
+// receiveRecognitionResults forwards results of one recognition stream to the client and records final turns
+func (s *speechSession) receiveRecognitionResults(ctx context.Context, recognition *recognitionChannel) {
+	// Turns are stored within the stream's span
+	ctx = trace.ContextWithSpan(ctx, recognition.span)
+	for {
+		// Google Speech API로부터 변환 결과 수신
+		resp, err := recognition.stream.Recv()
+		if err == io.EOF {
+			speechLog.DebugContext(ctx, "recognition stream ended", "channel", recognition.channel)
+			recognition.span.End()
+			return
+		}
+		if err != nil {
+			speechLog.ErrorContext(ctx, "receiving recognition results failed", "channel", recognition.channel, logging.Err(err))
+			tracing.End(recognition.span, err)
+			return
+		}
+
+		// 변환 결과 처리
+		for _, result := range resp.Results {
+			if len(result.Alternatives) == 0 {
+				speechLog.WarnContext(ctx, "recognition result has no alternatives", "channel", recognition.channel)
+				continue
+			}
+
+			transcript := result.Alternatives[0].Transcript
+			confidence := result.Alternatives[0].Confidence
+			recognition.observeLatency(result.IsFinal)
+
+			speechLog.DebugContext(ctx, "recognition result", logging.Text("transcript", transcript),
+				"confidence", confidence, "final", result.IsFinal, "speaker", recognition.speaker)
+
+			// Diarized speakers are only known once the result is final
+			speaker := recognition.speaker
+			if recognition.diarizer != nil {
+				speaker = "unknown"
+			}
+
+			// 중간 결과를 클라이언트에 전송
+			response := map[string]interface{}{
+				"type":       "transcript",
+				"transcript": transcript,
+				"final":      result.IsFinal,
+				"confidence": confidence,
+				"speaker":    speaker,
+				"channel":    recognition.channel,
+			}
+
+			// Final events carry word timings and the top alternatives. A diarized result
+			// repeats the words of earlier results, so only its new words are sent.
+			var wordInfos []*speechpb.WordInfo
+			var words []models.Word
+			var alternatives []models.Alternative
+			if result.IsFinal {
+				wordInfos = result.Alternatives[0].Words
+				if recognition.diarizer != nil {
+					wordInfos = recognition.diarizer.newWords(result.Alternatives[0])
 				}
+				words = convertWords(wordInfos)
+				recognition.alignWords(words)
+				alternatives = convertAlternatives(result.Alternatives)
+				response["words"] = words
+				response["alternatives"] = alternatives
+			}
 
-				log.Printf("[INFO] Google Speech API 응답 수신 - 결과 수: %d", len(resp.Results))
-
-				// 변환 결과 처리
-				for _, result := range resp.Results {
-					if len(result.Alternatives) > 0 {
-						transcript := result.Alternatives[0].Transcript
-						confidence := result.Alternatives[0].Confidence
-
-						log.Printf("[INFO] 변환 결과: '%s', 확실성: %.2f, 최종여부: %t",
-							transcript, confidence, result.IsFinal)
-
-						// 중간 결과를 클라이언트에 전송
-						response := map[string]interface{}{
-							"transcript": transcript,
-							"final":      result.IsFinal,
-							"confidence": confidence,
-						}
-
-						if err := conn.WriteJSON(response); err != nil {
-							log.Printf("[ERROR] WebSocket 메시지 전송 실패: %v", err)
-						} else {
-							log.Printf("[INFO] 클라이언트에 결과 전송 완료")
-						}
-
-						// 최종 결과인 경우 저장
-						if result.IsFinal {
-							finalTranscription = transcript
-							log.Printf("[INFO] 최종 텍스트 업데이트: %s", finalTranscription)
-						}
-					} else {
-						log.Printf("[WARN] 변환 결과에 대안이 없음")
-					}
+			if err := s.send(response); err != nil {
+				speechLog.ErrorContext(ctx, "sending transcript failed", logging.Err(err))
+			}
+
+			if !result.IsFinal {
+				continue
+			}
+			recognition.span.AddEvent("final result", trace.WithAttributes(
+				attribute.Float64("speech.confidence", float64(confidence)),
+				attribute.Int("speech.chars", len(transcript)),
+			))
+
+			// 최종 결과인 경우 저장
+			turns := []models.Turn{{
+				Speaker:      recognition.speaker,
+				Text:         transcript,
+				Language:     recognition.language,
+				Channel:      recognition.channel,
+				Confidence:   confidence,
+				Words:        words,
+				Alternatives: alternatives,
+			}}
+			if recognition.diarizer != nil {
+				turns = recognition.diarizer.turns(wordInfos, confidence, recognition.language, recognition.channel)
+				for _, turn := range turns {
+					recognition.alignWords(turn.Words)
+				}
+				if err := s.send(map[string]interface{}{
+					"type":  "speaker_turns",
+					"turns": turns,
+				}); err != nil {
+					speechLog.ErrorContext(ctx, "sending speaker turns failed", logging.Err(err))
 				}
 			}
+
+			for _, turn := range turns {
+				s.commitTurn(ctx, turn)
+			}
 		}
-	}()
+	}
+}
+
+// turnRecorder groups the finalized turns of one speech session into Question/Answer pairs
+type turnRecorder struct {
+	username  string
+	team      string
+	sessionID string
+	// service and issue are stamped on new entries as their call context
+	service string
+	issue   string
+	// key selects the conversation store entry that receives the turns; the username for live sessions
+	key string
+	// customerOnly is set when only the customer is transcribed; every customer turn then
+	// starts a new entry, because no answer separates the questions
+	customerOnly bool
+	current      int
+	// analyzed maps conversation entries to the turn whose analysis they hold, so a slow
+	// analysis of an earlier turn does not replace a newer one
+	analyzed map[int]int
+	turns    int
+	mu       sync.Mutex
+}
+
+// newTurnRecorder creates a recorder that starts a new conversation entry on the first turn
+func newTurnRecorder(username, team, sessionID string) *turnRecorder {
+	return &turnRecorder{username: username, team: team, sessionID: sessionID, key: username, current: -1, analyzed: make(map[int]int)}
+}
+
+// record stores a finalized turn. Customer speech goes into Question and agent speech into Answer;
+// customer speech after an answer, or any customer turn when only the customer is transcribed,
+// starts a new conversation entry. It returns the entry and the number of the turn within the session.
+func (t *turnRecorder) record(ctx context.Context, turn models.Turn, analysis *models.TurnAnalysis) (entry, seq int) {
+	ctx, span := storeTracer.Start(ctx, "store.conversations.record",
+		trace.WithAttributes(attribute.String("speech.speaker", turn.Speaker)))
+	defer span.End()
 
-	// Wait for WebSocket to close
-	<-done
-	log.Printf("[INFO] WebSocket 연결 종료 - Username: %s", username)
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	// Get the final transcription
-	finalTranscription := <-transcriptionChan
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
 
-	if finalTranscription != "" {
-		log.Printf("[INFO] 최종 텍스트 변환 결과: %s, Username: %s", finalTranscription, username)
+	conversations := conversationStore[t.key]
+	startNew := t.current < 0 ||
+		(turn.Speaker == models.SpeakerCustomer && (t.customerOnly || conversations[t.current].Answer != ""))
+
+	if startNew {
+		conversations = append(conversations, models.Conversation{SessionID: t.sessionID, Team: t.team, Service: t.service, Issue: t.issue})
+		t.current = len(conversations) - 1
+	}
+
+	conversation := &conversations[t.current]
+	if turn.Speaker == models.SpeakerCustomer {
+		conversation.Question = joinTranscript(conversation.Question, turn.Text)
+		if analysis != nil {
+			conversation.Analysis = analysis
+		}
 	} else {
-		log.Printf("[WARN] 텍스트 변환 결과 없음 - Username: %s", username)
+		conversation.Answer = joinTranscript(conversation.Answer, turn.Text)
 	}
+	conversation.Turns = append(conversation.Turns, turn)
+	conversationStore[t.key] = conversations
+	t.turns++
 
-	// Store conversation
-	if finalTranscription != "" {
-		storeMutex.Lock()
-		defer storeMutex.Unlock()
+	speechLog.DebugContext(ctx, "turn stored", "username", t.username, "speaker", turn.Speaker, "conversations", len(conversations))
+	return t.current, t.turns
+}
 
-		// Create a new conversation entry
-		newConversation := models.Conversation{
-			Question: finalTranscription,
-			Answer:   "",
-		}
+// storeAnalysis attaches the analysis of a customer turn to its conversation entry,
+// unless the entry already holds the analysis of a later turn
+func (t *turnRecorder) storeAnalysis(ctx context.Context, entry, seq int, analysis models.TurnAnalysis) {
+	_, span := storeTracer.Start(ctx, "store.conversations.analysis")
+	defer span.End()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.analyzed[entry] > seq {
+		return
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	conversations := conversationStore[t.key]
+	if entry < 0 || entry >= len(conversations) || conversations[entry].SessionID != t.sessionID {
+		speechLog.WarnContext(ctx, "conversation entry for analysis not found", "username", t.username, "entry", entry)
+		return
+	}
+	conversations[entry].Analysis = &analysis
+	t.analyzed[entry] = seq
+}
 
-		// Check if user exists in the store
-		if conversations, exists := conversationStore[username]; exists {
-			// Add new conversation to existing list
-			conversationStore[username] = append(conversations, newConversation)
-			log.Printf("[INFO] 기존 대화 목록에 질문 추가 - Username: %s, 총 대화 수: %d",
-				username, len(conversationStore[username]))
-		} else {
-			// Create new conversation list for user
-			conversationStore[username] = []models.Conversation{newConversation}
-			log.Printf("[INFO] 새 대화 목록 생성 - Username: %s", username)
+// currentEntry returns the index of the conversation entry that receives the next turns, or -1
+func (t *turnRecorder) currentEntry() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current
+}
+
+// storeSuggestions stores generated suggestions with the conversation entry they answer
+func (t *turnRecorder) storeSuggestions(ctx context.Context, entry int, suggestions []byte) {
+	_, span := storeTracer.Start(ctx, "store.conversations.suggestions")
+	defer span.End()
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	conversations := conversationStore[t.key]
+	if entry < 0 || entry >= len(conversations) || conversations[entry].SessionID != t.sessionID {
+		speechLog.WarnContext(ctx, "conversation entry for suggestions not found", "username", t.username, "entry", entry)
+		return
+	}
+	conversations[entry].Suggestions = json.RawMessage(suggestions)
+}
+
+// turnCount returns how many turns were recorded in this session
+func (t *turnRecorder) turnCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.turns
+}
+
+// joinTranscript appends a transcript segment to existing text
+func joinTranscript(existing, text string) string {
+	return strings.TrimSpace(existing + " " + strings.TrimSpace(text))
+}
+
+// handleControlMessage handles JSON control messages sent as text frames on the speech WebSocket
+func (s *speechSession) handleControlMessage(ctx context.Context, conn *safeConn, data []byte) {
+	var message struct {
+		Type    string `json:"type"`
+		Text    string `json:"text"`
+		Format  string `json:"format"`
+		Granted bool   `json:"granted"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		speechLog.WarnContext(ctx, "parsing control message failed", logging.Err(err))
+		return
+	}
+
+	switch message.Type {
+	case "tts":
+		speechLog.InfoContext(ctx, "speech synthesis requested", "chars", len(message.Text))
+		if err := checkTTSTextLength(message.Text); err != nil {
+			conn.WriteJSON(map[string]interface{}{"type": "tts_error", "error": err.Error()})
+			return
 		}
+		// One synthesis at a time keeps the audio frames of two requests from interleaving
+		if !s.ttsBusy.CompareAndSwap(false, true) {
+			speechLog.WarnContext(ctx, "speech synthesis rejected while another one is running")
+			conn.WriteJSON(map[string]interface{}{"type": "tts_error", "error": "speech synthesis already in progress"})
+			return
+		}
+		// Synthesis takes seconds, so it runs next to the read loop and audio keeps flowing.
+		// Synthesized audio is only streamed to the requesting connection and is not replayed;
+		// ctx is canceled when that connection stops reading.
+		s.background.Add(1)
+		go func() {
+			defer s.background.Done()
+			defer s.ttsBusy.Store(false)
+			if err := streamSpeechOverWebSocket(ctx, conn, message.Text, message.Format); err != nil {
+				if ctx.Err() != nil {
+					speechLog.InfoContext(ctx, "speech synthesis canceled", logging.Err(err))
+					return
+				}
+				speechLog.ErrorContext(ctx, "speech synthesis failed", logging.Err(err))
+				conn.WriteJSON(map[string]interface{}{
+					"type":  "tts_error",
+					"error": err.Error(),
+				})
+			}
+		}()
+	case "recording_consent":
+		s.setRecordingConsent(message.Granted)
+	default:
+		speechLog.WarnContext(ctx, "unknown control message type", "type", message.Type)
 	}
 }
 
 // GetConversations returns the conversations for a given user
-func GetConversations(username string) ([]models.Conversation, bool) {
+func GetConversations(ctx context.Context, username string) ([]models.Conversation, bool) {
+	_, span := storeTracer.Start(ctx, "store.conversations.get")
+	defer span.End()
+
 	storeMutex.RLock()
 	defer storeMutex.RUnlock()
 	conversations, exists := conversationStore[username]
-	return conversations, exists
+	// Return a copy because speech sessions keep updating the stored entries in place
+	return append([]models.Conversation(nil), conversations...), exists
+}
+
+// sessionConversations returns the entries of one speech session or transcription job
+func sessionConversations(conversations []models.Conversation, sessionID string) []models.Conversation {
+	var filtered []models.Conversation
+	for _, conversation := range conversations {
+		if conversation.SessionID == sessionID {
+			filtered = append(filtered, conversation)
+		}
+	}
+	return filtered
+}
+
+// latestSessionID returns the session of the most recent entry
+func latestSessionID(conversations []models.Conversation) string {
+	if len(conversations) == 0 {
+		return ""
+	}
+	return conversations[len(conversations)-1].SessionID
 }
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"testing"
+)
+
+func TestTurnRecorderPairsTurns(t *testing.T) {
+	customer := func(text string) models.Turn { return models.Turn{Speaker: models.SpeakerCustomer, Text: text} }
+	agent := func(text string) models.Turn { return models.Turn{Speaker: models.SpeakerAgent, Text: text} }
+
+	tests := []struct {
+		name         string
+		customerOnly bool
+		turns        []models.Turn
+		want         []models.Conversation
+	}{
+		{
+			name:  "dual channel",
+			turns: []models.Turn{customer("Mein Internet geht nicht."), customer("Seit gestern."), agent("Ich prüfe das."), customer("Danke.")},
+			want: []models.Conversation{
+				{Question: "Mein Internet geht nicht. Seit gestern.", Answer: "Ich prüfe das."},
+				{Question: "Danke."},
+			},
+		},
+		{
+			name:         "customer only",
+			customerOnly: true,
+			turns:        []models.Turn{customer("Mein Internet geht nicht."), customer("Seit gestern."), customer("Können Sie helfen?")},
+			want: []models.Conversation{
+				{Question: "Mein Internet geht nicht."},
+				{Question: "Seit gestern."},
+				{Question: "Können Sie helfen?"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := "recorder-" + tt.name
+			t.Cleanup(func() {
+				storeMutex.Lock()
+				delete(conversationStore, username)
+				storeMutex.Unlock()
+			})
+			recorder := newTurnRecorder(username, "", "call-1")
+			recorder.customerOnly = tt.customerOnly
+			for _, turn := range tt.turns {
+				recorder.record(context.Background(), turn, nil)
+			}
+
+			got, _ := GetConversations(context.Background(), username)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d conversation entries, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].Question != want.Question || got[i].Answer != want.Answer || got[i].SessionID != "call-1" {
+					t.Errorf("entry %d: got %q / %q in session %q, want %q / %q", i, got[i].Question, got[i].Answer, got[i].SessionID, want.Question, want.Answer)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// In-memory data store for end-of-call summaries, keyed by session ID
+	summaryStore      = make(map[string]models.CallSummary)
+	summaryStoreMutex sync.RWMutex
+)
+
+// languageNames maps agent language codes to the names used in prompts
+var languageNames = map[string]string{
+	"ko": "Korean",
+	"en": "English",
+	"de": "German",
+	"ja": "Japanese",
+	"zh": "Chinese",
+}
+
+// HandleGenerateSummary handles requests to summarize a finished call
+func HandleGenerateSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse request body
+	var requestBody struct {
+		Username string `json:"username"`
+		// SessionID selects the call to summarize; it defaults to the user's latest session
+		SessionID string `json:"session_id"`
+		Language  string `json:"language"`
+		Context   struct {
+			Service string `json:"service"`
+			Issue   string `json:"issue"`
+		} `json:"context"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		assistLog.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := requestUsername(r, requestBody.Username)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	requestBody.Username = username
+	if requestBody.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	if requestBody.Language == "" {
+		requestBody.Language = defaultSummaryLanguage
+	}
+
+	// A batch transcription job is summarized like a session of its owner. The call context
+	// recorded with the job or session wins over the one the client sent.
+	team := requestTeam(r)
+	var service, issue string
+	job, conversations, isJob := transcriptionConversations(r, requestBody.SessionID)
+	if isJob {
+		requestBody.Username = job.Username
+		team = job.Team
+		service, issue = job.Service, job.Issue
+	} else {
+		conversations, _ = GetConversations(r.Context(), requestBody.Username)
+		if requestBody.SessionID == "" {
+			requestBody.SessionID = latestSessionID(conversations)
+		}
+		conversations = sessionConversations(conversations, requestBody.SessionID)
+		service, issue = conversationsContext(conversations)
+	}
+	if service == "" {
+		service = requestBody.Context.Service
+	}
+	if issue == "" {
+		issue = requestBody.Context.Issue
+	}
+	if len(conversations) == 0 {
+		assistLog.WarnContext(r.Context(), "no conversations to summarize", "username", requestBody.Username, "session_id", requestBody.SessionID)
+		http.Error(w, "No conversations found for this session", http.StatusNotFound)
+		return
+	}
+
+	assistLog.InfoContext(r.Context(), "summarizing call", "username", requestBody.Username,
+		"session_id", requestBody.SessionID, "conversations", len(conversations), "language", requestBody.Language)
+
+	_, promptSpan := assistTracer.Start(r.Context(), "assist.build_prompt",
+		trace.WithAttributes(attribute.String("assist.prompt", "summary")))
+	prompt := constructSummaryPrompt(
+		service,
+		issue,
+		languageName(requestBody.Language),
+		conversations,
+	)
+	promptSpan.End()
+
+	content, err := callOpenAIChat(r.Context(), defaultSystemPrompt, prompt)
+	if err != nil {
+		assistLog.ErrorContext(r.Context(), "summarizing call failed", logging.Err(err), "username", requestBody.Username)
+		http.Error(w, fmt.Sprintf("Error calling OpenAI API: %v", err), openAIErrorStatus(err))
+		return
+	}
+
+	var parsed struct {
+		German     models.SummaryContent `json:"german"`
+		Translated models.SummaryContent `json:"translated"`
+	}
+	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+		assistLog.ErrorContext(r.Context(), "parsing summary failed", logging.Err(err), logging.Text("content", content))
+		http.Error(w, "Summary could not be parsed", http.StatusBadGateway)
+		return
+	}
+
+	summary := models.CallSummary{
+		SessionID:      requestBody.SessionID,
+		Username:       requestBody.Username,
+		Team:           team,
+		Service:        service,
+		Issue:          issue,
+		Language:       requestBody.Language,
+		German:         parsed.German,
+		Translated:     parsed.Translated,
+		SentimentTrend: computeSentimentTrend(conversations),
+		TurnCount:      len(conversations),
+		CreatedAt:      time.Now(),
+	}
+
+	saveSummary(r.Context(), summary)
+
+	assistLog.InfoContext(r.Context(), "summary stored", "username", requestBody.Username,
+		"session_id", summary.SessionID, "sentiment_trend", summary.SentimentTrend.Direction)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// HandleGetSummary returns the summary of a session, or the latest summary of a user,
+// optionally formatted as a ticket note
+func HandleGetSummary(w http.ResponseWriter, r *http.Request) {
+	var summary models.CallSummary
+	var exists bool
+	if sessionID := r.URL.Query().Get("SessionID"); sessionID != "" {
+		summary, exists = GetSummary(r.Context(), sessionID)
+	} else {
+		username, ok := viewUsername(r, r.URL.Query().Get("Username"))
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if username == "" {
+			http.Error(w, "SessionID or Username query parameter is required", http.StatusBadRequest)
+			return
+		}
+		summary, exists = latestSummary(r.Context(), username)
+	}
+
+	if exists && !canAccessUser(r, summary.Username, summary.Team) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !exists {
+		summaryLookups.WithLabelValues("not_found").Inc()
+		http.Error(w, "No summary found", http.StatusNotFound)
+		return
+	}
+	summaryLookups.WithLabelValues("found").Inc()
+
+	if r.URL.Query().Get("format") == "ticket" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "ticket-"+summary.SessionID+".txt"))
+		fmt.Fprint(w, FormatTicketNote(summary))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// saveSummary stores the summary of a session, replacing an earlier one of the same session
+func saveSummary(ctx context.Context, summary models.CallSummary) {
+	_, span := storeTracer.Start(ctx, "store.summaries.save")
+	defer span.End()
+
+	summaryStoreMutex.Lock()
+	defer summaryStoreMutex.Unlock()
+	summaryStore[summary.SessionID] = summary
+}
+
+// GetSummary returns the stored call summary of a session
+func GetSummary(ctx context.Context, sessionID string) (models.CallSummary, bool) {
+	_, span := storeTracer.Start(ctx, "store.summaries.get")
+	defer span.End()
+
+	summaryStoreMutex.RLock()
+	defer summaryStoreMutex.RUnlock()
+	summary, exists := summaryStore[sessionID]
+	return summary, exists
+}
+
+// latestSummary returns the most recent call summary of a user
+func latestSummary(ctx context.Context, username string) (models.CallSummary, bool) {
+	_, span := storeTracer.Start(ctx, "store.summaries.latest")
+	defer span.End()
+
+	summaryStoreMutex.RLock()
+	defer summaryStoreMutex.RUnlock()
+	var latest models.CallSummary
+	var exists bool
+	for _, summary := range summaryStore {
+		if summary.Username == username && (!exists || summary.CreatedAt.After(latest.CreatedAt)) {
+			latest, exists = summary, true
+		}
+	}
+	return latest, exists
+}
+
+// languageName returns the prompt name for a language code
+func languageName(code string) string {
+	if name, ok := languageNames[strings.ToLower(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// formatConversationHistory renders the stored turns as a transcript for prompts
+func formatConversationHistory(conversations []models.Conversation) string {
+	var b strings.Builder
+	for i, conversation := range conversations {
+		fmt.Fprintf(&b, "Turn %d\nCustomer: %s\n", i+1, conversation.Question)
+		if conversation.Answer != "" {
+			fmt.Fprintf(&b, "Agent: %s\n", conversation.Answer)
+		}
+	}
+	return b.String()
+}
+
+// conversationsContext returns the service and issue recorded on the latest conversation entry that has them
+func conversationsContext(conversations []models.Conversation) (service, issue string) {
+	for i := len(conversations) - 1; i >= 0; i-- {
+		if conversations[i].Service != "" || conversations[i].Issue != "" {
+			return conversations[i].Service, conversations[i].Issue
+		}
+	}
+	return "", ""
+}
+
+// constructSummaryPrompt creates a prompt asking for a structured call summary
+func constructSummaryPrompt(service, issue, language string, conversations []models.Conversation) string {
+	return renderPrompt(PromptSummary, promptData{
+		Service:  service,
+		Issue:    issue,
+		History:  formatConversationHistory(conversations),
+		Language: language,
+	})
+}
+
+// computeSentimentTrend derives the mood trend from the per-turn analyses
+func computeSentimentTrend(conversations []models.Conversation) models.SentimentTrend {
+	trend := models.SentimentTrend{
+		Direction:  "unknown",
+		Sentiments: []string{},
+		Scores:     []float64{},
+	}
+	for _, conversation := range conversations {
+		if conversation.Analysis == nil {
+			continue
+		}
+		trend.Sentiments = append(trend.Sentiments, conversation.Analysis.Sentiment)
+		trend.Scores = append(trend.Scores, conversation.Analysis.Score)
+	}
+
+	if len(trend.Scores) == 0 {
+		return trend
+	}
+
+	// Compare the first and last analyzed turns
+	delta := trend.Scores[len(trend.Scores)-1] - trend.Scores[0]
+	switch {
+	case delta >= 0.2:
+		trend.Direction = "improving"
+	case delta <= -0.2:
+		trend.Direction = "worsening"
+	default:
+		trend.Direction = "stable"
+	}
+	return trend
+}
+
+// FormatTicketNote renders a call summary as a plain-text ticket note
+func FormatTicketNote(summary models.CallSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Call summary - %s\n", summary.CreatedAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Agent: %s\n", summary.Username)
+	if summary.Service != "" || summary.Issue != "" {
+		fmt.Fprintf(&b, "Service: %s / Issue: %s\n", summary.Service, summary.Issue)
+	}
+	fmt.Fprintf(&b, "Turns: %d, Sentiment trend: %s\n", summary.TurnCount, summary.SentimentTrend.Direction)
+
+	writeSummarySection(&b, "DE", summary.German)
+	writeSummarySection(&b, strings.ToUpper(summary.Language), summary.Translated)
+	return b.String()
+}
+
+// writeSummarySection writes one language block of a ticket note
+func writeSummarySection(b *strings.Builder, label string, content models.SummaryContent) {
+	fmt.Fprintf(b, "\n[%s]\n", label)
+	fmt.Fprintf(b, "Problem: %s\n", content.Problem)
+	if len(content.Steps) > 0 {
+		b.WriteString("Steps:\n")
+		for _, step := range content.Steps {
+			fmt.Fprintf(b, "  - %s\n", step)
+		}
+	}
+	fmt.Fprintf(b, "Resolution: %s\n", content.Resolution)
+	if len(content.FollowUpActions) > 0 {
+		b.WriteString("Follow-up:\n")
+		for _, action := range content.FollowUpActions {
+			fmt.Fprintf(b, "  - %s\n", action)
+		}
+	}
+	if content.SentimentSummary != "" {
+		fmt.Fprintf(b, "Sentiment: %s\n", content.SentimentSummary)
+	}
+}
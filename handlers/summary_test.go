@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetSummaryBySession(t *testing.T) {
+	t.Cleanup(func() { summaryStore = make(map[string]models.CallSummary) })
+	now := time.Now()
+	saveSummary(context.Background(), models.CallSummary{SessionID: "call-1", Username: "anna", Issue: "router", CreatedAt: now.Add(-time.Hour)})
+	saveSummary(context.Background(), models.CallSummary{SessionID: "call-2", Username: "anna", Issue: "invoice", CreatedAt: now})
+	saveSummary(context.Background(), models.CallSummary{SessionID: "call-3", Username: "carl", Issue: "contract", CreatedAt: now})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantIssue string
+	}{
+		{"earlier session", "SessionID=call-1", http.StatusOK, "router"},
+		{"latest session of user", "Username=anna", http.StatusOK, "invoice"},
+		{"unknown session", "SessionID=call-9", http.StatusNotFound, ""},
+		{"no parameters", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HandleGetSummary(rec, httptest.NewRequest(http.MethodGet, "/api/summary?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantIssue == "" {
+				return
+			}
+			var summary models.CallSummary
+			if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+				t.Fatal(err)
+			}
+			if summary.Issue != tt.wantIssue {
+				t.Errorf("got the summary about %q, want %q", summary.Issue, tt.wantIssue)
+			}
+		})
+	}
+
+	// Agents cannot read other agents' sessions by ID
+	req := httptest.NewRequest(http.MethodGet, "/api/summary?SessionID=call-3", nil)
+	req = req.WithContext(context.WithValue(req.Context(), identityKey{}, models.Identity{Username: "anna", Role: models.RoleAgent}))
+	rec := httptest.NewRecorder()
+	HandleGetSummary(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("reading another agent's session: got status %d, want 403", rec.Code)
+	}
+}
+
+func TestSessionConversations(t *testing.T) {
+	conversations := []models.Conversation{
+		{SessionID: "call-1", Question: "Mein Router geht nicht"},
+		{SessionID: "call-2", Question: "Die Rechnung ist falsch"},
+		{SessionID: "call-2", Question: "Ich habe zweimal bezahlt"},
+	}
+	if got := latestSessionID(conversations); got != "call-2" {
+		t.Errorf("latestSessionID() = %q, want call-2", got)
+	}
+	if got := sessionConversations(conversations, "call-2"); len(got) != 2 || got[0].Question != "Die Rechnung ist falsch" {
+		t.Errorf("sessionConversations(call-2) = %v", got)
+	}
+	if got := sessionConversations(conversations, "call-9"); len(got) != 0 {
+		t.Errorf("sessionConversations(call-9) = %v, want none", got)
+	}
+}
+
+func TestGenerateSummaryUsesRecordedContext(t *testing.T) {
+	stub := withOpenAIStub(t, `{"german": {"problem": "Kein Internet"}, "translated": {"problem": "인터넷 없음"}}`, 10)
+	t.Cleanup(func() {
+		summaryStore = make(map[string]models.CallSummary)
+		storeMutex.Lock()
+		delete(conversationStore, "anna")
+		storeMutex.Unlock()
+	})
+
+	recorded := newTurnRecorder("anna", "", "call-1")
+	recorded.service, recorded.issue = "internet", "outage"
+	recorded.record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Mein Internet geht nicht."}, nil)
+	unrecorded := newTurnRecorder("anna", "", "call-2")
+	unrecorded.record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Meine Rechnung ist falsch."}, nil)
+	addTranscriptionJob(t, "job-1", "anna", models.JobCompleted, time.Now())
+	transcriptionJobsMutex.Lock()
+	transcriptionJobs["job-1"].Service, transcriptionJobs["job-1"].Issue = "mobile", "roaming"
+	transcriptionJobsMutex.Unlock()
+
+	tests := []struct {
+		name        string
+		sessionID   string
+		wantService string
+		wantIssue   string
+	}{
+		{"session context wins over the body", "call-1", "internet", "outage"},
+		{"body without recorded context", "call-2", "tv", "billing"},
+		{"job context wins over the body", "job-1", "mobile", "roaming"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"username": "anna", "session_id": "` + tt.sessionID + `", "context": {"service": "tv", "issue": "billing"}}`
+			rec := httptest.NewRecorder()
+			HandleGenerateSummary(rec, httptest.NewRequest(http.MethodPost, "/api/summary", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+
+			var summary models.CallSummary
+			if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+				t.Fatal(err)
+			}
+			if summary.Service != tt.wantService || summary.Issue != tt.wantIssue {
+				t.Errorf("got service %q and issue %q, want %q and %q", summary.Service, summary.Issue, tt.wantService, tt.wantIssue)
+			}
+			prompts := stub.received()
+			want := tt.wantService + " service regarding " + tt.wantIssue + " issue"
+			if prompt := prompts[len(prompts)-1]; !strings.Contains(prompt, want) {
+				t.Errorf("prompt does not contain %q:\n%s", want, prompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"awesomeProject2/audio"
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"bytes"
+	speech "cloud.google.com/go/speech/apiv1"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits for batch transcription; inline audio content is limited by the recognizer
+const (
+	maxTranscriptionUpload    = 10 << 20
+	transcriptionTimeout      = time.Hour
+	transcriptionPollInterval = 2 * time.Second
+	// transcriptionJobTTL is how long finished jobs and their conversations are kept
+	transcriptionJobTTL = 24 * time.Hour
+)
+
+// transcriptionLog logs batch transcription jobs
+var transcriptionLog = logging.For("transcription")
+
+var (
+	// In-memory store for batch transcription jobs
+	transcriptionJobs      = make(map[string]*models.TranscriptionJob)
+	transcriptionJobsMutex sync.RWMutex
+)
+
+// errUnsupportedAudio is returned for uploads that are not WAV, FLAC or Ogg/Opus
+var errUnsupportedAudio = errors.New("unsupported audio file; expected WAV (16-bit PCM, float or G.711), FLAC or Ogg/Opus")
+
+// HandleCreateTranscription accepts an audio upload or an archived recording reference and starts a batch job
+func HandleCreateTranscription(w http.ResponseWriter, r *http.Request) {
+	var username, language, service, issue, source, filename string
+	var data []byte
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxTranscriptionUpload+1<<20)
+		if err := r.ParseMultipartForm(maxTranscriptionUpload); err != nil {
+			transcriptionLog.WarnContext(r.Context(), "parsing upload failed", logging.Err(err))
+			http.Error(w, "Invalid or too large upload", http.StatusBadRequest)
+			return
+		}
+		username = r.FormValue("username")
+		language = r.FormValue("language")
+		service = r.FormValue("service")
+		issue = r.FormValue("issue")
+
+		file, header, err := r.FormFile("audio")
+		if err != nil {
+			http.Error(w, "audio file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		data, err = io.ReadAll(io.LimitReader(file, maxTranscriptionUpload+1))
+		if err != nil {
+			http.Error(w, "Could not read upload", http.StatusBadRequest)
+			return
+		}
+		source = "upload"
+		filename = header.Filename
+	} else {
+		var requestBody struct {
+			Username    string `json:"username"`
+			Language    string `json:"language"`
+			RecordingID string `json:"recording_id"`
+			Service     string `json:"service"`
+			Issue       string `json:"issue"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			transcriptionLog.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		username = requestBody.Username
+		language = requestBody.Language
+		service = requestBody.Service
+		issue = requestBody.Issue
+
+		recording, exists := GetRecording(requestBody.RecordingID)
+		if !exists || recording.EndedAt.IsZero() || !canAccessUser(r, recording.Username, recording.Team) {
+			http.Error(w, "Recording not found", http.StatusNotFound)
+			return
+		}
+		var err error
+		data, err = os.ReadFile(recording.Path)
+		if err != nil {
+			transcriptionLog.ErrorContext(r.Context(), "reading recording failed", logging.Err(err), "recording_id", recording.ID)
+			http.Error(w, "Recording could not be read", http.StatusInternalServerError)
+			return
+		}
+		source = "recording"
+		filename = recording.ID
+	}
+
+	username, ok := requestUsername(r, username)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	if language == "" {
+		language = defaultCustomerLanguage
+	}
+	if len(data) > maxTranscriptionUpload {
+		http.Error(w, "Audio file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	config, content, err := batchRecognitionConfig(data, language)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedAudio) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	now := time.Now()
+	job := &models.TranscriptionJob{
+		ID:        newID(),
+		Username:  username,
+		Team:      requestTeam(r),
+		Source:    source,
+		Filename:  filename,
+		Language:  language,
+		Service:   service,
+		Issue:     issue,
+		Status:    models.JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	transcriptionJobsMutex.Lock()
+	transcriptionJobs[job.ID] = job
+	transcriptionJobsMutex.Unlock()
+
+	transcriptionLog.InfoContext(r.Context(), "transcription job created",
+		"job_id", job.ID, "username", username, "source", source, "bytes", len(data))
+
+	// The job outlives the request but keeps its request ID for logging
+	go runTranscriptionJob(context.WithoutCancel(r.Context()), job.ID, username, job.Team, config, content)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// HandleGetTranscription returns the status and result of a batch job
+func HandleGetTranscription(w http.ResponseWriter, r *http.Request) {
+	job, exists := GetTranscriptionJob(mux.Vars(r)["id"])
+	if !exists || !canAccessUser(r, job.Username, job.Team) {
+		http.Error(w, "Transcription job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// transcriptionStoreKey is the conversation store entry holding the turns of a batch job.
+// Jobs get their own entry so re-processed calls never mix with a user's live conversation;
+// the prefix can never be a username.
+func transcriptionStoreKey(id string) string {
+	return "\x00transcription/" + id
+}
+
+// transcriptionConversations returns a batch job and its conversation entries when the
+// caller may access them
+func transcriptionConversations(r *http.Request, id string) (models.TranscriptionJob, []models.Conversation, bool) {
+	if id == "" {
+		return models.TranscriptionJob{}, nil, false
+	}
+	job, exists := GetTranscriptionJob(id)
+	if !exists || !canAccessUser(r, job.Username, job.Team) {
+		return models.TranscriptionJob{}, nil, false
+	}
+	conversations, _ := GetConversations(r.Context(), transcriptionStoreKey(id))
+	return job, conversations, true
+}
+
+// StartTranscriptionJobExpiry periodically removes finished jobs older than transcriptionJobTTL
+func StartTranscriptionJobExpiry(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			expireTranscriptionJobs(time.Now())
+		}
+	}()
+}
+
+// expireTranscriptionJobs removes jobs that finished before now minus transcriptionJobTTL,
+// together with their conversation entries. Queued and running jobs are kept.
+func expireTranscriptionJobs(now time.Time) {
+	cutoff := now.Add(-transcriptionJobTTL)
+
+	var expired []string
+	transcriptionJobsMutex.Lock()
+	for id, job := range transcriptionJobs {
+		finished := job.Status == models.JobCompleted || job.Status == models.JobFailed
+		if finished && job.UpdatedAt.Before(cutoff) {
+			delete(transcriptionJobs, id)
+			expired = append(expired, id)
+		}
+	}
+	transcriptionJobsMutex.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+
+	storeMutex.Lock()
+	for _, id := range expired {
+		delete(conversationStore, transcriptionStoreKey(id))
+	}
+	storeMutex.Unlock()
+	transcriptionLog.Info("expired transcription jobs removed", "jobs", len(expired))
+}
+
+// GetTranscriptionJob returns a copy of a batch job
+func GetTranscriptionJob(id string) (models.TranscriptionJob, bool) {
+	transcriptionJobsMutex.RLock()
+	defer transcriptionJobsMutex.RUnlock()
+	job, exists := transcriptionJobs[id]
+	if !exists {
+		return models.TranscriptionJob{}, false
+	}
+	return *job, true
+}
+
+// updateTranscriptionJob applies a change to a stored job
+func updateTranscriptionJob(id string, update func(job *models.TranscriptionJob)) {
+	transcriptionJobsMutex.Lock()
+	defer transcriptionJobsMutex.Unlock()
+	if job, exists := transcriptionJobs[id]; exists {
+		update(job)
+		job.UpdatedAt = time.Now()
+	}
+}
+
+// failTranscriptionJob marks a job as failed
+func failTranscriptionJob(ctx context.Context, id string, err error) {
+	transcriptionLog.ErrorContext(ctx, "transcription job failed", "job_id", id, logging.Err(err))
+	updateTranscriptionJob(id, func(job *models.TranscriptionJob) {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+	})
+}
+
+// batchRecognitionConfig detects the container format and builds a non-streaming recognition config
+func batchRecognitionConfig(data []byte, language string) (*speechpb.RecognitionConfig, []byte, error) {
+	config := &speechpb.RecognitionConfig{
+		LanguageCode:          language,
+		EnableWordTimeOffsets: true,
+		EnableWordConfidence:  true,
+		MaxAlternatives:       defaultMaxAlternatives,
+	}
+	content := data
+	channels := 1
+
+	switch {
+	case audio.IsWAV(data):
+		info, pcm, err := audio.ParseWAV(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.Encoding = speechpb.RecognitionConfig_LINEAR16
+		config.SampleRateHertz = int32(info.SampleRate)
+		channels = info.Channels
+		content = pcm
+
+		// Float and G.711 files are converted to 16 kHz LINEAR16 like streamed audio
+		input, ok := wavInputFormat(info)
+		if !ok {
+			return nil, nil, errUnsupportedAudio
+		}
+		if input.Encoding != audio.EncodingPCM16 {
+			converter, err := audio.NewConverter(input, info.Channels)
+			if err != nil {
+				return nil, nil, err
+			}
+			// The whole file is available, so the resampler's held-back tail is drained as well
+			content = append(converter.Convert(pcm), converter.Flush()...)
+			config.SampleRateHertz = audio.TargetSampleRate
+		}
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		// The sample rate is read from the FLAC header by the recognizer
+		config.Encoding = speechpb.RecognitionConfig_FLAC
+		channels = flacChannels(data)
+	case bytes.HasPrefix(data, []byte("OggS")):
+		// Ogg also carries Vorbis, FLAC or Speex, which the recognizer does not accept
+		head, ok := oggFirstPacket(data)
+		if !ok || !bytes.HasPrefix(head, []byte("OpusHead")) || len(head) < 19 {
+			return nil, nil, fmt.Errorf("%w: the Ogg stream does not contain Opus audio", errUnsupportedAudio)
+		}
+		config.Encoding = speechpb.RecognitionConfig_OGG_OPUS
+		config.SampleRateHertz = 48000
+		channels = int(head[9])
+	default:
+		return nil, nil, errUnsupportedAudio
+	}
+
+	if channels < 1 || channels > 2 {
+		return nil, nil, fmt.Errorf("unsupported channel count %d", channels)
+	}
+	// Stereo files carry the customer on the left channel and the agent on the right
+	config.AudioChannelCount = int32(channels)
+	config.EnableSeparateRecognitionPerChannel = channels == 2
+	return config, content, nil
+}
+
+// wavInputFormat maps a WAV header to a converter input format
+func wavInputFormat(info audio.WAVInfo) (audio.Format, bool) {
+	format := audio.Format{SampleRate: info.SampleRate, Channels: info.Channels}
+	switch {
+	case info.AudioFormat == audio.WAVFormatPCM && info.BitsPerSample == 16:
+		format.Encoding = audio.EncodingPCM16
+	case info.AudioFormat == audio.WAVFormatFloat && info.BitsPerSample == 32:
+		format.Encoding = audio.EncodingFloat32
+	case info.AudioFormat == audio.WAVFormatMuLaw && info.BitsPerSample == 8:
+		format.Encoding = audio.EncodingMuLaw
+	case info.AudioFormat == audio.WAVFormatALaw && info.BitsPerSample == 8:
+		format.Encoding = audio.EncodingALaw
+	default:
+		return format, false
+	}
+	return format, true
+}
+
+// flacChannels reads the channel count from the FLAC STREAMINFO block
+func flacChannels(data []byte) int {
+	// "fLaC" + 4-byte block header + STREAMINFO; channels-1 is stored in bits 1-3 of byte 12
+	if len(data) < 8+13 {
+		return 1
+	}
+	return int((data[8+12]>>1)&0x07) + 1
+}
+
+// oggFirstPacket returns the first packet of an Ogg stream, which identifies its codec,
+// e.g. the OpusHead packet. ok is false when the first page is truncated.
+func oggFirstPacket(data []byte) (packet []byte, ok bool) {
+	// The page header is 27 bytes, followed by a segment table of one lacing value per segment
+	if len(data) < 27 {
+		return nil, false
+	}
+	segments := int(data[26])
+	if len(data) < 27+segments {
+		return nil, false
+	}
+	// A packet continues while its segments are 255 bytes long
+	size := 0
+	for _, lacing := range data[27 : 27+segments] {
+		size += int(lacing)
+		if lacing < 255 {
+			start := 27 + segments
+			if len(data) < start+size {
+				return nil, false
+			}
+			return data[start : start+size], true
+		}
+	}
+	return nil, false
+}
+
+// runTranscriptionJob runs the recognizer in non-streaming mode and stores the result as conversation turns
+// under the job ID
+func runTranscriptionJob(ctx context.Context, id, username, team string, config *speechpb.RecognitionConfig, content []byte) {
+	ctx, cancel := context.WithTimeout(ctx, transcriptionTimeout)
+	defer cancel()
+
+	updateTranscriptionJob(id, func(job *models.TranscriptionJob) {
+		job.Status = models.JobRunning
+	})
+
+	client, err := speech.NewClient(ctx)
+	if err != nil {
+		failTranscriptionJob(ctx, id, err)
+		return
+	}
+	defer client.Close()
+
+	op, err := client.LongRunningRecognize(ctx, &speechpb.LongRunningRecognizeRequest{
+		Config: config,
+		Audio: &speechpb.RecognitionAudio{
+			AudioSource: &speechpb.RecognitionAudio_Content{Content: content},
+		},
+	})
+	if err != nil {
+		failTranscriptionJob(ctx, id, err)
+		return
+	}
+
+	// Poll so that progress is visible for long files
+	var resp *speechpb.LongRunningRecognizeResponse
+	for {
+		resp, err = op.Poll(ctx)
+		if err != nil {
+			failTranscriptionJob(ctx, id, err)
+			return
+		}
+		if op.Done() {
+			break
+		}
+		if metadata, err := op.Metadata(); err == nil && metadata != nil {
+			updateTranscriptionJob(id, func(job *models.TranscriptionJob) {
+				job.Progress = int(metadata.ProgressPercent)
+			})
+		}
+		select {
+		case <-ctx.Done():
+			failTranscriptionJob(ctx, id, ctx.Err())
+			return
+		case <-time.After(transcriptionPollInterval):
+		}
+	}
+
+	// Results of separate channels are interleaved by time to rebuild the dialogue
+	results := resp.Results
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].ResultEndTime.AsDuration() < results[j].ResultEndTime.AsDuration()
+	})
+
+	recorder := newTurnRecorder(username, team, id)
+	recorder.key = transcriptionStoreKey(id)
+	recorder.customerOnly = !config.EnableSeparateRecognitionPerChannel
+	var turns []models.Turn
+	for _, result := range results {
+		if len(result.Alternatives) == 0 || strings.TrimSpace(result.Alternatives[0].Transcript) == "" {
+			continue
+		}
+
+		speaker := models.SpeakerCustomer
+		channel := 0
+		if config.EnableSeparateRecognitionPerChannel && result.ChannelTag == 2 {
+			speaker = models.SpeakerAgent
+			channel = 1
+		}
+
+		turn := models.Turn{
+			Speaker:      speaker,
+			Text:         result.Alternatives[0].Transcript,
+			Language:     config.LanguageCode,
+			Channel:      channel,
+			Confidence:   result.Alternatives[0].Confidence,
+			Words:        convertWords(result.Alternatives[0].Words),
+			Alternatives: convertAlternatives(result.Alternatives),
+		}
+
+		var analysis *models.TurnAnalysis
+		if speaker == models.SpeakerCustomer {
+			result := analyzeTurn(ctx, turn.Text)
+			analysis = &result
+		}
+		recorder.record(ctx, turn, analysis)
+		turns = append(turns, turn)
+	}
+
+	updateTranscriptionJob(id, func(job *models.TranscriptionJob) {
+		job.Status = models.JobCompleted
+		job.Progress = 100
+		job.Turns = turns
+	})
+	transcriptionLog.InfoContext(ctx, "transcription job completed", "job_id", id, "username", username, "turns", len(turns))
+}
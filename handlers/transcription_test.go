@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	"bytes"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// addTranscriptionJob stores a job with one recorded customer turn and removes it after the test
+func addTranscriptionJob(t *testing.T, id, username, status string, updatedAt time.Time) {
+	t.Helper()
+	transcriptionJobsMutex.Lock()
+	transcriptionJobs[id] = &models.TranscriptionJob{ID: id, Username: username, Status: status, UpdatedAt: updatedAt}
+	transcriptionJobsMutex.Unlock()
+
+	recorder := newTurnRecorder(username, "", id)
+	recorder.key = transcriptionStoreKey(id)
+	recorder.record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Ich habe eine Frage."}, nil)
+
+	t.Cleanup(func() {
+		transcriptionJobsMutex.Lock()
+		delete(transcriptionJobs, id)
+		transcriptionJobsMutex.Unlock()
+		storeMutex.Lock()
+		delete(conversationStore, transcriptionStoreKey(id))
+		storeMutex.Unlock()
+	})
+}
+
+func TestTranscriptionTurnsStayOutOfLiveConversation(t *testing.T) {
+	t.Cleanup(func() {
+		storeMutex.Lock()
+		delete(conversationStore, "tina")
+		storeMutex.Unlock()
+	})
+	live := newTurnRecorder("tina", "", "session-1")
+	live.record(context.Background(), models.Turn{Speaker: models.SpeakerCustomer, Text: "Mein Internet geht nicht."}, nil)
+	addTranscriptionJob(t, "job-1", "tina", models.JobCompleted, time.Now())
+
+	conversations, _ := GetConversations(context.Background(), "tina")
+	if len(conversations) != 1 || conversations[0].SessionID != "session-1" {
+		t.Errorf("live conversation holds %+v, want only the session turn", conversations)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/conversations?SessionID=job-1", nil)
+	rec := httptest.NewRecorder()
+	HandleGetConversations(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	var jobConversations []models.Conversation
+	if err := json.NewDecoder(rec.Body).Decode(&jobConversations); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobConversations) != 1 || jobConversations[0].SessionID != "job-1" || jobConversations[0].Question != "Ich habe eine Frage." {
+		t.Errorf("got job conversations %+v", jobConversations)
+	}
+}
+
+func TestExpireTranscriptionJobs(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-transcriptionJobTTL - time.Minute)
+	addTranscriptionJob(t, "expired-completed", "tina", models.JobCompleted, old)
+	addTranscriptionJob(t, "expired-failed", "tina", models.JobFailed, old)
+	addTranscriptionJob(t, "recent", "tina", models.JobCompleted, now.Add(-time.Hour))
+	addTranscriptionJob(t, "long-running", "tina", models.JobRunning, old)
+
+	expireTranscriptionJobs(now)
+
+	for id, want := range map[string]bool{
+		"expired-completed": false,
+		"expired-failed":    false,
+		"recent":            true,
+		"long-running":      true,
+	} {
+		_, exists := GetTranscriptionJob(id)
+		_, stored := GetConversations(context.Background(), transcriptionStoreKey(id))
+		if exists != want || stored != want {
+			t.Errorf("%s: job kept %v, turns kept %v; want %v", id, exists, stored, want)
+		}
+	}
+}
+
+// oggPage wraps a packet in the first page of an Ogg stream
+func oggPage(packet []byte) []byte {
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	header[5] = 0x02 // beginning of stream
+	var lacing []byte
+	for size := len(packet); ; size -= 255 {
+		if size < 255 {
+			lacing = append(lacing, byte(size))
+			break
+		}
+		lacing = append(lacing, 255)
+	}
+	header[26] = byte(len(lacing))
+	return append(append(header, lacing...), packet...)
+}
+
+// opusHead returns an OpusHead identification packet for the channel count
+func opusHead(channels byte) []byte {
+	return append([]byte("OpusHead"), 1, channels, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+}
+
+func TestBatchRecognitionConfigChecksOggCodec(t *testing.T) {
+	config, _, err := batchRecognitionConfig(oggPage(opusHead(2)), "de-DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Encoding != speechpb.RecognitionConfig_OGG_OPUS || config.AudioChannelCount != 2 || !config.EnableSeparateRecognitionPerChannel {
+		t.Errorf("got encoding %v with %d channels, want stereo Ogg/Opus", config.Encoding, config.AudioChannelCount)
+	}
+
+	// A long first packet spans several segments
+	long := append(opusHead(1), make([]byte, 300)...)
+	if config, _, err := batchRecognitionConfig(oggPage(long), "de-DE"); err != nil || config.AudioChannelCount != 1 {
+		t.Errorf("multi-segment OpusHead: got %v, %v", config, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"vorbis":         oggPage(append([]byte("\x01vorbis"), make([]byte, 22)...)),
+		"flac in ogg":    oggPage(append([]byte("\x7fFLAC"), make([]byte, 40)...)),
+		"opus later":     append(oggPage([]byte("\x01vorbis")), opusHead(1)...),
+		"truncated page": oggPage(opusHead(1))[:30],
+		"short opus":     oggPage([]byte("OpusHead")),
+	} {
+		if _, _, err := batchRecognitionConfig(data, "de-DE"); !errors.Is(err, errUnsupportedAudio) {
+			t.Errorf("%s: got error %v, want errUnsupportedAudio", name, err)
+		}
+	}
+}
+
+func TestCreateTranscriptionRejectsUnsupportedAudio(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("username", "tina")
+	file, err := form.CreateFormFile("audio", "call.ogg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(oggPage(append([]byte("\x01vorbis"), make([]byte, 22)...)))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/transcriptions", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	HandleCreateTranscription(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("got status %d, want 415: %s", rec.Code, rec.Body.String())
+	}
+}
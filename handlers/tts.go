@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"awesomeProject2/audio"
+	"awesomeProject2/logging"
+	"awesomeProject2/tracing"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Audio formats supported by the TTS endpoint
+const (
+	AudioFormatWAV  = "wav"
+	AudioFormatOpus = "opus"
+)
+
+// ttsChunkSize is the size of binary frames used when streaming audio over the speech WebSocket
+const ttsChunkSize = 16 * 1024
+
+// maxTTSTextLength is the longest text in characters synthesized per request; the OpenAI
+// speech API rejects longer input
+var maxTTSTextLength = 4096
+
+// errTTSTextTooLong is returned for text longer than maxTTSTextLength
+var errTTSTextTooLong = errors.New("text too long for speech synthesis")
+
+// ConfigureTTS sets the longest text in characters synthesized per request
+func ConfigureTTS(maxTextLength int) {
+	maxTTSTextLength = maxTextLength
+}
+
+// checkTTSTextLength returns errTTSTextTooLong for text longer than maxTTSTextLength
+func checkTTSTextLength(text string) error {
+	if n := utf8.RuneCountInString(text); n > maxTTSTextLength {
+		return fmt.Errorf("%w: %d characters, at most %d are allowed", errTTSTextTooLong, n, maxTTSTextLength)
+	}
+	return nil
+}
+
+// ttsLog logs text-to-speech requests
+var ttsLog = logging.For("tts")
+
+// ttsTracer traces speech synthesis requests
+var ttsTracer = tracing.Tracer("tts")
+
+// ErrUnsupportedFormat is returned when a synthesizer cannot produce the requested format
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Synthesizer converts German text to audio
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text, format string) ([]byte, error)
+}
+
+var (
+	synthesizer      Synthesizer = NewOpenAISynthesizer("tts-1", "alloy")
+	synthesizerMutex sync.RWMutex
+)
+
+// SetSynthesizer replaces the synthesizer used by the TTS endpoint and the speech WebSocket
+func SetSynthesizer(s Synthesizer) {
+	synthesizerMutex.Lock()
+	defer synthesizerMutex.Unlock()
+	synthesizer = s
+}
+
+// synthesize runs the configured synthesizer
+func synthesize(ctx context.Context, text, format string) ([]byte, error) {
+	synthesizerMutex.RLock()
+	s := synthesizer
+	synthesizerMutex.RUnlock()
+	return s.Synthesize(ctx, text, format)
+}
+
+// audioContentType returns the MIME type for an audio format
+func audioContentType(format string) string {
+	if format == AudioFormatOpus {
+		return "audio/ogg"
+	}
+	return "audio/wav"
+}
+
+// normalizeAudioFormat validates the requested format and defaults to WAV
+func normalizeAudioFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", AudioFormatWAV:
+		return AudioFormatWAV, nil
+	case AudioFormatOpus:
+		return AudioFormatOpus, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// HandleTextToSpeech handles requests to synthesize a German response
+func HandleTextToSpeech(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse request body; "german" matches the field of a chosen Response
+	var requestBody struct {
+		German string `json:"german"`
+		Format string `json:"format"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		ttsLog.WarnContext(r.Context(), "parsing request body failed", logging.Err(err))
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestBody.German) == "" {
+		http.Error(w, "german is required", http.StatusBadRequest)
+		return
+	}
+	if err := checkTTSTextLength(requestBody.German); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	format, err := normalizeAudioFormat(requestBody.Format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ttsLog.InfoContext(r.Context(), "synthesizing speech", "format", format, "chars", len(requestBody.German))
+	data, err := synthesize(r.Context(), requestBody.German, format)
+	if err != nil {
+		ttsLog.ErrorContext(r.Context(), "speech synthesis failed", logging.Err(err))
+		if errors.Is(err, ErrUnsupportedFormat) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Error synthesizing speech: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", audioContentType(format))
+	w.Write(data)
+	ttsLog.DebugContext(r.Context(), "speech synthesized", "bytes", len(data))
+}
+
+// streamSpeechOverWebSocket synthesizes text and sends it as binary frames framed by JSON events
+func streamSpeechOverWebSocket(ctx context.Context, conn *safeConn, text, format string) error {
+	format, err := normalizeAudioFormat(format)
+	if err != nil {
+		return err
+	}
+
+	data, err := synthesize(ctx, text, format)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{
+		"type":         "tts_start",
+		"format":       format,
+		"content_type": audioContentType(format),
+		"bytes":        len(data),
+	}); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(data); start += ttsChunkSize {
+		end := start + ttsChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, data[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return conn.WriteJSON(map[string]interface{}{
+		"type": "tts_end",
+	})
+}
+
+// OpenAISynthesizer uses the OpenAI speech API
+type OpenAISynthesizer struct {
+	Model string
+	Voice string
+}
+
+// NewOpenAISynthesizer creates a synthesizer backed by the OpenAI speech API
+func NewOpenAISynthesizer(model, voice string) *OpenAISynthesizer {
+	return &OpenAISynthesizer{Model: model, Voice: voice}
+}
+
+// Synthesize requests audio for the text from the OpenAI API
+func (s *OpenAISynthesizer) Synthesize(ctx context.Context, text, format string) (data []byte, err error) {
+	ctx, span := ttsTracer.Start(ctx, "openai.speech", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("openai.model", s.Model), attribute.Int("tts.chars", len(text))))
+	defer func() { tracing.End(span, err) }()
+
+	requestJSON, err := json.Marshal(map[string]interface{}{
+		"model":           s.Model,
+		"voice":           s.Voice,
+		"input":           text,
+		"response_format": format,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/audio/speech", bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+openAIAPIKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		observeOpenAIError("speech", 0)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		ttsLog.ErrorContext(ctx, "openai tts request failed", "status", resp.StatusCode, "response", string(body))
+		observeOpenAIError("speech", resp.StatusCode)
+		return nil, fmt.Errorf("OpenAI TTS error: %s", string(body))
+	}
+	return body, nil
+}
+
+// ToneSynthesizer is a local stand-in that renders one short tone per word as WAV
+type ToneSynthesizer struct {
+	SampleRate int
+}
+
+// NewToneSynthesizer creates a synthesizer that needs no external service
+func NewToneSynthesizer() *ToneSynthesizer {
+	return &ToneSynthesizer{SampleRate: 16000}
+}
+
+// Synthesize renders the text as a sequence of tones
+func (s *ToneSynthesizer) Synthesize(ctx context.Context, text, format string) ([]byte, error) {
+	if format != AudioFormatWAV {
+		return nil, fmt.Errorf("%w: %s (local synthesizer only produces wav)", ErrUnsupportedFormat, format)
+	}
+
+	words := strings.Fields(text)
+	toneSamples := s.SampleRate / 5
+	pauseSamples := s.SampleRate / 20
+
+	var pcm bytes.Buffer
+	for i, word := range words {
+		// Vary the pitch by word length so different texts sound different
+		frequency := 300.0 + float64(len(word)%8)*60.0
+		for n := 0; n < toneSamples; n++ {
+			sample := int16(8000 * math.Sin(2*math.Pi*frequency*float64(n)/float64(s.SampleRate)))
+			binary.Write(&pcm, binary.LittleEndian, sample)
+		}
+		if i < len(words)-1 {
+			pcm.Write(make([]byte, pauseSamples*2))
+		}
+	}
+
+	return audio.EncodeWAV(pcm.Bytes(), s.SampleRate, 1), nil
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSynthesizer uses the synthesizer for one test and restores the previous one afterwards
+func withSynthesizer(t *testing.T, s Synthesizer) {
+	t.Helper()
+	synthesizerMutex.RLock()
+	previous := synthesizer
+	synthesizerMutex.RUnlock()
+	SetSynthesizer(s)
+	t.Cleanup(func() { SetSynthesizer(previous) })
+}
+
+// gatedSynthesizer blocks until release is closed and then synthesizes tones
+type gatedSynthesizer struct {
+	release chan struct{}
+}
+
+func (s *gatedSynthesizer) Synthesize(ctx context.Context, text, format string) ([]byte, error) {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return NewToneSynthesizer().Synthesize(ctx, text, format)
+}
+
+func TestHandleTextToSpeech(t *testing.T) {
+	withSynthesizer(t, NewToneSynthesizer())
+
+	tests := []struct {
+		name        string
+		body        string
+		status      int
+		contentType string
+	}{
+		{"wav", `{"german": "Guten Tag, wie kann ich helfen?"}`, http.StatusOK, "audio/wav"},
+		{"explicit wav", `{"german": "Guten Tag", "format": "WAV"}`, http.StatusOK, "audio/wav"},
+		{"opus not produced locally", `{"german": "Guten Tag", "format": "opus"}`, http.StatusBadRequest, ""},
+		{"unknown format", `{"german": "Guten Tag", "format": "mp3"}`, http.StatusBadRequest, ""},
+		{"empty text", `{"german": "  "}`, http.StatusBadRequest, ""},
+		{"text too long", `{"german": "` + strings.Repeat("ä", maxTTSTextLength+1) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{"longest text", `{"german": "` + strings.Repeat("ä", maxTTSTextLength) + `"}`, http.StatusOK, "audio/wav"},
+		{"invalid body", `{`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HandleTextToSpeech(w, httptest.NewRequest(http.MethodPost, "/tts", strings.NewReader(tt.body)))
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("got content type %q, want %q", got, tt.contentType)
+			}
+			if !bytes.HasPrefix(w.Body.Bytes(), []byte("RIFF")) {
+				t.Errorf("response is not a WAV file")
+			}
+		})
+	}
+}
+
+func TestWebSocketSynthesisDoesNotBlockReadLoop(t *testing.T) {
+	gate := &gatedSynthesizer{release: make(chan struct{})}
+	withSynthesizer(t, gate)
+	s := testSession(t)
+	t.Cleanup(s.finish)
+
+	handled := make(chan struct{})
+	server, _ := keepAliveServer(t, func(conn *safeConn) {
+		s.handleControlMessage(context.Background(), conn, []byte(`{"type": "tts", "text": "Guten Tag"}`))
+		close(handled)
+	})
+	client := dial(t, server)
+
+	// The control message is handled while the synthesizer still blocks
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("handling the tts message waits for the synthesis")
+	}
+	close(gate.release)
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var start struct {
+		Type  string `json:"type"`
+		Bytes int    `json:"bytes"`
+	}
+	if err := client.ReadJSON(&start); err != nil {
+		t.Fatal(err)
+	}
+	if start.Type != "tts_start" {
+		t.Fatalf("got %q, want tts_start", start.Type)
+	}
+
+	var audio []byte
+	for {
+		messageType, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType == websocket.BinaryMessage {
+			audio = append(audio, data...)
+			continue
+		}
+		var end struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &end); err != nil {
+			t.Fatal(err)
+		}
+		if end.Type != "tts_end" {
+			t.Fatalf("got %q, want tts_end", end.Type)
+		}
+		break
+	}
+	if len(audio) != start.Bytes || !bytes.HasPrefix(audio, []byte("RIFF")) {
+		t.Errorf("got %d bytes of audio, want a %d byte WAV file", len(audio), start.Bytes)
+	}
+}
+
+// readTTSError reads messages until a tts_error arrives and returns its error text
+func readTTSError(t *testing.T, client *websocket.Conn) string {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var message struct {
+			Type  string `json:"type"`
+			Error string `json:"error"`
+		}
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type == "tts_error" {
+			return message.Error
+		}
+	}
+}
+
+func TestWebSocketSynthesisRejectsConcurrentAndLongRequests(t *testing.T) {
+	gate := &gatedSynthesizer{release: make(chan struct{})}
+	withSynthesizer(t, gate)
+	s := testSession(t)
+	t.Cleanup(s.finish)
+
+	server, _ := keepAliveServer(t, func(conn *safeConn) {
+		s.handleControlMessage(context.Background(), conn, []byte(`{"type": "tts", "text": "Guten Tag"}`))
+		s.handleControlMessage(context.Background(), conn, []byte(`{"type": "tts", "text": "Auf Wiedersehen"}`))
+	})
+	client := dial(t, server)
+
+	if got := readTTSError(t, client); !strings.Contains(got, "already in progress") {
+		t.Errorf("got error %q for a second request, want it rejected", got)
+	}
+
+	// The running synthesis counts as background work of the session
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("background work finished while the synthesis blocks")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(gate.release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("synthesis did not finish")
+	}
+
+	server, _ = keepAliveServer(t, func(conn *safeConn) {
+		s.handleControlMessage(context.Background(), conn, []byte(`{"type": "tts", "text": "`+strings.Repeat("a", maxTTSTextLength+1)+`"}`))
+	})
+	if got := readTTSError(t, dial(t, server)); !strings.Contains(got, "too long") {
+		t.Errorf("got error %q, want the text rejected as too long", got)
+	}
+}
+
+func TestWebSocketSynthesisStopsWithConnection(t *testing.T) {
+	withSynthesizer(t, &gatedSynthesizer{release: make(chan struct{})})
+	s := testSession(t)
+	t.Cleanup(s.finish)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server, _ := keepAliveServer(t, func(conn *safeConn) {
+		s.handleControlMessage(ctx, conn, []byte(`{"type": "tts", "text": "Guten Tag"}`))
+	})
+	dial(t, server)
+	for deadline := time.Now().Add(2 * time.Second); !s.ttsBusy.Load(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("synthesis did not start")
+		}
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("synthesis kept running after its connection stopped")
+	}
+	if s.ttsBusy.Load() {
+		t.Error("a canceled synthesis still blocks new requests")
+	}
+}
+
+func TestSafeConnRejectsWritesAfterClose(t *testing.T) {
+	closed := make(chan error, 1)
+	server, _ := keepAliveServer(t, func(conn *safeConn) {
+		conn.Close()
+		closed <- conn.WriteJSON(map[string]string{"type": "tts_end"})
+	})
+	dial(t, server)
+	select {
+	case err := <-closed:
+		if !errors.Is(err, errConnClosed) {
+			t.Errorf("got error %v, want errConnClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write did not return")
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+)
+
+// lowConfidenceThreshold marks words the agent should double-check
+const lowConfidenceThreshold = 0.6
+
+// Limits for the number of recognition alternatives requested per result
+const (
+	defaultMaxAlternatives = 3
+	maxAlternativesLimit   = 30
+)
+
+// convertWords converts Google word infos into stored words
+func convertWords(words []*speechpb.WordInfo) []models.Word {
+	if len(words) == 0 {
+		return nil
+	}
+	converted := make([]models.Word, 0, len(words))
+	for _, word := range words {
+		converted = append(converted, models.Word{
+			Word:          word.Word,
+			StartMs:       word.StartTime.AsDuration().Milliseconds(),
+			EndMs:         word.EndTime.AsDuration().Milliseconds(),
+			Confidence:    word.Confidence,
+			LowConfidence: word.Confidence > 0 && word.Confidence < lowConfidenceThreshold,
+			SpeakerTag:    word.SpeakerTag,
+		})
+	}
+	return converted
+}
+
+// convertAlternatives converts the top recognition hypotheses of a result
+func convertAlternatives(alternatives []*speechpb.SpeechRecognitionAlternative) []models.Alternative {
+	converted := make([]models.Alternative, 0, len(alternatives))
+	for _, alternative := range alternatives {
+		converted = append(converted, models.Alternative{
+			Transcript: alternative.Transcript,
+			Confidence: alternative.Confidence,
+		})
+	}
+	return converted
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"awesomeProject2/models"
+	speechpb "cloud.google.com/go/speech/apiv1/speechpb"
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"reflect"
+	"testing"
+)
+
+func TestConvertWords(t *testing.T) {
+	uncertain := wordInfo("Glasfaser", 0, 1250, 1900)
+	uncertain.Confidence = 0.4
+	unscored := wordInfo("Router", 0, 2000, 2400)
+	unscored.Confidence = 0
+
+	words := convertWords([]*speechpb.WordInfo{wordInfo("Mein", 0, 0, 300), uncertain, unscored})
+	if len(words) != 3 {
+		t.Fatalf("got %d words, want 3", len(words))
+	}
+	if words[1].StartMs != 1250 || words[1].EndMs != 1900 {
+		t.Errorf("got %d-%d ms, want 1250-1900 ms", words[1].StartMs, words[1].EndMs)
+	}
+	// Words without a confidence score are not flagged
+	if words[0].LowConfidence || !words[1].LowConfidence || words[2].LowConfidence {
+		t.Errorf("got low confidence flags %v, %v, %v; want only the uncertain word flagged",
+			words[0].LowConfidence, words[1].LowConfidence, words[2].LowConfidence)
+	}
+	if convertWords(nil) != nil {
+		t.Error("no words should convert to nil")
+	}
+}
+
+func TestAlignWordsAcrossStreams(t *testing.T) {
+	// Word times restart with every stream; the audio sent before the stream opened is added
+	recognition := &recognitionChannel{streamBaseMs: 290000}
+	words := convertWords([]*speechpb.WordInfo{wordInfo("Hallo", 0, 100, 500)})
+	recognition.alignWords(words)
+	if words[0].StartMs != 290100 || words[0].EndMs != 290500 {
+		t.Errorf("got %d-%d ms, want 290100-290500 ms", words[0].StartMs, words[0].EndMs)
+	}
+}
+
+func TestFinalEventCarriesWordsAndAlternatives(t *testing.T) {
+	s := testSession(t)
+	t.Cleanup(func() {
+		s.finish()
+		storeMutex.Lock()
+		delete(conversationStore, "anna")
+		storeMutex.Unlock()
+	})
+
+	hello := wordInfo("Hallo", 0, 0, 400)
+	hello.Confidence = 0.4
+	recognition := &recognitionChannel{
+		speaker:  models.SpeakerCustomer,
+		language: "de-DE",
+		stream: &fakeRecognitionStream{responses: []*speechpb.StreamingRecognizeResponse{
+			{Results: []*speechpb.StreamingRecognitionResult{{
+				Alternatives: []*speechpb.SpeechRecognitionAlternative{{Transcript: "Hal"}},
+			}}},
+			{Results: []*speechpb.StreamingRecognitionResult{{
+				IsFinal: true,
+				Alternatives: []*speechpb.SpeechRecognitionAlternative{
+					{Transcript: "Hallo Welt", Confidence: 0.8, Words: []*speechpb.WordInfo{hello, wordInfo("Welt", 0, 500, 900)}},
+					{Transcript: "Hallo Geld", Confidence: 0.5},
+				},
+			}}},
+		}},
+		span:         trace.SpanFromContext(context.Background()),
+		streamBaseMs: 1000,
+	}
+	s.receiveRecognitionResults(context.Background(), recognition)
+
+	s.eventsMu.Lock()
+	var transcripts []map[string]interface{}
+	for _, event := range s.events {
+		if event["type"] == "transcript" {
+			transcripts = append(transcripts, event)
+		}
+	}
+	s.eventsMu.Unlock()
+
+	if len(transcripts) != 2 {
+		t.Fatalf("got %d transcript events, want 2", len(transcripts))
+	}
+	if _, found := transcripts[0]["words"]; found {
+		t.Errorf("interim event carries words: %v", transcripts[0])
+	}
+	wantWords := []models.Word{
+		{Word: "Hallo", StartMs: 1000, EndMs: 1400, Confidence: 0.4, LowConfidence: true},
+		{Word: "Welt", StartMs: 1500, EndMs: 1900, Confidence: 0.9},
+	}
+	wantAlternatives := []models.Alternative{{Transcript: "Hallo Welt", Confidence: 0.8}, {Transcript: "Hallo Geld", Confidence: 0.5}}
+	if words := transcripts[1]["words"]; !reflect.DeepEqual(words, wantWords) {
+		t.Errorf("got final event words %+v, want %+v", words, wantWords)
+	}
+	if alternatives := transcripts[1]["alternatives"]; !reflect.DeepEqual(alternatives, wantAlternatives) {
+		t.Errorf("got final event alternatives %+v, want %+v", alternatives, wantAlternatives)
+	}
+
+	conversations, _ := GetConversations(context.Background(), "anna")
+	if len(conversations) != 1 || len(conversations[0].Turns) != 1 {
+		t.Fatalf("got stored conversations %+v, want one turn", conversations)
+	}
+	if stored := conversations[0].Turns[0]; !reflect.DeepEqual(stored.Words, wantWords) || !reflect.DeepEqual(stored.Alternatives, wantAlternatives) {
+		t.Errorf("got stored words %+v and alternatives %+v", stored.Words, stored.Alternatives)
+	}
+}
@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// settings is the active output handler and level configuration
+type settings struct {
+	handler slog.Handler
+	level   slog.Level
+	modules map[string]slog.Level
+}
+
+// current is swapped by Setup; loggers created earlier pick up the new settings
+var current atomic.Pointer[settings]
+
+func init() {
+	current.Store(&settings{
+		handler: slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// Setup configures the output format ("json" or "text"), the default level and per-module
+// levels given as "module=level". Messages of the standard log package are routed through it too.
+func Setup(w io.Writer, format, level string, modules []string) error {
+	s := &settings{modules: make(map[string]slog.Level)}
+
+	var err error
+	if s.level, err = ParseLevel(level); err != nil {
+		return err
+	}
+	for _, entry := range modules {
+		module, value, found := strings.Cut(entry, "=")
+		if !found || module == "" {
+			return fmt.Errorf("module level %q must look like module=level", entry)
+		}
+		if s.modules[module], err = ParseLevel(value); err != nil {
+			return fmt.Errorf("module %s: %w", module, err)
+		}
+	}
+
+	// Levels are filtered per module, so the output handler accepts everything
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch format {
+	case "", "json":
+		s.handler = slog.NewJSONHandler(w, options)
+	case "text":
+		s.handler = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	current.Store(s)
+	slog.SetDefault(For("default"))
+	return nil
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+	return level, nil
+}
+
+// For returns the logger of a module. Every line carries the module name and the
+// request, session, trace and span IDs found in the context passed to the logging call.
+func For(module string) *slog.Logger {
+	return slog.New(&moduleHandler{module: module})
+}
+
+// minLevel returns the configured level of a module
+func (s *settings) minLevel(module string) slog.Level {
+	if level, ok := s.modules[module]; ok {
+		return level
+	}
+	return s.level
+}
+
+// moduleHandler filters records by module level and adds the context IDs
+type moduleHandler struct {
+	module string
+	// wrap replays WithAttrs and WithGroup calls on the current output handler
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().minLevel(h.module)
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, record slog.Record) error {
+	s := current.Load()
+	reveal := s.minLevel(h.module) <= slog.LevelDebug
+
+	// The module and IDs come first so that they stay outside of any group
+	ids := []slog.Attr{slog.String("module", h.module)}
+	if id := RequestID(ctx); id != "" {
+		ids = append(ids, slog.String("request_id", id))
+	}
+	if id := SessionID(ctx); id != "" {
+		ids = append(ids, slog.String("session_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		ids = append(ids, slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+
+	out := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		if value, ok := attr.Value.Any().(sensitive); ok && reveal {
+			attr.Value = slog.StringValue(string(value))
+		}
+		out.AddAttrs(attr)
+		return true
+	})
+
+	handler := s.handler.WithAttrs(ids)
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, out)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *moduleHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	wraps := append(append([]func(slog.Handler) slog.Handler{}, h.wrap...), wrap)
+	return &moduleHandler{module: h.module, wrap: wraps}
+}
+
+// sensitive is customer content that is masked unless the module logs at debug level
+type sensitive string
+
+// LogValue masks the content but keeps its length for troubleshooting
+func (s sensitive) LogValue() slog.Value {
+	return slog.StringValue(fmt.Sprintf("[redacted %d chars]", len([]rune(string(s)))))
+}
+
+// Text returns an attribute for transcripts, prompts and other customer content
+func Text(key, value string) slog.Attr {
+	return slog.Any(key, sensitive(value))
+}
+
+// Err returns the conventional attribute for an error
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+// Context keys of the IDs added to every log line
+type requestIDKey struct{}
+type sessionIDKey struct{}
+
+// WithRequestID returns a context whose log lines carry the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of a context
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithSessionID returns a context whose log lines carry the speech session ID
+func WithSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, id)
+}
+
+// SessionID returns the speech session ID of a context
+func SessionID(ctx context.Context) string {
+	id, _ := ctx.Value(sessionIDKey{}).(string)
+	return id
+}
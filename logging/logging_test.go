@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// setupForTest configures logging into a buffer for one test
+func setupForTest(t *testing.T, format, level string, modules ...string) *bytes.Buffer {
+	t.Helper()
+	previous, previousDefault := current.Load(), slog.Default()
+	t.Cleanup(func() {
+		current.Store(previous)
+		slog.SetDefault(previousDefault)
+	})
+	var buf bytes.Buffer
+	if err := Setup(&buf, format, level, modules); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// lines decodes the JSON log lines written so far
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var decoded []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %s", line)
+		}
+		decoded = append(decoded, entry)
+	}
+	return decoded
+}
+
+func TestSetupRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		modules []string
+	}{
+		{"unknown level", "json", "verbose", nil},
+		{"unknown format", "xml", "info", nil},
+		{"module without level", "json", "info", []string{"speech"}},
+		{"level without module", "json", "info", []string{"=debug"}},
+		{"unknown module level", "json", "info", []string{"speech=loud"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Setup(&bytes.Buffer{}, tt.format, tt.level, tt.modules); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	buf := setupForTest(t, "json", "warn", "speech=debug")
+
+	For("speech").Debug("speech debug")
+	For("auth").Info("auth info")
+	For("auth").Warn("auth warn")
+
+	got := lines(t, buf)
+	if len(got) != 2 || got[0]["msg"] != "speech debug" || got[1]["msg"] != "auth warn" {
+		t.Fatalf("got %v, want the speech debug and auth warn lines", got)
+	}
+	if got[0]["module"] != "speech" || got[1]["module"] != "auth" {
+		t.Errorf("got modules %v and %v", got[0]["module"], got[1]["module"])
+	}
+}
+
+func TestTextIsMaskedUnlessDebug(t *testing.T) {
+	buf := setupForTest(t, "json", "info", "speech=debug")
+
+	For("assist").Info("prompt", Text("text", "Mein Internet geht nicht."))
+	For("speech").Info("transcript", Text("text", "Mein Internet geht nicht."))
+
+	got := lines(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want 2", len(got))
+	}
+	if got[0]["text"] != "[redacted 25 chars]" {
+		t.Errorf("got %v at info level, want the text masked", got[0]["text"])
+	}
+	if got[1]["text"] != "Mein Internet geht nicht." {
+		t.Errorf("got %v at debug level, want the text", got[1]["text"])
+	}
+}
+
+func TestContextIDs(t *testing.T) {
+	buf := setupForTest(t, "json", "info")
+
+	ctx := WithSessionID(WithRequestID(context.Background(), "req-1"), "session-1")
+	For("speech").With("channel", 1).InfoContext(ctx, "stream opened")
+	For("speech").InfoContext(context.Background(), "no ids")
+
+	got := lines(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want 2", len(got))
+	}
+	if got[0]["request_id"] != "req-1" || got[0]["session_id"] != "session-1" || got[0]["channel"] != float64(1) {
+		t.Errorf("got %v, want the request and session IDs and the channel", got[0])
+	}
+	if _, found := got[1]["request_id"]; found {
+		t.Errorf("got %v, want no request ID", got[1])
+	}
+}
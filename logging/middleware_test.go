@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	recorder := NewStatusRecorder(httptest.NewRecorder())
+	if recorder.Status != http.StatusOK {
+		t.Errorf("default status %d, want 200", recorder.Status)
+	}
+	recorder.WriteHeader(http.StatusNotFound)
+	if recorder.Status != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", recorder.Status)
+	}
+	if _, _, err := recorder.Hijack(); err == nil {
+		t.Error("hijacking a writer without Hijack support should fail")
+	}
+}
+
+func TestStatusRecorderHijacksThroughNestedRecorders(t *testing.T) {
+	var outer, inner *StatusRecorder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outer = NewStatusRecorder(w)
+		inner = NewStatusRecorder(outer)
+		conn, _, err := inner.Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		conn.Close()
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if outer.Status != http.StatusSwitchingProtocols || inner.Status != http.StatusSwitchingProtocols {
+		t.Errorf("got statuses %d and %d, want 101 for both", outer.Status, inner.Status)
+	}
+}
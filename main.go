@@ -1,75 +1,278 @@
 package main
 
 import (
+	"awesomeProject2/config"
 	"awesomeProject2/handlers"
+	"awesomeProject2/logging"
+	"awesomeProject2/models"
+	"awesomeProject2/tracing"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// logger logs server startup and shutdown
+var logger = logging.For("server")
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
-	// Command line flags
-	port := flag.Int("port", 8080, "Port to listen on")
-	flag.Parse()
+	// Defaults < config file < environment variables < flags
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("loading configuration failed", logging.Err(err))
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal("invalid configuration", logging.Err(err))
+	}
+	if err := logging.Setup(os.Stderr, cfg.Log.Format, cfg.Log.Level, cfg.Log.Modules); err != nil {
+		fatal("configuring logging failed", logging.Err(err))
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.ServiceName, cfg.Tracing.OTLPEndpoint)
+	if err != nil {
+		fatal("configuring tracing failed", logging.Err(err))
+	}
+
+	// "print-config" prints the effective configuration with secrets redacted and exits
+	if len(args) > 0 {
+		if args[0] != "print-config" {
+			fatal("unknown command", "command", args[0])
+		}
+		out, err := cfg.Redacted().YAML()
+		if err != nil {
+			fatal("printing configuration failed", logging.Err(err))
+		}
+		fmt.Print(out)
+		return
+	}
+
+	// The Google client library reads its credentials from the environment
+	if cfg.Google.CredentialsFile != "" {
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", cfg.Google.CredentialsFile)
+	} else {
+		logger.Warn("Google credentials file is not configured; speech-to-text may not work")
+	}
+
+	if cfg.OpenAI.APIKey == "" {
+		logger.Warn("OpenAI API key is not configured; response generation will not work")
+	}
+	handlers.ConfigureOpenAI(cfg.OpenAI.APIKey, cfg.OpenAI.Model)
+	handlers.ConfigureLanguages(cfg.Speech.CustomerLanguage, cfg.Speech.AgentLanguage, cfg.Speech.SummaryLanguage)
 
-	// Check for required environment variables
-	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
-		log.Println("Warning: GOOGLE_APPLICATION_CREDENTIALS environment variable is not set")
-		log.Println("Speech-to-Text functionality may not work correctly")
+	// Select the analyzer used for sentiment and escalation detection
+	if cfg.Analysis.TurnAnalyzer == "llm" {
+		handlers.SetTurnAnalyzer(handlers.NewLLMAnalyzer())
+		logger.Info("using LLM turn analyzer")
 	}
 
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		log.Println("Warning: OPENAI_API_KEY environment variable is not set")
-		log.Println("Response generation functionality will not work correctly")
+	// Select the speech synthesizer used for reading responses aloud
+	if cfg.TTS.Provider == "local" {
+		handlers.SetSynthesizer(handlers.NewToneSynthesizer())
+		logger.Info("using local tone synthesizer for text-to-speech")
+	} else {
+		handlers.SetSynthesizer(handlers.NewOpenAISynthesizer(cfg.OpenAI.TTSModel, cfg.OpenAI.TTSVoice))
+	}
+
+	// Bound the text synthesized per request
+	handlers.ConfigureTTS(cfg.TTS.MaxTextLength)
+
+	// Load the per-service knowledge base used by the grammar check
+	if path := cfg.Analysis.KnowledgeBaseFile; path != "" {
+		if err := handlers.LoadKnowledgeBase(path); err != nil {
+			fatal("loading knowledge base failed", logging.Err(err))
+		}
+	}
+
+	// Load the prompt templates admins changed from the defaults
+	if path := cfg.OpenAI.PromptTemplatesFile; path != "" {
+		if err := handlers.LoadPromptTemplates(path); err != nil {
+			fatal("loading prompt templates failed", logging.Err(err))
+		}
+	}
+
+	// Load the monthly OpenAI token budgets of the teams
+	if path := cfg.OpenAI.BudgetsFile; path != "" {
+		if err := handlers.LoadBudgets(path); err != nil {
+			fatal("loading OpenAI budgets failed", logging.Err(err))
+		}
+	}
+
+	// Load the per-service phrase hints passed to the recognizer
+	if path := cfg.Speech.PhraseHintsFile; path != "" {
+		if err := handlers.LoadPhraseHints(path); err != nil {
+			fatal("loading phrase hints failed", logging.Err(err))
+		}
+	}
+
+	// Enable call recording when an archive directory is configured
+	if dir := cfg.Recording.Dir; dir != "" {
+		retention := time.Duration(cfg.Recording.RetentionDays) * 24 * time.Hour
+		if err := handlers.ConfigureRecording(dir, retention); err != nil {
+			fatal("enabling call recording failed", logging.Err(err))
+		}
+		handlers.StartRecordingRetention(time.Hour)
+	}
+
+	// Drop finished batch transcription jobs and their turns after a day
+	handlers.StartTranscriptionJobExpiry(time.Hour)
+
+	// Bound frame size and detect stalled or idle speech connections
+	handlers.ConfigureWebSocket(handlers.WebSocketLimits{
+		MaxMessageBytes: cfg.WebSocket.MaxMessageBytes,
+		WriteTimeout:    cfg.WebSocket.WriteTimeout,
+		PongTimeout:     cfg.WebSocket.PongTimeout,
+		IdleTimeout:     cfg.WebSocket.IdleTimeout,
+	})
+
+	// Keep dropped speech sessions resumable for a grace period
+	handlers.ConfigureSessionResume(cfg.Speech.ResumeGrace)
+
+	// Authenticate agents with bearer tokens or API keys
+	if secret := cfg.Auth.JWTSecret; secret != "" {
+		handlers.ConfigureJWT(secret)
+	}
+	if path := cfg.Auth.APIKeysFile; path != "" {
+		if err := handlers.LoadAPIKeys(path); err != nil {
+			fatal("loading API keys failed", logging.Err(err))
+		}
+	}
+	if !handlers.AuthEnabled() {
+		// An empty key file must not silently turn authentication off
+		if !cfg.Auth.Disabled {
+			fatal("no authentication method is usable; configure credentials or set auth.disabled")
+		}
+		logger.Warn("authentication is disabled; the Username parameter is trusted")
 	}
 
 	// Initialize router
 	router := mux.NewRouter()
+	router.Use(tracing.Middleware)
+
+	// Register routes; everything under /api requires authentication when it is configured.
+	// Every authenticated user has at least the agent role; supervisor and admin routes need more.
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(handlers.Authenticate, handlers.RequireRole(models.RoleAgent))
+
+	api.HandleFunc("/speech", handlers.HandleSpeechToText)
+	api.HandleFunc("/ws-ticket", handlers.HandleCreateTicket).Methods("POST")
+	api.HandleFunc("/generate-response", handlers.HandleGenerateResponse).Methods("POST")
+	api.HandleFunc("/translate-reply", handlers.HandleTranslateReply).Methods("POST")
+	api.HandleFunc("/check-grammar", handlers.HandleCheckGrammar).Methods("POST")
+	api.HandleFunc("/tts", handlers.HandleTextToSpeech).Methods("POST")
+	api.HandleFunc("/conversations", handlers.HandleGetConversations).Methods("GET")
+
+	api.HandleFunc("/transcriptions", handlers.HandleCreateTranscription).Methods("POST")
+	api.HandleFunc("/transcriptions/{id}", handlers.HandleGetTranscription).Methods("GET")
+	api.HandleFunc("/recordings", handlers.HandleListRecordings).Methods("GET")
+	api.HandleFunc("/recordings/{id}", handlers.HandleDownloadRecording).Methods("GET")
+	api.HandleFunc("/summary", handlers.HandleGenerateSummary).Methods("POST")
+	api.HandleFunc("/summary", handlers.HandleGetSummary).Methods("GET")
+
+	// Supervisor routes
+	api.Handle("/sessions", handlers.RequireRole(models.RoleSupervisor)(http.HandlerFunc(handlers.HandleListSessions))).Methods("GET")
 
-	// Register routes
-	router.HandleFunc("/api/speech", handlers.HandleSpeechToText)
-	router.HandleFunc("/api/generate-response", handlers.HandleGenerateResponse).Methods("POST")
+	// Admin routes
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(handlers.RequireRole(models.RoleAdmin))
+	admin.HandleFunc("/phrase-hints", handlers.HandleListPhraseHints).Methods("GET")
+	admin.HandleFunc("/phrase-hints/{service}", handlers.HandleGetPhraseHints).Methods("GET")
+	admin.HandleFunc("/phrase-hints/{service}", handlers.HandlePutPhraseHints).Methods("PUT")
+	admin.HandleFunc("/phrase-hints/{service}", handlers.HandleDeletePhraseHints).Methods("DELETE")
+	admin.HandleFunc("/knowledge-base", handlers.HandleListKnowledgeBase).Methods("GET")
+	admin.HandleFunc("/knowledge-base/{service}", handlers.HandleGetKnowledgeBase).Methods("GET")
+	admin.HandleFunc("/knowledge-base/{service}", handlers.HandlePutKnowledgeBase).Methods("PUT")
+	admin.HandleFunc("/knowledge-base/{service}", handlers.HandleDeleteKnowledgeBase).Methods("DELETE")
+	admin.HandleFunc("/prompt-templates", handlers.HandleListPromptTemplates).Methods("GET")
+	admin.HandleFunc("/prompt-templates/{name}", handlers.HandleGetPromptTemplate).Methods("GET")
+	admin.HandleFunc("/prompt-templates/{name}", handlers.HandlePutPromptTemplate).Methods("PUT")
+	admin.HandleFunc("/prompt-templates/{name}", handlers.HandleDeletePromptTemplate).Methods("DELETE")
+	admin.HandleFunc("/budgets", handlers.HandleListBudgets).Methods("GET")
+	admin.HandleFunc("/budgets/{team}", handlers.HandleGetBudget).Methods("GET")
+	admin.HandleFunc("/budgets/{team}", handlers.HandlePutBudget).Methods("PUT")
+	admin.HandleFunc("/budgets/{team}", handlers.HandleDeleteBudget).Methods("DELETE")
+	admin.HandleFunc("/budgets/{team}/usage", handlers.HandleGetBudgetUsage).Methods("GET")
 
-	// Health check endpoint
+	// Health check endpoint, kept for existing probes; it only reports that the process runs
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "Service is healthy")
 	})
 
-	// Configure CORS - allow everything for local testing
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"}, // Allow all origins
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"*"}, // Allow all headers
-		ExposedHeaders:   []string{"*"}, // Expose all headers
-		AllowCredentials: true,
-		MaxAge:           86400, // 24 hours
-	})
+	// Liveness and readiness probes; readiness checks credentials, OpenAI and the conversation store.
+	// They stay unauthenticated for load balancers and only report the status of each component.
+	router.HandleFunc("/health/live", handlers.HandleLiveness).Methods("GET")
+	router.HandleFunc("/health/ready", handlers.HandleReadiness).Methods("GET")
 
-	// Create HTTP server
-	handler := c.Handler(router)
-
-	// Add middleware to add CORS headers to all responses for WebSocket as well
-	handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Add CORS headers manually for WebSocket protocol upgrade requests
-		if websocketRequest := r.Header.Get("Upgrade") == "websocket"; websocketRequest {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-			w.Header().Set("Access-Control-Allow-Headers", "*")
-			w.Header().Set("Access-Control-Expose-Headers", "*")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-		}
-		c.Handler(router).ServeHTTP(w, r)
-	})
+	// Prometheus metrics expose call volume and error rates, so scrapers authenticate as an admin,
+	// e.g. with an API key sent as bearer token
+	router.Handle("/metrics", handlers.Authenticate(handlers.RequireRole(models.RoleAdmin)(handlers.MetricsHandler())))
+
+	// Browsers may only call the API from the configured origins
+	policy := handlers.OriginPolicy{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+	}
+	if err := handlers.ConfigureOriginPolicy(policy); err != nil {
+		fatal("invalid origin policy", logging.Err(err))
+	}
+
+	// Create HTTP server; every request gets an ID that is added to its log lines
+	handler := logging.Middleware(policy.CORS(router))
 
 	// Start server
-	serverAddr := fmt.Sprintf(":%d", *port)
-	log.Printf("Server starting on %s\n", serverAddr)
-	if err := http.ListenAndServe(serverAddr, handler); err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler: handler,
+	}
+	go func() {
+		logger.Info("server starting", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("starting server failed", logging.Err(err))
+		}
+	}()
+
+	// Wait for SIGINT or SIGTERM, then stop accepting connections and let live calls wrap up
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	logger.Info("shutting down, draining speech sessions", "drain_timeout", cfg.Server.DrainTimeout.String())
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	defer cancel()
+
+	// Shutdown does not wait for WebSocket connections, which are drained separately
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(drainCtx)
+	}()
+	if err := handlers.DrainSpeechSessions(drainCtx); err != nil {
+		logger.Warn("draining speech sessions failed", logging.Err(err))
+	}
+	if err := <-shutdownErr; err != nil {
+		logger.Warn("HTTP server shutdown failed", logging.Err(err))
+	}
+
+	// Export the spans of the drained sessions before exiting
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Warn("flushing traces failed", logging.Err(err))
 	}
+	logger.Info("server stopped")
 }
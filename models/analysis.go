@@ -0,0 +1,25 @@
+package models
+
+// Sentiment values reported for a conversation turn
+const (
+	SentimentPositive = "positive"
+	SentimentNeutral  = "neutral"
+	SentimentNegative = "negative"
+)
+
+// Urgency values reported for a conversation turn
+const (
+	UrgencyLow    = "low"
+	UrgencyMedium = "medium"
+	UrgencyHigh   = "high"
+)
+
+// TurnAnalysis holds the sentiment and urgency detected for a single customer turn
+type TurnAnalysis struct {
+	Sentiment          string   `json:"sentiment"`
+	Score              float64  `json:"score"`
+	Urgency            string   `json:"urgency"`
+	EscalationKeywords []string `json:"escalation_keywords"`
+	Escalate           bool     `json:"escalate"`
+	Source             string   `json:"source"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// OpenAIBudget limits the chat completion tokens a team may use per calendar month
+type OpenAIBudget struct {
+	MonthlyTokens int64 `json:"monthly_tokens"`
+}
+
+// OpenAIUsage reports the tokens a team used in the current month against its budget
+type OpenAIUsage struct {
+	Team          string `json:"team"`
+	Month         string `json:"month"`
+	UsedTokens    int64  `json:"used_tokens"`
+	MonthlyTokens int64  `json:"monthly_tokens,omitempty"`
+}
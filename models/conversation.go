@@ -1,7 +1,39 @@
 package models
 
+import "encoding/json"
+
+// Speakers that can be attached to a transcribed turn
+const (
+	SpeakerCustomer = "customer"
+	SpeakerAgent    = "agent"
+)
+
 // Conversation represents a single conversation entry
 type Conversation struct {
-	Question string `json:"question"`
-	Answer   string `json:"answer"`
+	// SessionID is the speech session or transcription job that produced the entry
+	SessionID string `json:"session_id,omitempty"`
+	// Team is the team of the agent whose call produced the entry
+	Team string `json:"team,omitempty"`
+	// Service and Issue are the call context the session was opened with
+	Service  string        `json:"service,omitempty"`
+	Issue    string        `json:"issue,omitempty"`
+	Question string        `json:"question"`
+	Answer   string        `json:"answer"`
+	Analysis *TurnAnalysis `json:"analysis,omitempty"`
+	Turns    []Turn        `json:"turns,omitempty"`
+	// Suggestions are the responses generated for the customer's question
+	Suggestions json.RawMessage `json:"suggestions,omitempty"`
+}
+
+// Turn represents a single finalized transcript segment tagged by speaker
+type Turn struct {
+	Speaker    string  `json:"speaker"`
+	SpeakerTag int32   `json:"speaker_tag,omitempty"`
+	Text       string  `json:"text"`
+	Language   string  `json:"language"`
+	Channel    int     `json:"channel"`
+	Confidence float32 `json:"confidence"`
+	// Words and Alternatives let clients highlight uncertain words and align recordings to text
+	Words        []Word        `json:"words,omitempty"`
+	Alternatives []Alternative `json:"alternatives,omitempty"`
 }
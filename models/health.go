@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Readiness states of the service and its components
+const (
+	HealthOK       = "ok"
+	HealthDown     = "down"
+	HealthReady    = "ready"
+	HealthNotReady = "not_ready"
+	HealthDraining = "draining"
+)
+
+// ComponentHealth is the result of checking one dependency
+type ComponentHealth struct {
+	Status string `json:"status"`
+	// Error is only logged; the readiness endpoint is public and errors may name credential paths
+	Error     string    `json:"-"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Cached is set when the result comes from an earlier probe
+	Cached bool `json:"cached,omitempty"`
+}
+
+// ReadinessReport is returned by the readiness endpoint
+type ReadinessReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// Roles, from least to most privileged
+const (
+	RoleAgent      = "agent"
+	RoleSupervisor = "supervisor"
+	RoleAdmin      = "admin"
+)
+
+// Identity is the authenticated user a request acts for
+type Identity struct {
+	Username string `json:"username"`
+	// Role defaults to agent when the credential does not name one
+	Role string `json:"role,omitempty"`
+	// Team groups agents under their supervisors
+	Team string `json:"team,omitempty"`
+	// Method is how the user authenticated: jwt, api_key or ticket
+	Method string `json:"method"`
+}
@@ -0,0 +1,7 @@
+package models
+
+// PhraseHint is a word or phrase the recognizer should favor, with an optional boost
+type PhraseHint struct {
+	Phrase string  `json:"phrase"`
+	Boost  float32 `json:"boost"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Recording describes an archived call recording
+type Recording struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Team       string    `json:"team,omitempty"`
+	Path       string    `json:"-"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Bytes      int64     `json:"bytes"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// SessionInfo describes a live speech session for monitoring
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Team      string    `json:"team,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	State     string    `json:"state"`
+	Channels  int       `json:"channels"`
+	Turns     int       `json:"turns"`
+	StartedAt time.Time `json:"started_at"`
+}
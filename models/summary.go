@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// SummaryContent holds the structured parts of a call summary in one language
+type SummaryContent struct {
+	Problem          string   `json:"problem"`
+	Steps            []string `json:"steps"`
+	Resolution       string   `json:"resolution"`
+	FollowUpActions  []string `json:"follow_up_actions"`
+	SentimentSummary string   `json:"sentiment_summary"`
+}
+
+// SentimentTrend describes how the customer's mood developed during the call
+type SentimentTrend struct {
+	Direction  string    `json:"direction"`
+	Sentiments []string  `json:"sentiments"`
+	Scores     []float64 `json:"scores"`
+}
+
+// CallSummary represents the end-of-call summary of one speech session or transcription job
+type CallSummary struct {
+	SessionID      string         `json:"session_id"`
+	Username       string         `json:"username"`
+	Team           string         `json:"team,omitempty"`
+	Service        string         `json:"service"`
+	Issue          string         `json:"issue"`
+	Language       string         `json:"language"`
+	German         SummaryContent `json:"german"`
+	Translated     SummaryContent `json:"translated"`
+	SentimentTrend SentimentTrend `json:"sentiment_trend"`
+	TurnCount      int            `json:"turn_count"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Transcription job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// TranscriptionJob tracks the batch transcription of an uploaded or archived audio file
+type TranscriptionJob struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Team     string `json:"team,omitempty"`
+	Source   string `json:"source"`
+	Filename string `json:"filename,omitempty"`
+	Language string `json:"language"`
+	// Service and Issue are the call context given when the job was created
+	Service   string    `json:"service,omitempty"`
+	Issue     string    `json:"issue,omitempty"`
+	Status    string    `json:"status"`
+	Progress  int       `json:"progress"`
+	Error     string    `json:"error,omitempty"`
+	Turns     []Turn    `json:"turns,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
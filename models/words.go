@@ -0,0 +1,17 @@
+package models
+
+// Word represents a single recognized word with its timing relative to the start of the stream
+type Word struct {
+	Word          string  `json:"word"`
+	StartMs       int64   `json:"start_ms"`
+	EndMs         int64   `json:"end_ms"`
+	Confidence    float32 `json:"confidence"`
+	LowConfidence bool    `json:"low_confidence"`
+	SpeakerTag    int32   `json:"speaker_tag,omitempty"`
+}
+
+// Alternative represents one of the top recognition hypotheses for a turn
+type Alternative struct {
+	Transcript string  `json:"transcript"`
+	Confidence float32 `json:"confidence"`
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"awesomeProject2/logging"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogCarriesSpan(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	provider := sdktrace.NewTracerProvider()
+	otel.SetTracerProvider(provider)
+	var buf bytes.Buffer
+	if err := logging.Setup(&buf, "json", "info", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+		logging.Setup(os.Stderr, "json", "info", nil)
+	})
+
+	// The logging middleware wraps the router like in main, so the span starts inside it
+	var span trace.SpanContext
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/api/conversations", func(w http.ResponseWriter, r *http.Request) {
+		span = trace.SpanContextFromContext(r.Context())
+	})
+	logging.Middleware(router).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/conversations", nil))
+
+	if !span.IsValid() {
+		t.Fatal("the handler saw no span")
+	}
+	var access map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %s", line)
+		}
+		if entry["msg"] == "request completed" {
+			access = entry
+		}
+	}
+	if access == nil {
+		t.Fatalf("no access line in %s", buf.String())
+	}
+	if access["trace_id"] != span.TraceID().String() || access["span_id"] != span.SpanID().String() {
+		t.Errorf("got access line %v, want trace %s and span %s", access, span.TraceID(), span.SpanID())
+	}
+}